@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"prune_backups/retention"
+	"prune_backups/sandbox"
+	"prune_backups/vfs"
+)
+
+type CheckCmd struct {
+	WarningAge   int         `help:"OPTIONAL. Report WARNING if the newest snapshot was made more than this number of hours ago. 0 disables the check." default:"2" group:"Thresholds"`
+	CriticalAge  int         `help:"OPTIONAL. Report CRITICAL if the newest snapshot was made more than this number of hours ago. 0 disables the check." default:"26" group:"Thresholds"`
+	WarningGaps  int         `help:"OPTIONAL. Report WARNING if at least this number of slots of the --gap-tiers between the oldest and the newest snapshot are empty. 0 disables the check." default:"1" group:"Thresholds"`
+	CriticalGaps int         `help:"OPTIONAL. Like --warning-gaps for CRITICAL." default:"0" group:"Thresholds"`
+	GapTiers     []string    `help:"OPTIONAL. The tiers whose empty slots count as gaps: hourly, daily or monthly. Leave out hourly if backups are made once a day." enum:"hourly,daily,monthly" default:"hourly,daily" group:"Thresholds"`
+	Marker       string      `name:"complete-marker" help:"OPTIONAL. A file that a backup tool creates in a snapshot directory when it is complete, e.g. .complete. Snapshots without it are not counted." group:"Completeness"`
+	Unmodified   int         `name:"unmodified-for" help:"OPTIONAL. Do not count snapshots modified within this number of minutes. 0 disables the check." default:"0" group:"Completeness"`
+	Files        bool        `help:"OPTIONAL. Check regular files like database dumps or archives (e.g. db-2024-06-17_09-49.sql.zst) instead of directories." default:"false" group:"Files"`
+	Match        string      `help:"OPTIONAL. Only consider files matching this glob pattern, e.g. '*.tar.gz'. Requires --files." group:"Files"`
+	Sidecar      []string    `help:"OPTIONAL. Extensions of sidecar files that are not snapshots themselves, see the from command." default:".sha256,.sig" group:"Files"`
+	Sandbox      bool        `help:"OPTIONAL. On Linux, restrict the process to reading <dir> with Landlock before reading anything." default:"true" negatable:""`
+	Verbosity    int         `help:"OPTIONAL. Set verbosity of the diagnostics written to stderr. 0 - mute, 1 - some, 2 - a lot." default:"0" short:"v"`
+	S3           S3Options   `embed:"" prefix:"s3-" group:"S3"`
+	SFTP         SFTPOptions `embed:"" prefix:"sftp-" group:"SFTP"`
+	Dir          string      `arg:"" help:"REQUIRED. The directory of the snapshots, or an s3://bucket/prefix or sftp://user@host/path location." required:"true"`
+}
+
+// The states of Nagios plugins, which are also their exit codes.
+const (
+	nagiosOK ExitStatus = iota
+	nagiosWarning
+	nagiosCritical
+	nagiosUnknown
+)
+
+var nagiosStates = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+func (c *CheckCmd) Run(cli *CLI) error {
+	if c.Match != "" && !c.Files {
+		return configErrorf("match flag requires the files flag")
+	}
+	if c.WarningAge < 0 || c.CriticalAge < 0 || c.WarningGaps < 0 || c.CriticalGaps < 0 {
+		return configErrorf("the thresholds must not be negative")
+	}
+	complete, err := completeness(c.Marker, c.Unmodified, c.Files)
+	if err != nil {
+		return err
+	}
+	thresholds := checkThresholds{
+		warningAge:   time.Duration(c.WarningAge) * time.Hour,
+		criticalAge:  time.Duration(c.CriticalAge) * time.Hour,
+		warningGaps:  c.WarningGaps,
+		criticalGaps: c.CriticalGaps,
+	}
+	for _, tier := range c.GapTiers {
+		thresholds.tiers = append(thresholds.tiers, retention.Tier(tier))
+	}
+
+	result, err := c.check(complete, thresholds)
+	if err != nil {
+		fmt.Println("BACKUPS " + nagiosStates[nagiosUnknown] + " - " + firstLine(err.Error()))
+		return nagiosUnknown
+	}
+	status := thresholds.status(result)
+	fmt.Print(result.format(status, thresholds))
+	if status == nagiosOK {
+		return nil
+	}
+	return status
+}
+
+// check opens c.Dir and checks the snapshots in it.
+func (c *CheckCmd) check(complete retention.Completeness, t checkThresholds) (checkResult, error) {
+	fsys, dir, err := openLocation(c.Dir, c.S3, c.SFTP)
+	if err != nil {
+		return checkResult{}, err
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	if _, local := fsys.(vfs.OS); local && c.Sandbox {
+		if err := restrict(c.Verbosity, sandbox.Path{Name: dir, Access: sandbox.ReadOnly}); err != nil {
+			return checkResult{}, err
+		}
+	}
+	return checkSnapshots(pruneOptions{
+		fsys:      fsys,
+		dir:       dir,
+		now:       time.Now(),
+		verbosity: c.Verbosity,
+		files:     c.Files,
+		match:     c.Match,
+		sidecars:  c.Sidecar,
+		complete:  complete,
+	}, t.tiers)
+}
+
+// checkThresholds decide on the state reported by check.
+type checkThresholds struct {
+	warningAge, criticalAge   time.Duration // 0 disables the check
+	warningGaps, criticalGaps int           // 0 disables the check
+	tiers                     []retention.Tier
+}
+
+// checkResult is what check found out about the snapshots.
+type checkResult struct {
+	newest    string        // the newest complete snapshot; empty if there is none
+	age       time.Duration // how long ago newest was made
+	snapshots int           // the number of complete snapshots
+	// the empty slots of the checked tiers between the oldest and the newest
+	// snapshot, newest first
+	gaps []retention.Slot
+}
+
+// checkSnapshots finds the newest complete snapshot in o.dir and the gaps in
+// the slots of tiers at o.now.
+func checkSnapshots(o pruneOptions, tiers []retention.Tier) (checkResult, error) {
+	var result checkResult
+	names, err := listCandidates(o)
+	if err != nil {
+		return result, err
+	}
+	incomplete, err := findIncomplete(o, names)
+	if err != nil {
+		return result, err
+	}
+	policy := retention.DefaultPolicy()
+	if len(incomplete) > 0 {
+		policy.Incomplete = retention.IsListed(incomplete)
+	}
+	var slots []retention.Slot
+	var decisions retention.Decisions
+	if o.files {
+		slots, decisions = policy.CoverageFiles(o.now, names), policy.PlanFiles(o.now, names)
+	} else {
+		slots, decisions = policy.Coverage(o.now, names), policy.Plan(o.now, names)
+	}
+
+	// the complete snapshots in date format
+	valid := slices.Concat(decisions.Keep, decisions.Prune)
+	newest, date, ok := retention.Newest(valid, o.now.Location())
+	if !ok {
+		return result, nil
+	}
+	result.newest, result.age, result.snapshots = newest, o.now.Sub(date), len(valid)
+
+	first, last := -1, -1
+	for i, slot := range slots {
+		if slot.Filled() {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	for i := first + 1; i < last; i++ {
+		if !slots[i].Filled() && slices.Contains(tiers, slots[i].Tier) {
+			result.gaps = append(result.gaps, slots[i])
+		}
+	}
+	return result, nil
+}
+
+// status returns the state of r according to t.
+func (t checkThresholds) status(r checkResult) ExitStatus {
+	exceeds := func(value, threshold int) bool {
+		return threshold > 0 && value >= threshold
+	}
+	older := func(age, threshold time.Duration) bool {
+		return threshold > 0 && age > threshold
+	}
+	switch {
+	case r.newest == "":
+		return nagiosCritical
+	case older(r.age, t.criticalAge), exceeds(len(r.gaps), t.criticalGaps):
+		return nagiosCritical
+	case older(r.age, t.warningAge), exceeds(len(r.gaps), t.warningGaps):
+		return nagiosWarning
+	default:
+		return nagiosOK
+	}
+}
+
+// format returns the output of the check in the format of Nagios plugins:
+// a status line with performance data, followed by the gaps.
+func (r checkResult) format(status ExitStatus, t checkThresholds) string {
+	var b strings.Builder
+	b.WriteString("BACKUPS " + nagiosStates[status] + " - ")
+	tiers := make([]string, 0, len(t.tiers))
+	for _, tier := range t.tiers {
+		tiers = append(tiers, string(tier))
+	}
+	if r.newest == "" {
+		b.WriteString("there is no snapshot")
+	} else {
+		fmt.Fprintf(&b, "the newest snapshot %s was made %s ago", r.newest, r.age.Round(time.Second))
+		if len(r.gaps) == 0 {
+			fmt.Fprintf(&b, ", no gaps in the %s slots", strings.Join(tiers, " and "))
+		} else {
+			fmt.Fprintf(&b, ", %d gaps in the %s slots", len(r.gaps), strings.Join(tiers, " and "))
+		}
+	}
+
+	// 'label'=value[UOM];[warn];[crit];[min];[max], where warn and crit
+	// are exceeded by greater values
+	threshold := func(value int, enabled bool) string {
+		if !enabled {
+			return ""
+		}
+		return fmt.Sprint(value)
+	}
+	age := "U"
+	if r.newest != "" {
+		age = fmt.Sprintf("%ds", int64(r.age.Seconds()))
+	}
+	fmt.Fprintf(&b, " | age=%s;%s;%s;0; gaps=%d;%s;%s;0; snapshots=%d;;;0;\n",
+		age,
+		threshold(int(t.warningAge.Seconds()), t.warningAge > 0), threshold(int(t.criticalAge.Seconds()), t.criticalAge > 0),
+		len(r.gaps),
+		threshold(t.warningGaps-1, t.warningGaps > 0), threshold(t.criticalGaps-1, t.criticalGaps > 0),
+		r.snapshots)
+	for _, gap := range r.gaps {
+		fmt.Fprintf(&b, "no %s snapshot in %s\n", gap.Tier, gap.Prefix)
+	}
+	return b.String()
+}
+
+// firstLine returns s up to its first line break, as Nagios shows only the
+// first line in overviews.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
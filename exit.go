@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"prune_backups/retention"
+)
+
+// The exit codes of prune_backups, see the README.
+const (
+	ExitOK = 0
+	// ExitFailure is returned for errors not covered by the other codes.
+	ExitFailure = 1
+	// ExitPartial is returned if some snapshots or trash entries could not be
+	// moved, deleted, purged or restored while the others were.
+	ExitPartial = 2
+	// ExitConfig is returned for invalid flags or arguments, and for
+	// features not supported on this OS. Nothing was touched.
+	ExitConfig = 3
+	// ExitLocked is returned if another run holds the lock of the directory.
+	ExitLocked = 4
+	// ExitSafety is returned if a run refused to prune as doing so could
+	// lose data, e.g. because a pre-flight check failed, the plan drifted or
+	// an earlier run was interrupted.
+	ExitSafety = 5
+	// ExitStale is returned if the newest snapshot is older than
+	// --stale-after.
+	ExitStale = 6
+	// ExitAccess is returned if the pruned directory could not be read or
+	// the trash directory could not be created.
+	ExitAccess = 7
+)
+
+// ConfigError reports invalid flags or arguments.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// configErrorf returns a *ConfigError with the formatted message.
+func configErrorf(format string, args ...any) error {
+	return &ConfigError{Err: fmt.Errorf(format, args...)}
+}
+
+// SafetyError reports that a run refused to prune as doing so could lose
+// data, e.g. because the snapshots changed since the plan was computed.
+type SafetyError struct {
+	Err error
+}
+
+func (e *SafetyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *SafetyError) Unwrap() error {
+	return e.Err
+}
+
+// ReadDirError reports that the directory of the snapshots could not be
+// listed.
+type ReadDirError struct {
+	Dir string
+	Err error
+}
+
+func (e *ReadDirError) Error() string {
+	return fmt.Sprintf("Could not read pruning directory: %s", e.Err)
+}
+
+func (e *ReadDirError) Unwrap() error {
+	return e.Err
+}
+
+// ExitStatus is returned by commands that report their result by their exit
+// code, e.g. check. main exits with it without logging an error.
+type ExitStatus int
+
+func (e ExitStatus) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// exitCode returns the exit code for the error a command returned. If it
+// failed for several reasons, the one that stopped it earliest wins: config,
+// locked, safety, access, partial and stale, in this order.
+func exitCode(err error) int {
+	var (
+		status        ExitStatus
+		config        *ConfigError
+		locked        *retention.LockedError
+		safety        *SafetyError
+		preflight     *retention.PreflightError
+		worldWritable *retention.WorldWritableError
+		crossDevice   *retention.CrossDeviceError
+		readDir       *ReadDirError
+		trash         *retention.TrashError
+		partial       *retention.PartialError
+		stale         *retention.StaleError
+	)
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &status):
+		return int(status)
+	case errors.As(err, &config), errors.Is(err, errors.ErrUnsupported):
+		return ExitConfig
+	case errors.As(err, &locked):
+		return ExitLocked
+	case errors.As(err, &safety), errors.As(err, &preflight), errors.As(err, &worldWritable), errors.As(err, &crossDevice):
+		return ExitSafety
+	case errors.As(err, &readDir), errors.As(err, &trash):
+		return ExitAccess
+	case errors.As(err, &partial):
+		return ExitPartial
+	case errors.As(err, &stale):
+		return ExitStale
+	default:
+		return ExitFailure
+	}
+}
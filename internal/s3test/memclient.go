@@ -0,0 +1,180 @@
+// Package s3test provides an in-memory S3 client for tests of code using
+// prune_backups/vfs/s3fs.
+package s3test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// MemClient is an in-process stand-in for an S3-compatible service that
+// implements s3fs.Client. It holds the objects of any number of buckets in
+// memory. Fault, if set, is called before every operation and can be used
+// to inject errors.
+type MemClient struct {
+	// Fault is called with the operation ("list", "copy", "remove", "get"
+	// or "put") and the key it works on. A non-nil result is returned instead of
+	// performing the operation.
+	Fault func(op, key string) error
+
+	mutex   sync.Mutex
+	objects map[string]map[string]memObject // bucket -> key -> object
+}
+
+type memObject struct {
+	size int64
+	data []byte // nil for objects created by Put
+}
+
+// NewMemClient returns a MemClient without any objects.
+func NewMemClient() *MemClient {
+	return &MemClient{objects: make(map[string]map[string]memObject)}
+}
+
+// Put stores an object of the given size without any content.
+func (c *MemClient) Put(bucket, key string, size int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.put(bucket, key, memObject{size: size})
+}
+
+func (c *MemClient) put(bucket, key string, obj memObject) {
+	if c.objects[bucket] == nil {
+		c.objects[bucket] = make(map[string]memObject)
+	}
+	c.objects[bucket][key] = obj
+}
+
+// Keys returns the keys of all objects in bucket, sorted.
+func (c *MemClient) Keys(bucket string) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result := []string{}
+	for k := range c.objects[bucket] {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func (c *MemClient) fault(op, key string) error {
+	if c.Fault == nil {
+		return nil
+	}
+	return c.Fault(op, key)
+}
+
+func (c *MemClient) ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	ch := make(chan minio.ObjectInfo, 1)
+	if err := c.fault("list", opts.Prefix); err != nil {
+		ch <- minio.ObjectInfo{Err: err}
+		close(ch)
+		return ch
+	}
+
+	c.mutex.Lock()
+	var result []minio.ObjectInfo
+	seen := make(map[string]bool)
+	for k, obj := range c.objects[bucketName] {
+		if !strings.HasPrefix(k, opts.Prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(k, opts.Prefix)
+		if i := strings.Index(rest, "/"); !opts.Recursive && i >= 0 {
+			common := opts.Prefix + rest[:i+1]
+			if !seen[common] {
+				seen[common] = true
+				result = append(result, minio.ObjectInfo{Key: common})
+			}
+			continue
+		}
+		result = append(result, minio.ObjectInfo{Key: k, Size: obj.size, LastModified: time.Unix(0, 0)})
+	}
+	c.mutex.Unlock()
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	go func() {
+		defer close(ch)
+		for _, obj := range result {
+			select {
+			case ch <- obj:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func (c *MemClient) ComposeObject(ctx context.Context, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) (minio.UploadInfo, error) {
+	if err := c.fault("copy", dst.Object); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var composed memObject
+	for _, src := range srcs {
+		obj, ok := c.objects[src.Bucket][src.Object]
+		if !ok {
+			return minio.UploadInfo{}, noSuchKey(src.Bucket, src.Object)
+		}
+		composed.size += obj.size
+		if obj.data != nil {
+			composed.data = append(composed.data, obj.data...)
+		}
+	}
+	c.put(dst.Bucket, dst.Object, composed)
+	return minio.UploadInfo{Bucket: dst.Bucket, Key: dst.Object, Size: composed.size}, nil
+}
+
+func (c *MemClient) RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error {
+	if err := c.fault("remove", objectName); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.objects[bucketName], objectName)
+	return nil
+}
+
+func (c *MemClient) GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	if err := c.fault("get", objectName); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	obj, ok := c.objects[bucketName][objectName]
+	if !ok {
+		return nil, noSuchKey(bucketName, objectName)
+	}
+	data := obj.data
+	if data == nil {
+		data = make([]byte, obj.size)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *MemClient) PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	if err := c.fault("put", objectName); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.put(bucketName, objectName, memObject{size: int64(len(data)), data: data})
+	return minio.UploadInfo{Bucket: bucketName, Key: objectName, Size: int64(len(data))}, nil
+}
+
+func noSuchKey(bucket, key string) error {
+	return minio.ErrorResponse{Code: "NoSuchKey", Key: key, BucketName: bucket, StatusCode: 404}
+}
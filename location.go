@@ -0,0 +1,64 @@
+package main
+
+import (
+	"prune_backups/vfs"
+	"prune_backups/vfs/s3fs"
+	"prune_backups/vfs/sftpfs"
+)
+
+type S3Options struct {
+	Endpoint string `help:"OPTIONAL. The endpoint (host[:port]) of the S3-compatible service used for s3:// locations. Credentials are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY." default:"s3.amazonaws.com" env:"S3_ENDPOINT"`
+	Region   string `help:"OPTIONAL. The region of the bucket for s3:// locations." env:"AWS_REGION"`
+	Insecure bool   `help:"OPTIONAL. Use plain HTTP instead of HTTPS for s3:// locations, e.g. for a local MinIO instance." default:"false"`
+}
+
+type SFTPOptions struct {
+	Identity   []string `help:"OPTIONAL. Private key file(s) used for sftp:// locations, in addition to a running ssh-agent. Defaults to ~/.ssh/id_ed25519, ~/.ssh/id_ecdsa and ~/.ssh/id_rsa." type:"path"`
+	KnownHosts string   `help:"OPTIONAL. The known_hosts file used to verify the host key of sftp:// locations. Defaults to ~/.ssh/known_hosts." type:"path"`
+}
+
+// dialOptions fills in the OpenSSH defaults for everything not given.
+func (o SFTPOptions) dialOptions() sftpfs.DialOptions {
+	opts := sftpfs.DefaultDialOptions()
+	if len(o.Identity) > 0 {
+		opts.IdentityFiles = o.Identity
+	}
+	if o.KnownHosts != "" {
+		opts.KnownHostsFile = o.KnownHosts
+	}
+	return opts
+}
+
+// openLocation returns the filesystem and the directory name within it for
+// location, which is either a local directory, an s3://bucket/prefix URL or
+// an sftp://user@host/path URL. The filesystem should be closed by the caller
+// if it implements io.Closer.
+func openLocation(location string, s3opts S3Options, sftpOpts SFTPOptions) (vfs.FS, string, error) {
+	loc, isSFTP, err := sftpfs.ParseURL(location)
+	if err != nil {
+		return nil, "", &ConfigError{Err: err}
+	}
+	if isSFTP {
+		fsys, err := sftpfs.Dial(loc, sftpOpts.dialOptions())
+		if err != nil {
+			return nil, "", err
+		}
+		return fsys, loc.Path, nil
+	}
+
+	bucket, prefix, isS3, err := s3fs.ParseURL(location)
+	if err != nil {
+		return nil, "", &ConfigError{Err: err}
+	}
+	if !isS3 {
+		return vfs.OS{}, location, nil
+	}
+	if s3opts.Endpoint == "" {
+		return nil, "", configErrorf("no S3 endpoint given")
+	}
+	client, err := s3fs.NewClient(s3opts.Endpoint, s3opts.Region, !s3opts.Insecure)
+	if err != nil {
+		return nil, "", err
+	}
+	return s3fs.New(client, bucket), prefix, nil
+}
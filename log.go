@@ -0,0 +1,56 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// LogFlags are the global flags selecting how diagnostics are written to
+// stderr. Results, e.g. statistics and the snapshots a dry run would prune,
+// are written to stdout.
+type LogFlags struct {
+	Format string `help:"OPTIONAL. The format of the diagnostics written to stderr: text or json." enum:"text,json" default:"text"`
+	Level  string `help:"OPTIONAL. The minimum level of the diagnostics written to stderr: debug, info, warn or error. By default, it follows --verbosity: 0 - warn, 1 - info, 2 - debug."`
+}
+
+// logFlags holds the global flags of the current run.
+var logFlags = LogFlags{Format: "text"}
+
+// apply makes f the flags used by newLogger.
+func (f LogFlags) apply() error {
+	if f.Level != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(f.Level)); err != nil {
+			return configErrorf("invalid log level %q, expected debug, info, warn or error", f.Level)
+		}
+	}
+	logFlags = f
+	return nil
+}
+
+// newLogger returns a logger for the diagnostics of a command run with
+// verbosity, unless --log-level overrides it. Each record has an "event"
+// key naming what happened; the keys "dir", "src", "dst" and "err" hold the
+// directory worked on, the source and destination of moves, and errors.
+func newLogger(verbosity int) *slog.Logger {
+	level := slog.LevelInfo
+	switch {
+	case verbosity <= 0:
+		level = slog.LevelWarn
+	case verbosity > 1:
+		level = slog.LevelDebug
+	}
+	if logFlags.Level != "" {
+		_ = level.UnmarshalText([]byte(logFlags.Level)) // checked by apply
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if logFlags.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// log returns the logger for the diagnostics of the run described by o.
+func (o pruneOptions) log() *slog.Logger {
+	return newLogger(o.verbosity).With("dir", o.dir)
+}
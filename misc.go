@@ -3,8 +3,6 @@ package main
 import (
 	"fmt"
 	"runtime/debug"
-	"strings"
-	"time"
 )
 
 var commitInfo = func() string {
@@ -17,70 +15,6 @@ var commitInfo = func() string {
 	return "untagged"
 }()
 
-func getAllButFirstMatchingPrefix(from []string, prefix string) []string {
-	var result = []string{} // make sure it's not nil
-	var first = true
-	for _, s := range from {
-		if strings.HasPrefix(s, prefix) {
-			if first {
-				first = false
-			} else {
-				result = append(result, s)
-			}
-		}
-	}
-	return result
-}
-
-func getAnyMatchingAnyPrefixes(searchIn []string, prefixes []string) bool {
-	for _, s := range searchIn {
-		for _, prefix := range prefixes {
-			if strings.HasPrefix(s, prefix) {
-				return true
-			}
-		}
-	}
-	return false
-}
-
-func prevMonth(year *int, month *int) {
-	*month--
-	if *month <= 0 {
-		*month = 12
-		*year--
-	}
-}
-
-func toDateStr(year int, month int) string {
-	return fmt.Sprintf("%04d-%02d", year, month)
-}
-
-func toDateStr3(year int, month int, day int) string {
-	return fmt.Sprintf("%04d-%02d-%02d", year, month, day)
-}
-
-func twoDigit(i int) string {
-	return fmt.Sprintf("%02d", i)
-}
-
-func daysInMonth(year int, month time.Month) int {
-	return getUltimo(year, month).Day()
-}
-
-func getUltimo(year int, month time.Month) time.Time {
-	// Start with the first day of the next month
-	t := time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
-	// Subtract a day to get the last day of the original month
-	t = t.AddDate(0, 0, -1)
-	return t
-}
-
-func get15thOfMonthBefore(current_time time.Time) time.Time {
-	t := time.Date(current_time.Year(), current_time.Month(), 15, 0, 0, 0, 0, time.UTC)
-	t = t.AddDate(0, -1, 0)
-	return t
-}
-
 func formatSI(b uint64) string {
 	const basis = 1000
 	if b < basis {
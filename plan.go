@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"prune_backups/retention"
+	"prune_backups/stats"
+	"prune_backups/vfs"
+)
+
+type PlanCmd struct {
+	Out        string      `help:"REQUIRED. The file the plan is written to, e.g. plan.json." required:"true" short:"o"`
+	To         string      `help:"OPTIONAL. The name of the directory where the pruned directories will be moved, relative to <dir>, or an absolute path." default:"to_delete" short:"t"`
+	Delete     bool        `help:"OPTIONAL. Plan to delete the pruned directories instead of moving them." default:"false"`
+	Marker     string      `name:"complete-marker" help:"OPTIONAL. A file that a backup tool creates in a snapshot directory when it is complete, e.g. .complete. Snapshots without it are considered incomplete: they neither displace a complete snapshot in their time slot nor are pruned." group:"Completeness"`
+	Unmodified int         `name:"unmodified-for" help:"OPTIONAL. Consider snapshots modified within this number of minutes incomplete. For directories, the latest modification of any file counts; only snapshots of the last day are walked. 0 disables the check." default:"0" group:"Completeness"`
+	MinFiles   int         `help:"OPTIONAL. Snapshots with fewer files look like failed backups and do not win their time slot if there are other snapshots in it. 0 disables the check." default:"0" group:"Plausibility"`
+	MinSize    uint64      `help:"OPTIONAL. Like --min-files for the total size of the files in bytes." default:"0" group:"Plausibility"`
+	MinRatio   float64     `help:"OPTIONAL. Like --min-files for the size relative to the next older snapshot, e.g. 0.5 for snapshots less than half as big. 0 disables the check." default:"0" group:"Plausibility"`
+	Files      bool        `help:"OPTIONAL. Prune regular files like database dumps or archives (e.g. db-2024-06-17_09-49.sql.zst) instead of directories." default:"false" group:"Files"`
+	Match      string      `help:"OPTIONAL. Only consider files matching this glob pattern, e.g. '*.tar.gz'. Requires --files." group:"Files"`
+	Sidecar    []string    `help:"OPTIONAL. Extensions of sidecar files that are moved along with a pruned snapshot if they share its name, e.g. db-2024-06-17_09-49.sql.zst.sha256 or db-2024-06-17_09-49.sig." default:".sha256,.sig" group:"Files"`
+	Verbosity  int         `help:"OPTIONAL. Set verbosity. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
+	S3         S3Options   `embed:"" prefix:"s3-" group:"S3"`
+	SFTP       SFTPOptions `embed:"" prefix:"sftp-" group:"SFTP"`
+	Dir        string      `arg:"" help:"REQUIRED. The name of the directory that will be pruned, or an s3://bucket/prefix or sftp://user@host/path location." required:"true"`
+}
+
+type ApplyCmd struct {
+	Force       bool        `help:"OPTIONAL. Apply the plan even if the snapshots changed since it was computed. Vanished snapshots are skipped." default:"false"`
+	OnCollision string      `help:"OPTIONAL. What to do if the name of a pruned directory is already taken in the trash directory: suffix, skip or replace, see the from command." enum:"suffix,skip,replace" default:"suffix"`
+	Wait        bool        `help:"OPTIONAL. If another run of prune_backups is working on the directory of the plan, wait for it to finish instead of failing." default:"false" negatable:""`
+	CopyAcross  bool        `name:"copy-across-devices" help:"OPTIONAL. If the trash directory is on another filesystem, copy the pruned directories there, verify the copies and remove the originals." default:"false"`
+	PerRunTrash bool        `help:"OPTIONAL. Move the pruned directories into a subdirectory of the trash directory named after the time of the run, along with a manifest describing the run." default:"true" negatable:""`
+	Workers     int         `help:"OPTIONAL. The number of directories removed in parallel if the plan deletes them." default:"64"`
+	Verbosity   int         `help:"OPTIONAL. Set verbosity. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
+	S3          S3Options   `embed:"" prefix:"s3-" group:"S3"`
+	SFTP        SFTPOptions `embed:"" prefix:"sftp-" group:"SFTP"`
+	Plan        string      `arg:"" help:"REQUIRED. The plan file written by the plan command." required:"true"`
+}
+
+func (p *PlanCmd) Run(cli *CLI) error {
+	if p.Match != "" && !p.Files {
+		return configErrorf("match flag requires the files flag")
+	}
+	complete, err := completeness(p.Marker, p.Unmodified, p.Files)
+	if err != nil {
+		return err
+	}
+	if (p.MinFiles > 0 || p.MinSize > 0 || p.MinRatio > 0) && !stats.SupportedOS {
+		return configErrorf("plausibility checks not supported for your OS")
+	}
+	if p.MinFiles < 0 || p.MinRatio < 0 {
+		return configErrorf("min-files and min-ratio must not be negative")
+	}
+	fsys, dir, err := openLocation(p.Dir, p.S3, p.SFTP)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+
+	o := pruneOptions{
+		fsys:      fsys,
+		dir:       dir,
+		now:       time.Now(),
+		to:        p.To,
+		verbosity: p.Verbosity,
+		delete:    p.Delete,
+		files:     p.Files,
+		match:     p.Match,
+		sidecars:  p.Sidecar,
+		complete:  complete,
+		plausible: retention.Plausibility{MinFiles: p.MinFiles, MinBytes: p.MinSize, MinRatio: p.MinRatio},
+	}
+	dirs, err := listCandidates(o)
+	if err != nil {
+		return err
+	}
+	incomplete, err := findIncomplete(o, dirs)
+	if err != nil {
+		return err
+	}
+	links, err := findSymlinks(o)
+	if err != nil {
+		return err
+	}
+	decisions := decide(o, dirs, incomplete, links)
+	location := p.Dir
+	if _, local := fsys.(vfs.OS); local {
+		if location, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+	}
+	plan := retention.PlanFile{
+		Time:        o.now,
+		Location:    location,
+		To:          p.To,
+		Delete:      p.Delete,
+		Files:       p.Files,
+		Match:       p.Match,
+		Candidates:  dirs,
+		Keep:        decisions.Keep,
+		Prune:       decisions.Prune,
+		Skipped:     decisions.Skipped,
+		Incomplete:  decisions.Incomplete,
+		Implausible: decisions.Implausible,
+		Protected:   decisions.Protected,
+
+		CompleteMarker:    p.Marker,
+		UnmodifiedMinutes: p.Unmodified,
+	}
+	if p.Files {
+		plan.Sidecars = p.Sidecar
+	}
+	if err := retention.WritePlanFile(p.Out, plan); err != nil {
+		return fmt.Errorf("Could not write plan file: %w", err)
+	}
+	if p.Verbosity > 1 {
+		for _, name := range decisions.Prune {
+			fmt.Println(" - prune", name)
+		}
+	}
+	o.log().Info(fmt.Sprint("I planned to prune ", len(decisions.Prune), " of ", len(dirs), " ", o.kind(), " and wrote the plan to ", p.Out, ". Review it and run 'prune_backups apply ", p.Out, "' to execute it."), "event", "planned", "dst", p.Out, "count", len(decisions.Prune))
+	return nil
+}
+
+func (a *ApplyCmd) Run(cli *CLI) error {
+	plan, err := retention.ReadPlanFile(a.Plan)
+	if err != nil {
+		return err
+	}
+	fsys, dir, err := openLocation(plan.Location, a.S3, a.SFTP)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+
+	return applyPlan(pruneOptions{
+		fsys:       fsys,
+		dir:        dir,
+		now:        time.Now(),
+		to:         plan.To,
+		verbosity:  a.Verbosity,
+		delete:     plan.Delete,
+		workers:    a.Workers,
+		perRun:     a.PerRunTrash,
+		copyAcross: a.CopyAcross,
+		wait:       a.Wait,
+		collision:  retention.Collision(a.OnCollision),
+		files:      plan.Files,
+		match:      plan.Match,
+		sidecars:   plan.Sidecars,
+		complete:   plan.Completeness(),
+	}, plan, a.Force)
+}
+
+// applyPlan executes plan unless the snapshots drifted since it was computed
+// and force is not set. With force, vanished snapshots are skipped.
+func applyPlan(o pruneOptions, plan retention.PlanFile, force bool) error {
+	unlock, err := lockDir(o.fsys, o.dir, o.wait, o.verbosity)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if done, err := recoverInterrupted(o, o.trash()); done || err != nil {
+		return err
+	}
+	dirs, err := listCandidates(o)
+	if err != nil {
+		return err
+	}
+
+	decisions := plan.Decisions()
+	if drift := plan.Drift(dirs); !drift.Empty() {
+		changes := describeDrift(drift)
+		if !force {
+			return &SafetyError{Err: fmt.Errorf("the %s in %s changed since the plan was computed at %s:\n%s\nCompute a new plan, or use --force to apply it anyway", o.kind(), o.dir, plan.Time.Format(time.DateTime), strings.Join(changes, "\n"))}
+		}
+		o.log().Warn("Applying the plan although the "+o.kind()+" changed since it was computed:\n"+strings.Join(changes, "\n"), "event", "drift", "changes", changes)
+		vanished := make(map[string]bool, len(drift.Vanished))
+		for _, name := range drift.Vanished {
+			vanished[name] = true
+		}
+		var prune []string
+		for _, name := range decisions.Prune {
+			if !vanished[name] {
+				prune = append(prune, name)
+			}
+		}
+		decisions.Prune = prune
+	}
+
+	// snapshots being written now are not pruned, even if they were complete when the plan was computed
+	incomplete, err := findIncomplete(o, decisions.Prune)
+	if err != nil {
+		return err
+	}
+	if len(incomplete) > 0 {
+		decisions.Prune = slices.DeleteFunc(decisions.Prune, retention.IsListed(incomplete))
+	}
+	// nor are snapshots that symlinks point to now
+	links, err := findSymlinks(o)
+	if err != nil {
+		return err
+	}
+	var protected []string
+	decisions.Prune = slices.DeleteFunc(decisions.Prune, func(name string) bool {
+		if len(links[name]) > 0 {
+			protected = append(protected, name)
+			return true
+		}
+		return false
+	})
+	reportProtected(o, protected, links)
+	return execute(o, decisions)
+}
+
+func describeDrift(drift retention.Drift) []string {
+	var changes []string
+	for _, name := range drift.Vanished {
+		changes = append(changes, fmt.Sprintf(" - %s was planned to be pruned, but vanished", name))
+	}
+	for _, name := range drift.NowKept {
+		changes = append(changes, fmt.Sprintf(" - %s was planned to be pruned, but would be kept now", name))
+	}
+	for _, name := range drift.NowPruned {
+		changes = append(changes, fmt.Sprintf(" - %s was not planned to be pruned, but would be pruned now", name))
+	}
+	return changes
+}
@@ -3,34 +3,69 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"regexp"
 	"runtime"
-	"sort"
 	"strings"
 	"time"
 
 	"github.com/alecthomas/kong"
+
+	"prune_backups/retention"
+	"prune_backups/sandbox"
+	"prune_backups/stats"
+	"prune_backups/vfs"
 )
 
 type CLI struct {
-	Version VersionCmd `cmd:"" help:"Show version/build information and exit."`
-	From    PruneCmd   `cmd:"" help:"Prune subdirectories from <dir> and move them to a 'to_delete' subdirectory (default, will be created automatically in <dir>) or --to a given location."`
-	Stats   StatsCmd   `cmd:"" help:"Show total size of linked and unlinked files in a given directory."`
+	Log       LogFlags     `embed:"" prefix:"log-"`
+	Version   VersionCmd   `cmd:"" help:"Show version/build information and exit."`
+	From      PruneCmd     `cmd:"" help:"Prune subdirectories from <dir> and move them to a 'to_delete' subdirectory (default, will be created automatically in <dir>) or --to a given location."`
+	Stats     StatsCmd     `cmd:"" help:"Show total size of linked and unlinked files in a given directory."`
+	Snapshots SnapshotsCmd `cmd:"" help:"Prune ZFS snapshots or btrfs subvolumes of <target> with the same rules."`
+	Purge     PurgeCmd     `cmd:"" help:"Delete the entries of the 'to_delete' subdirectory of <dir> that were moved there longer than a quarantine period ago."`
+	Restore   RestoreCmd   `cmd:"" help:"Undo a run of prune_backups by moving the directories it pruned from <dir> back from the trash directory."`
+	Check     CheckCmd     `cmd:"" help:"Check the age of the newest snapshot in <dir> and the gaps in its hourly and daily slots, reporting the result in the format of Nagios plugins."`
+	Report    ReportCmd    `cmd:"" help:"List the hourly, daily and monthly slots of the retention policy for <dir>, whether a snapshot fills them, and the gaps."`
+	Plan      PlanCmd      `cmd:"" help:"Decide which subdirectories of <dir> to prune and write the decisions to a plan file for review, without moving anything."`
+	Apply     ApplyCmd     `cmd:"" help:"Execute a plan file written by the plan command, refusing if the snapshots changed since."`
 }
 
 type VersionCmd struct{}
 
 type StatsCmd struct {
-	Dir string `arg:"" help:"REQUIRED. The name of the directory for searching and aggregating file types and sizes." required:"true"`
+	Sandbox bool   `help:"OPTIONAL. On Linux, restrict the process to reading <dir> with Landlock before reading anything." default:"true" negatable:""`
+	Dir     string `arg:"" help:"REQUIRED. The name of the directory for searching and aggregating file types and sizes." required:"true"`
 }
 
 type PruneCmd struct {
-	To        string `help:"OPTIONAL. The name of the directory where the pruned directories will be moved." default:"to_delete" short:"t"`
-	Stats     bool   `help:"OPTIONAL. Show total size of linked and unlinked files in the pruned directories." default:"false" short:"s"`
-	Verbosity int    `help:"OPTIONAL. Set verbosity. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
-	Dir       string `arg:"" help:"REQUIRED. The name of the directory that will be pruned. Make sure the user running prune_backups has r/w access rights to it." required:"true"`
+	To          string      `help:"OPTIONAL. The name of the directory where the pruned directories will be moved, relative to <dir>, or an absolute path." default:"to_delete" short:"t"`
+	Stats       bool        `help:"OPTIONAL. Show total size of linked and unlinked files in the pruned directories." default:"false" short:"s"`
+	Delete      bool        `help:"OPTIONAL. Delete the pruned directories instead of moving them. Local directories are removed by several workers in parallel; errors are reported and do not stop the deletion." default:"false"`
+	Workers     int         `help:"OPTIONAL. The number of directories removed in parallel with --delete or --purge-after." default:"64"`
+	PerRunTrash bool        `help:"OPTIONAL. Move the pruned directories of each run into a subdirectory of the trash directory named after the time of the run, along with a manifest describing the run. Use --no-per-run-trash to move them into the trash directory itself." default:"true" negatable:""`
+	OnCollision string      `help:"OPTIONAL. What to do if the name of a pruned directory is already taken in the trash directory: suffix - move it under its name with a counter appended, skip - leave it where it is, replace - delete the old copy in the trash first." enum:"suffix,skip,replace" default:"suffix"`
+	CopyAcross  bool        `name:"copy-across-devices" help:"OPTIONAL. If the trash directory is on another filesystem, copy the pruned directories there, preserving hard links, permissions, times and extended attributes, verify the copies and remove the originals. Without this flag, such moves are refused." default:"false"`
+	Wait        bool        `help:"OPTIONAL. If another run of prune_backups is working on <dir>, wait for it to finish instead of failing." default:"false" negatable:""`
+	Sandbox     bool        `help:"OPTIONAL. On Linux, restrict the process to <dir> and the trash directory with Landlock before touching anything. Use --no-sandbox if prune_backups needs to access other files, or to hide the message on kernels without Landlock." default:"true" negatable:""`
+	Resume      bool        `help:"OPTIONAL. Complete runs that were interrupted, e.g. by a crash, before pruning." xor:"interrupted"`
+	Rollback    bool        `help:"OPTIONAL. Undo runs that were interrupted, e.g. by a crash, and exit without pruning." xor:"interrupted"`
+	PurgeAfter  int         `help:"OPTIONAL. After pruning, delete the entries of the trash directory that were moved there more than this number of days ago. 0 disables purging." default:"0"`
+	StaleAfter  int         `help:"OPTIONAL. After pruning, fail with exit code 6 if the newest snapshot was made more than this number of hours ago, e.g. because backups stopped. 0 disables the check." default:"0"`
+	Marker      string      `name:"complete-marker" help:"OPTIONAL. A file that a backup tool creates in a snapshot directory when it is complete, e.g. .complete. Snapshots without it are considered incomplete: they neither displace a complete snapshot in their time slot nor are pruned." group:"Completeness"`
+	Unmodified  int         `name:"unmodified-for" help:"OPTIONAL. Consider snapshots modified within this number of minutes incomplete. For directories, the latest modification of any file counts; only snapshots of the last day are walked. 0 disables the check." default:"0" group:"Completeness"`
+	MinFiles    int         `help:"OPTIONAL. Snapshots with fewer files look like failed backups and do not win their time slot if there are other snapshots in it. 0 disables the check." default:"0" group:"Plausibility"`
+	MinSize     uint64      `help:"OPTIONAL. Like --min-files for the total size of the files in bytes." default:"0" group:"Plausibility"`
+	MinRatio    float64     `help:"OPTIONAL. Like --min-files for the size relative to the next older snapshot, e.g. 0.5 for snapshots less than half as big. 0 disables the check." default:"0" group:"Plausibility"`
+	Files       bool        `help:"OPTIONAL. Prune regular files like database dumps or archives (e.g. db-2024-06-17_09-49.sql.zst) instead of directories." default:"false" group:"Files"`
+	Match       string      `help:"OPTIONAL. Only consider files matching this glob pattern, e.g. '*.tar.gz'. Requires --files." group:"Files"`
+	Sidecar     []string    `help:"OPTIONAL. Extensions of sidecar files that are moved along with a pruned snapshot if they share its name, e.g. db-2024-06-17_09-49.sql.zst.sha256 or db-2024-06-17_09-49.sig." default:".sha256,.sig" group:"Files"`
+	Verbosity   int         `help:"OPTIONAL. Set verbosity. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
+	S3          S3Options   `embed:"" prefix:"s3-" group:"S3"`
+	SFTP        SFTPOptions `embed:"" prefix:"sftp-" group:"SFTP"`
+	Dir         string      `arg:"" help:"REQUIRED. The name of the directory that will be pruned, or an s3://bucket/prefix or sftp://user@host/path location. Make sure the user running prune_backups has r/w access rights to it." required:"true"`
 }
 
 func (v *VersionCmd) Run(cli *CLI) error {
@@ -39,382 +74,584 @@ func (v *VersionCmd) Run(cli *CLI) error {
 }
 
 func (p *PruneCmd) Run(cli *CLI) error {
-	if p.Stats && !Stats_SupportedOS {
-		return errors.New("stats flag not supported for your OS")
+	if p.Stats && !stats.SupportedOS {
+		return configErrorf("stats flag not supported for your OS")
+	}
+	if p.Stats && p.Delete {
+		return configErrorf("stats flag cannot be combined with the delete flag")
+	}
+	if p.PurgeAfter < 0 {
+		return configErrorf("the quarantine period must not be negative")
+	}
+	if p.StaleAfter < 0 {
+		return configErrorf("stale-after must not be negative")
+	}
+	if p.PurgeAfter > 0 && p.Delete {
+		return configErrorf("purge-after flag cannot be combined with the delete flag")
+	}
+	if p.Match != "" && !p.Files {
+		return configErrorf("match flag requires the files flag")
+	}
+	complete, err := completeness(p.Marker, p.Unmodified, p.Files)
+	if err != nil {
+		return err
+	}
+	if (p.MinFiles > 0 || p.MinSize > 0 || p.MinRatio > 0) && !stats.SupportedOS {
+		return configErrorf("plausibility checks not supported for your OS")
+	}
+	if p.MinFiles < 0 || p.MinRatio < 0 {
+		return configErrorf("min-files and min-ratio must not be negative")
 	}
 
-	now := time.Now()
+	fsys, dir, err := openLocation(p.Dir, p.S3, p.SFTP)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	if _, local := fsys.(vfs.OS); local && p.Sandbox {
+		paths := []sandbox.Path{{Name: dir, Access: sandbox.ReadWrite}}
+		trash := trashPath(dir, p.To)
+		if !p.Delete {
+			// only existing directories can be granted, so create the trash now
+			if err := fsys.MkdirAll(trash, 0755); err != nil {
+				return &retention.TrashError{Path: trash, Err: err}
+			}
+		}
+		if _, err := fsys.Stat(trash); err == nil {
+			// with --delete, only for the journal of an interrupted run
+			paths = append(paths, sandbox.Path{Name: trash, Access: sandbox.ReadWrite})
+		}
+		if err := restrict(p.Verbosity, paths...); err != nil {
+			return err
+		}
+	}
 
-	err := pruneDirectory(p.Dir, now, p.To, p.Verbosity, p.Stats)
-	return err
+	return prune(pruneOptions{
+		fsys:       fsys,
+		dir:        dir,
+		now:        time.Now(),
+		to:         p.To,
+		verbosity:  p.Verbosity,
+		showStats:  p.Stats,
+		delete:     p.Delete,
+		workers:    p.Workers,
+		purgeAfter: days(p.PurgeAfter),
+		staleAfter: time.Duration(p.StaleAfter) * time.Hour,
+		perRun:     p.PerRunTrash,
+		copyAcross: p.CopyAcross,
+		collision:  retention.Collision(p.OnCollision),
+		resume:     p.Resume,
+		rollback:   p.Rollback,
+		wait:       p.Wait,
+		complete:   complete,
+		plausible:  retention.Plausibility{MinFiles: p.MinFiles, MinBytes: p.MinSize, MinRatio: p.MinRatio},
+		files:      p.Files,
+		match:      p.Match,
+		sidecars:   p.Sidecar,
+	})
 }
 
 func (p *StatsCmd) Run(cli *CLI) error {
-	if !Stats_SupportedOS {
-		return errors.New("stats command not supported for your OS")
+	if !stats.SupportedOS {
+		return configErrorf("stats command not supported for your OS")
+	}
+	if p.Sandbox {
+		if err := restrict(1, sandbox.Path{Name: p.Dir, Access: sandbox.ReadOnly}); err != nil {
+			return err
+		}
 	}
 	err := showStatsOf(p.Dir)
 	return err
 }
 
+// restrict confines the process to paths with sandbox.Restrict. A kernel
+// without Landlock is reported on Linux, and the run continues unrestricted.
+func restrict(verbosity int, paths ...sandbox.Path) error {
+	// load the local time zone while /etc/localtime can still be read
+	_, _ = time.Now().Zone()
+	err := sandbox.Restrict(paths...)
+	if errors.Is(err, errors.ErrUnsupported) {
+		if runtime.GOOS == "linux" {
+			newLogger(verbosity).Warn("Running without a sandbox. Use --no-sandbox to hide this message.", "event", "no_sandbox", "err", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Could not restrict the process to %s: %w", paths[0].Name, err)
+	}
+	return nil
+}
+
 func main() {
 	cli := CLI{}
 	ctx := kong.Parse(&cli,
 		kong.Name("prune_backups"),
+		kong.Exit(func(code int) {
+			if code != 0 {
+				code = ExitConfig // kong exits only for invalid command lines, or with 0 after --help
+			}
+			os.Exit(code)
+		}),
 		kong.Description("A lightweight tool designed to elegantly trim backup directories based on filename conventions, maintaining one per hour for a day, one per day for a month, and one per month thereafter. The pattern is YYYY-MM-DD_HH-mm. Within each time slot, the latest directory is retained."),
 		// kong.UsageOnError(),
 	)
-	err := ctx.Run(&cli)
+	err := cli.Log.apply()
+	if err == nil {
+		err = ctx.Run(&cli)
+	}
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		code := exitCode(err)
+		if status := ExitStatus(0); !errors.As(err, &status) {
+			newLogger(1).Error(err.Error(), "event", "failed", "err", err, "exit_code", code)
+		}
+		os.Exit(code)
 	}
 }
 
+// pruneOptions holds everything prune needs to know about a run.
+type pruneOptions struct {
+	fsys       vfs.FS
+	dir        string
+	now        time.Time
+	to         string
+	verbosity  int
+	showStats  bool
+	delete     bool
+	workers    int           // the number of directories removed in parallel if delete is set
+	purgeAfter time.Duration // the quarantine period of the trash; 0 disables purging
+	staleAfter time.Duration // the maximum age of the newest snapshot; 0 disables the check
+	perRun     bool          // move into a subdirectory of the trash per run
+	copyAcross bool          // copy and remove if the trash is on another device
+	collision  retention.Collision
+	resume     bool // complete interrupted runs before pruning
+	rollback   bool // undo interrupted runs instead of pruning
+	wait       bool // wait for a concurrent run to finish instead of failing
+	complete   retention.Completeness
+	plausible  retention.Plausibility
+	files      bool     // prune regular files instead of directories
+	match      string   // glob pattern the files must match, if files is set
+	sidecars   []string // extensions of sidecar files moved along with the snapshots
+}
+
 func pruneDirectory(pruneDirName string, now time.Time, toDeleteDirName string, verbosity int, showStats bool) error {
-	files, err := os.ReadDir(pruneDirName)
-	if err != nil {
-		errorMessage := fmt.Sprintf("Could not read pruning directory: %s", err)
-		return errors.New(errorMessage)
-	}
+	return prune(pruneOptions{
+		fsys:      vfs.OS{},
+		dir:       pruneDirName,
+		now:       now,
+		to:        toDeleteDirName,
+		verbosity: verbosity,
+		showStats: showStats,
+	})
+}
 
-	dirs := make([]string, 0)
-	for _, file := range files {
-		if file.IsDir() {
-			dirs = append(dirs, file.Name())
-		}
+func prune(o pruneOptions) error {
+	unlock, err := lockDir(o.fsys, o.dir, o.wait, o.verbosity)
+	if err != nil {
+		return err
 	}
-	if verbosity > 0 {
-		fmt.Println("I found", len(dirs), "directories in", pruneDirName)
+	defer unlock()
+	if done, err := recoverInterrupted(o, o.trash()); done || err != nil {
+		return err
 	}
 
-	// Sort in descending order - caution: this is important for the algorithm!
-	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
-
-	var toDelete []string // in this array we will collect all directories that we will move to the to_delete-directory
-
-	filters := getAllFilters(now, dirs)
-	for _, filter := range filters {
-		addToDelete := getAllButFirstMatchingPrefix(dirs, filter)
-		toDelete = append(toDelete, addToDelete...)
+	dirs, err := listCandidates(o)
+	if err != nil {
+		return err
 	}
-
-	cleanupOthers := getDateDirectoriesNotMatchingAnyPrefix(dirs, filters, verbosity)
-	toDelete = append(toDelete, cleanupOthers...)
-
-	delPath := filepath.Join(pruneDirName, toDeleteDirName)
-	err2 := os.MkdirAll(delPath, 0755)
-	if err2 != nil {
-		errorMessage := fmt.Sprintf("Error creating directory \"%s\": %s", delPath, err2)
-		if verbosity > 0 {
-			movedDirs := "\nI would have moved the following directories there:\n"
-			for _, dir := range toDelete {
-				movedDirs += fmt.Sprintf(" - %s\n", dir)
-			}
-			errorMessage += movedDirs
-		}
-		return errors.New(errorMessage)
+	incomplete, err := findIncomplete(o, dirs)
+	if err != nil {
+		return err
 	}
-
-	/* now we have collected all directory names that need to be moved in toDelete. next we will create the target directory and actually move them */
-	var successfulMoveCounter, failedMoveCounter int
-	for _, dirname := range toDelete {
-		fromPath := filepath.Join(pruneDirName, dirname)
-		toPath := filepath.Join(delPath, dirname)
-		if verbosity > 1 {
-			fmt.Print("Moving ", fromPath, " to ", toPath, "... ")
-		}
-		if moveErr := os.Rename(fromPath, toPath); moveErr != nil {
-			failedMoveCounter++
-			if verbosity > 1 {
-				fmt.Println(moveErr)
-			} else {
-				fmt.Println("Error moving ", fromPath, " to ", toPath, ": ", moveErr)
-			}
-		} else {
-			if verbosity > 1 {
-				fmt.Println("done.")
-			}
-			successfulMoveCounter++
-		}
+	links, err := findSymlinks(o)
+	if err != nil {
+		return err
 	}
-	if verbosity > 0 {
-		fmt.Println("I moved", successfulMoveCounter, "directories to", delPath)
+	err = execute(o, decide(o, dirs, incomplete, links))
+	if o.staleAfter > 0 {
+		err = errors.Join(err, retention.CheckFresh(dirs, o.now, o.staleAfter))
 	}
-	var result error
-	if failedMoveCounter > 0 {
-		result = fmt.Errorf("%d of %d directories could not be moved to %s", failedMoveCounter, len(toDelete), delPath)
+	return err
+}
+
+// completeness returns the criterion for complete snapshots given by the
+// flags.
+func completeness(marker string, unmodifiedFor int, files bool) (retention.Completeness, error) {
+	if unmodifiedFor < 0 {
+		return retention.Completeness{}, configErrorf("unmodified-for must not be negative")
 	}
-	if showStats {
-		return errors.Join(result, showStatsOf(delPath))
+	if marker != "" && files {
+		return retention.Completeness{}, configErrorf("complete-marker flag cannot be combined with the files flag")
 	}
-	return result
+	return retention.Completeness{Marker: marker, Unmodified: time.Duration(unmodifiedFor) * time.Minute}, nil
 }
 
-func showStatsOf(delPath string) error {
-	info, err := DiskUsage(delPath)
+// findIncomplete returns the snapshots among dirs that are incomplete
+// according to o.complete.
+func findIncomplete(o pruneOptions, dirs []string) ([]string, error) {
+	incomplete, err := o.complete.Incomplete(o.fsys, o.dir, dirs, o.now)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("Could not check whether the %s are complete: %w", o.kind(), err)
 	}
-	fmt.Printf("Content of %v:\n", delPath)
-	printNiceNumbr(" - unlinked files            ", uint64(info.number_of_unlinked_files))
-	printNiceBytes(" - bytes in unlinked files   ", info.size_of_unlinked_files)
-	printNiceNumbr(" - hard-linked files         ", uint64(info.number_of_linked_files))
-	printNiceBytes(" - bytes in hard-linked files", info.size_of_linked_files)
-	printNiceNumbr(" - directories               ", uint64(info.number_of_subdirs))
-	printNiceNumbr(" - append-only-flagged files ", uint64(info.nr_apnd))
-	printNiceNumbr(" - exclusive-flagged files   ", uint64(info.nr_excl))
-	printNiceNumbr(" - temporary-flagged files   ", uint64(info.nr_tmp))
-	printNiceNumbr(" - symlinks                  ", uint64(info.nr_sym))
-	printNiceNumbr(" - device nodes              ", uint64(info.nr_dev))
-	printNiceNumbr(" - named pipes               ", uint64(info.nr_pipe))
-	printNiceNumbr(" - sockets                   ", uint64(info.nr_sock))
-	if info.number_of_permission_errors_files+info.number_of_permission_errors_dirs+info.number_of_other_errors_files+info.number_of_other_errors_dirs == 0 {
-		fmt.Println("No I/O errors occurred scanning the directory tree.")
-	} else {
-		fmt.Printf("%v errors occurred scanning the directory tree:\n", info.number_of_permission_errors_files+info.number_of_permission_errors_dirs+info.number_of_other_errors_files+info.number_of_other_errors_dirs)
-		printNiceNumbr(" - permission denial accessing directories ", uint64(info.number_of_permission_errors_dirs))
-		printNiceNumbr(" - permission denial accessing files       ", uint64(info.number_of_permission_errors_files))
-		printNiceNumbr(" - other errors accessing directories      ", uint64(info.number_of_other_errors_dirs))
-		printNiceNumbr(" - other errors accessing files            ", uint64(info.number_of_other_errors_files))
+	for _, name := range incomplete {
+		o.log().Info("Leaving "+name+" alone as it is incomplete.", "event", "incomplete", "src", name)
 	}
-	return nil
+	return incomplete, nil
 }
 
-func printNiceNumbr(prefix string, val uint64) {
-	if val > 999 {
-		fmt.Printf("%s : %v (i.e. %v)\n", prefix, val, formatSI(val))
-	} else {
-		fmt.Printf("%s : %v\n", prefix, val)
+// findSymlinks maps the snapshots in o.dir to the symlinks there pointing to
+// them, see retention.Symlinks.
+func findSymlinks(o pruneOptions) (map[string][]string, error) {
+	links, err := retention.Symlinks(o.fsys, o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the symlinks in %s: %w", o.dir, err)
 	}
+	return links, nil
 }
 
-func printNiceBytes(prefix string, val uint64) {
-	if val > 999 {
-		fmt.Printf("%s : %v Bytes (i.e. %vBytes)\n", prefix, val, formatSI(val))
-	} else {
-		fmt.Printf("%s : %v Bytes\n", prefix, val)
+// reportProtected logs the snapshots kept as symlinks point to them.
+func reportProtected(o pruneOptions, protected []string, links map[string][]string) {
+	for _, name := range protected {
+		o.log().Info("Protecting "+name+" as it is the target of "+strings.Join(links[name], ", ")+".", "event", "protected", "src", name, "links", links[name])
 	}
 }
 
-func getAllFilters(startTime time.Time, existingDirs []string) []string {
-	var result = []string{}
-
-	// append hourly filters
-
-	result = append(result, getFiltersForHourlies(startTime, existingDirs)...)
-
-	// append daily filters
-
-	filtersForDailies, firstMonthForMonthlies := getFiltersForDailies(startTime.AddDate(0, 0, -2), existingDirs)
-	result = append(result, filtersForDailies...)
-
-	// append monthly filters
-
-	result = append(result, getFiltersForMonthlies(firstMonthForMonthlies, 119)...)
-
-	return result
+// trashPath returns the trash directory for the snapshots in dir: to itself
+// if it is absolute, or the subdirectory to of dir.
+func trashPath(dir, to string) string {
+	if filepath.IsAbs(to) {
+		return to
+	}
+	return filepath.Join(dir, to)
 }
 
-func getFiltersForHourlies(startTime time.Time, existingDirs []string) []string {
-	var result = []string{}
-	filtersToday := getFiltersForToday(startTime)
-	result = append(result, filtersToday...)
-	remainingHourlies := 24 - len(filtersToday)
-	filtersYesterday := getFiltersForYesterday(startTime, remainingHourlies, existingDirs)
-	result = append(result, filtersYesterday...)
-	return result
+func (o pruneOptions) trash() string {
+	return trashPath(o.dir, o.to)
 }
 
-func getFiltersForToday(currentTime time.Time) []string {
-	var result = []string{}
-
-	day := currentTime.Day()
-
-	for currentTime.Day() == day {
-		// Format the time in the format YYYY-MM-DD_hh
-		prefix := currentTime.Format("2006-01-02_15") // caution, this is a magic number in go!
-		result = append(result, prefix)
-
-		// Subtract one hour from the current timestamp
-		currentTime = currentTime.Add(-1 * time.Hour)
+// kind names the snapshots in messages.
+func (o pruneOptions) kind() string {
+	if o.files {
+		return "files"
 	}
-	return result
+	return "directories"
 }
 
-func getFiltersForYesterday(currentTime time.Time, remainingHourlyBackups int, allDirs []string) []string {
-	var hourlyFilters = []string{}
-
-	yesterday := currentTime.Add(-24 * time.Hour)
-
-	var year = yesterday.Year()
-	var month = (int)(yesterday.Month())
-	var day = yesterday.Day()
-	yesterDateStr := toDateStr3(year, month, day)
-
-	for i := range remainingHourlyBackups {
-		prefix := yesterDateStr + "_" + twoDigit(23-i)
-		hourlyFilters = append(hourlyFilters, prefix)
+// listCandidates lists the snapshots in o.dir.
+func listCandidates(o pruneOptions) ([]string, error) {
+	var dirs []string
+	var err error
+	if o.files {
+		dirs, err = retention.ListFilesFS(o.fsys, o.dir, o.match, o.sidecars)
+	} else {
+		dirs, err = retention.ListFS(o.fsys, o.dir)
 	}
+	if err != nil {
+		return nil, &ReadDirError{Dir: o.dir, Err: err}
+	}
+	o.log().Info(fmt.Sprint("I found ", len(dirs), " ", o.kind(), " in ", o.dir), "event", "found", "count", len(dirs))
+	return dirs, nil
+}
 
-	anyMatches := getAnyMatchingAnyPrefixes(allDirs, hourlyFilters) // check what is actually there - the filter for yesterday will depend on it
-
-	if anyMatches {
-		// we found some hourly backup folders for yesterday, so return the filter for the hourly backups for yesterday, i.e. some YYYY-MM-DD_HH filters
-		return hourlyFilters
+// decide applies the default policy to the snapshots at o.now.
+func decide(o pruneOptions, dirs, incomplete []string, links map[string][]string) retention.Decisions {
+	policy := retention.DefaultPolicy()
+	if len(incomplete) > 0 {
+		policy.Incomplete = retention.IsListed(incomplete)
+	}
+	if len(links) > 0 {
+		policy.Protected = func(name string) bool { return len(links[name]) > 0 }
+	}
+	var checker *retention.PlausibilityChecker
+	if o.plausible.Enabled() {
+		checker = o.plausible.Checker(o.fsys, o.dir, dirs)
+		policy.Implausible = checker.Implausible
+	}
+	var decisions retention.Decisions
+	if o.files {
+		decisions = policy.PlanFiles(o.now, dirs)
 	} else {
-		// we found no hourly backup folders for yesterday, so return the filter for the latest backup for yesterday, i.e. one YYYY-MM-DD filter
-		return []string{yesterDateStr}
+		decisions = policy.Plan(o.now, dirs)
 	}
+	logger := o.log()
+	if checker != nil {
+		for _, name := range decisions.Implausible {
+			logger.Info("Flagging "+name+" as implausible as "+checker.Reasons[name]+".", "event", "implausible", "src", name, "reason", checker.Reasons[name])
+		}
+		for _, err := range checker.Errors {
+			logger.Warn("Could not check plausibility.", "event", "plausibility_failed", "err", err)
+		}
+	}
+	reportProtected(o, decisions.Protected, links)
+	for _, dir := range decisions.Skipped {
+		logger.Debug("Skipping "+dir+" as it is not in date format.", "event", "not_dated", "src", dir)
+	}
+	return decisions
 }
 
-func getFiltersForDailies(startDate time.Time, existingDirs []string) ([]string, time.Time) {
-	var result = []string{}
-	var firstMonthForMonthlies time.Time
-	M1 := get15thOfMonthBefore(startDate)
-	M2 := get15thOfMonthBefore(M1)
-	M3 := get15thOfMonthBefore(M2)
-	daysM0 := daysInMonth(startDate.Year(), startDate.Month())
-	daysM1 := daysInMonth(M1.Year(), M1.Month())
-
-	switch startDate.Day() {
-	case 1:
-		{
-			switch daysM1 {
-			case 28:
-				// The 30 days affect THREE months M0, M1, and M2 and M2 is NOT completely covered with daily backups.
-				// pin 29 normal dailies and test 1 daily in M2. continue with M3
-				result = append(result, getFiltersForDailiesSimple(startDate, 29)...)
-				result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M2.Year(), M2.Month()), 1, existingDirs)...)
-				firstMonthForMonthlies = M3
-			case 29:
-				// The 30 days affect TWO months M0 and M1 and M1 is completely covered with daily backups.
-				// pin 30 normal dailies and test nothing. continue with M2
-				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
-				firstMonthForMonthlies = M2
-			case 30, 31:
-				// The 30 days affect TWO months M0 and M1 and M1 is NOT completely covered with daily backups.
-				// pin 1 normal daily and test 29 dailies in M1. continue with M2
-				result = append(result, getFiltersForDailiesSimple(startDate, 1)...)
-				result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M1.Year(), M1.Month()), 29, existingDirs)...)
-				firstMonthForMonthlies = M2
+// execute moves or deletes the snapshots in decisions.Prune.
+func execute(o pruneOptions, decisions retention.Decisions) error {
+	kind := o.kind()
+	/* now we have collected all directory names that need to be moved in decisions.Prune. next we will create the target directory and actually move them */
+	delPath := o.trash()
+	var run string
+	movePath := delPath
+	if o.perRun && !o.delete {
+		run = retention.NewRunName(o.fsys, delPath, o.now)
+		movePath = filepath.Join(delPath, run)
+	}
+	var sidecars []string
+	if o.files {
+		sidecars = o.sidecars
+	}
+	logger := o.log()
+	result, err := retention.Apply(decisions, retention.ApplyOptions{
+		FS:       o.fsys,
+		Dir:      o.dir,
+		Trash:    delPath,
+		Run:      run,
+		Now:      o.now,
+		Delete:   o.delete,
+		Workers:  o.workers,
+		Sidecars: sidecars,
+
+		OnCollision:       o.collision,
+		CopyAcrossDevices: o.copyAcross,
+		Progress: func(m retention.Move) {
+			if m.Err == nil {
+				switch m.Collision {
+				case retention.CollisionSkip:
+					logger.Info("Skipping "+m.From+" as "+m.To+" already exists.", "event", "collision", "src", m.From, "dst", m.To, "collision", m.Collision)
+					return
+				case retention.CollisionSuffix:
+					logger.Info(filepath.Join(filepath.Dir(m.To), m.Name)+" already exists, moving "+m.From+" to "+m.To+" instead.", "event", "collision", "src", m.From, "dst", m.To, "collision", m.Collision)
+				case retention.CollisionReplace:
+					logger.Info("Replacing "+m.To+" with "+m.From+".", "event", "collision", "src", m.From, "dst", m.To, "collision", m.Collision)
+				}
 			}
-		}
-	case 2:
-		{
-			switch daysM1 {
-			case 28:
-				// The 30 days affect TWO months M0 and M1 and M1 is completely covered with daily backups.
-				// pin 30 normal dailies and test nothing. continue with M2
-				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
-				firstMonthForMonthlies = M2
-			case 29, 30, 31:
-				// The 30 days affect TWO months M0 and M1 and M1 is NOT completely covered with daily backups.
-				// pin 2 normal dailies and test 28 dailies in M1. continue with M2
-				result = append(result, getFiltersForDailiesSimple(startDate, 2)...)
-				result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M1.Year(), M1.Month()), 28, existingDirs)...)
-				firstMonthForMonthlies = M2
+			switch {
+			case o.delete && m.Err != nil:
+				logger.Error("Error deleting "+m.From+".", "event", "delete", "src", m.From, "err", m.Err)
+			case o.delete:
+				logger.Debug("Deleted "+m.From+".", "event", "delete", "src", m.From, "sidecars", m.Sidecars)
+			case m.Err != nil:
+				logger.Error("Error moving "+m.From+" to "+m.To+".", "event", "move", "src", m.From, "dst", m.To, "err", m.Err)
+			default:
+				logger.Debug("Moved "+m.From+" to "+m.To+".", "event", "move", "src", m.From, "dst", m.To, "sidecars", m.Sidecars)
 			}
-		}
-	case 30:
-		{
-			switch daysM0 {
-			// case 28, 29:
-			// impossible in a month with a 30st day if daysInMonth() works correctly
-			case 30:
-				// The 30 days affect ONE month M0 and M0 is completely covered with daily backups.
-				// pin 30 normal dailies and test nothing. continue with M1
-				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
-				firstMonthForMonthlies = M1
-			case 31:
-				// The 30 days affect ONE month M0 and (the rest of) M0 is completely covered with daily backups.
-				// Please note: the 31. will already be covered by the hourly backup filter logic, so the 30 daily filters will indeed cover the rest of the month
-				// pin 30 normal dailies and test nothing. continue with M1
-				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
-				firstMonthForMonthlies = M1
+		},
+	})
+	var trashErr *retention.TrashError
+	if errors.As(err, &trashErr) {
+		var movedDirs string
+		if o.verbosity > 0 {
+			movedDirs = "\nI would have moved the following " + kind + " there:\n"
+			for _, dir := range decisions.Prune {
+				movedDirs += fmt.Sprintf(" - %s\n", dir)
 			}
 		}
-	case 31:
-		{
-			switch daysM0 {
-			// case 28, 29, 30:
-			// impossible in a month with a 31st day if daysInMonth() works correctly
-			case 31:
-				// The 30 days affect ONE month M0 and M0 is NOT completely covered with daily backups.
-				// pin 0 normal dailies and test 30 dailies in M0. continue with M1
-				result = append(result, getFiltersForDailiesOrForMonth(startDate, 30, existingDirs)...)
-				firstMonthForMonthlies = M1
-			}
+		return fmt.Errorf("%w%s", trashErr, movedDirs)
+	}
+	var crossErr *retention.CrossDeviceError
+	if errors.As(err, &crossErr) {
+		return fmt.Errorf("%w. Use --copy-across-devices to allow this", err)
+	}
+	var preflightErr *retention.PreflightError
+	var worldWritableErr *retention.WorldWritableError
+	if errors.As(err, &preflightErr) || errors.As(err, &worldWritableErr) {
+		return err
+	}
+	if result.Moved() == 0 {
+		movePath = delPath // nothing has been moved to a run directory
+	}
+	if o.delete {
+		logger.Info(fmt.Sprint("I deleted ", result.Moved(), " ", kind, " from ", o.dir), "event", "deleted", "count", result.Moved())
+		if removed := result.Removed(); o.verbosity > 0 && (removed.Files > 0 || removed.Dirs > 0) {
+			printRemoval(removed)
+		}
+	} else {
+		logger.Info(fmt.Sprint("I moved ", result.Moved(), " ", kind, " to ", movePath), "event", "pruned", "dst", movePath, "count", result.Moved())
+		if skipped := result.Skipped(); skipped > 0 {
+			logger.Info(fmt.Sprint("I left ", skipped, " ", kind, " in place as their names are already taken in ", movePath), "event", "collisions_skipped", "dst", movePath, "count", skipped)
 		}
-	default:
-		// The 30 days affect TWO months M0 and M1 and M1 is NOT completely covered with daily backups.
-		// pin daysM0 normal dailies and test 30-daysM0 dailies in M1. continue with M2
-		result = append(result, getFiltersForDailiesSimple(startDate, startDate.Day())...)
-		result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M1.Year(), M1.Month()), 30-startDate.Day(), existingDirs)...)
-		firstMonthForMonthlies = M2
-	}
-	return result, firstMonthForMonthlies
+	}
+	if o.purgeAfter > 0 {
+		err = errors.Join(err, purgeTrash(o.fsys, delPath, o.now, o.purgeAfter, o.workers, o.verbosity))
+	}
+	if o.showStats {
+		return errors.Join(err, showStatsOfFS(o.fsys, movePath))
+	}
+	return err
 }
 
-func getFiltersForDailiesSimple(startDate time.Time, count int) []string {
-	var result = []string{}
-	for range count {
-		// Format the time in the format YYYY-MM-DD
-		prefix := startDate.Format("2006-01-02")
-		result = append(result, prefix)
-		startDate = startDate.AddDate(0, 0, -1)
+// lockDir takes the lock of dir for the duration of a run, see
+// retention.Lock. If another run holds it, lockDir waits for it if wait is
+// set, or returns an error describing the other run.
+func lockDir(fsys vfs.FS, dir string, wait bool, verbosity int) (unlock func(), err error) {
+	release, err := retention.Lock(fsys, dir, false)
+	var locked *retention.LockedError
+	if wait && errors.As(err, &locked) {
+		newLogger(verbosity).Info(locked.Error()+". Waiting for it to finish...", "event", "lock_wait", "dir", dir)
+		release, err = retention.Lock(fsys, dir, true)
+	}
+	if errors.Is(err, fs.ErrPermission) || errors.Is(err, fs.ErrNotExist) {
+		return func() {}, nil // reported when listing or moving
+	}
+	if errors.As(err, &locked) {
+		return nil, fmt.Errorf("%w. Use --wait to wait for it to finish", err)
 	}
-	return result
+	if err != nil {
+		return nil, fmt.Errorf("Could not lock %s: %w", dir, err)
+	}
+	return func() {
+		_ = release()
+	}, nil
 }
 
-func getFiltersForDailiesOrForMonth(startDate time.Time, remaining int, existingDirs []string) []string {
-	filtersForDailies := getFiltersForDailiesSimple(startDate, remaining)
-	anyMatches := getAnyMatchingAnyPrefixes(existingDirs, filtersForDailies) // check what is actually there
-	if anyMatches {
-		// we found some daily backup folders, so return the filter for the daily backups, i.e. some YYYY-MM-DD filters
-		return filtersForDailies
-	} else {
-		// we found no daily backup folders within the specified range, so return a filter for month, i.e. one YYYY-MM filter
-		filter := toDateStr(startDate.Year(), int(startDate.Month()))
-		return []string{filter}
+// recoverInterrupted looks for runs in trash that were interrupted and
+// completes or undoes them as requested by o. Without a request, an error
+// describing the interrupted runs is returned. done reports whether prune
+// must stop, i.e. after a rollback.
+func recoverInterrupted(o pruneOptions, trash string) (done bool, err error) {
+	runs, err := retention.InterruptedRuns(o.fsys, trash)
+	if errors.Is(err, fs.ErrPermission) {
+		return false, nil // reported when moving into the trash directory
+	}
+	if err != nil {
+		return true, fmt.Errorf("Could not check for interrupted runs: %w", err)
+	}
+	if len(runs) == 0 {
+		return false, nil
 	}
+	if !o.resume && !o.rollback {
+		var errs []error
+		for _, j := range runs {
+			errs = append(errs, fmt.Errorf("%s in %s was interrupted after %d of %d planned moves", runLabel(j.Run), trash, len(j.Renames), len(j.Planned)))
+		}
+		errs = append(errs, errors.New("use --resume to complete the interrupted runs or --rollback to undo them"))
+		return true, &SafetyError{Err: errors.Join(errs...)}
+	}
+
+	logger := o.log()
+	var errs []error
+	for _, j := range runs {
+		opts := retention.RestoreOptions{
+			FS:    o.fsys,
+			Dir:   o.dir,
+			Trash: trash,
+			Run:   j.Run,
+			Now:   o.now,
+
+			CopyAcrossDevices: o.copyAcross,
+			Progress: func(m retention.Move) {
+				if m.Err != nil {
+					logger.Error("Error moving "+m.From+" to "+m.To+".", "event", "recover_move", "src", m.From, "dst", m.To, "err", m.Err)
+				} else {
+					logger.Debug("Moved "+m.From+" to "+m.To+".", "event", "recover_move", "src", m.From, "dst", m.To)
+				}
+			},
+		}
+		if o.rollback {
+			result, err := retention.Rollback(opts)
+			logger.Info(fmt.Sprint("I rolled back interrupted ", runLabel(j.Run), ", moving ", result.Moved(), " entries back"), "event", "rolled_back", "run", j.Run, "count", result.Moved())
+			errs = append(errs, err)
+		} else {
+			result, err := retention.Resume(opts)
+			logger.Info(fmt.Sprint("I completed interrupted ", runLabel(j.Run), ", moving ", result.Moved(), " more entries to ", filepath.Join(trash, j.Run)), "event", "resumed", "run", j.Run, "dst", filepath.Join(trash, j.Run), "count", result.Moved())
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return true, err
+	}
+	if o.rollback {
+		logger.Info("Not pruning after a rollback. Run prune_backups again to prune.", "event", "not_pruning")
+	}
+	return o.rollback, nil
 }
 
-func getFiltersForMonthlies(current time.Time, count int) []string {
-	var result = []string{}
-	// don't use AddDate(0, -1, 0) as this function does not work as expected when we're on a March, 29th in a non-leap-year, e.g.
-	// use simpler and more robust approach, as from now on we don't need (leap-) days arithmetics anyhow
-	var year = current.Year()
-	var month = (int)(current.Month())
+// runLabel names a run in messages. A run that moved into the trash
+// directory itself, see --no-per-run-trash, has no name.
+func runLabel(run string) string {
+	if run == "" {
+		return "run"
+	}
+	return "run " + run
+}
 
-	for range count {
-		// Format the time in the format YYYY-MM
-		result = append(result, toDateStr(year, month))
-		prevMonth(&year, &month)
+func printRemoval(removed retention.Removal) {
+	fmt.Println("Removed:")
+	printNiceNumbr(" - files                     ", uint64(removed.Files))
+	printNiceBytes(" - bytes in files            ", removed.Bytes)
+	printNiceBytes(" - bytes freed               ", removed.FreedBytes)
+	printNiceNumbr(" - directories               ", uint64(removed.Dirs))
+	if len(removed.Errors) > 0 {
+		fmt.Printf("%v errors occurred removing the directory trees.\n", len(removed.Errors))
 	}
-	return result
 }
 
-func getDateDirectoriesNotMatchingAnyPrefix(allDirs []string, prefixes []string, verbosity int) []string {
-	var result = []string{}
-	r, _ := regexp.Compile(`^[\d]{4}\-[\d]{2}\-[\d]{2}.*`)
-	for _, dir := range allDirs {
-		if r.Match([]byte(dir)) {
-			foundMatch := false
-			for _, prefix := range prefixes {
-				if strings.HasPrefix(dir, prefix) {
-					foundMatch = true
-					break
-				}
-			}
-			if !foundMatch {
-				result = append(result, dir)
-			}
-		} else {
-			if verbosity > 1 {
-				fmt.Println("Skipping", dir, "as it is not in date format.")
-			}
-		}
+func showStatsOf(delPath string) error {
+	info, err := stats.DiskUsage(delPath)
+	if err != nil {
+		return err
+	}
+	printStats(delPath, info)
+	return nil
+}
+
+func showStatsOfFS(fsys vfs.FS, delPath string) error {
+	info, err := stats.DiskUsageFS(fsys, delPath)
+	if err != nil {
+		return err
+	}
+	printStats(delPath, info)
+	return nil
+}
+
+func printStats(delPath string, info stats.Infoblock) {
+	newLogger(1).Info("Collected the statistics of "+delPath+".", "event", "stats", "dir", delPath,
+		"unlinked_files", info.NumberOfUnlinkedFiles, "unlinked_bytes", info.SizeOfUnlinkedFiles,
+		"linked_files", info.NumberOfLinkedFiles, "linked_bytes", info.SizeOfLinkedFiles, "dirs", info.NumberOfSubdirs,
+		"errors", info.NumberOfPermissionErrorsFiles+info.NumberOfPermissionErrorsDirs+info.NumberOfOtherErrorsFiles+info.NumberOfOtherErrorsDirs)
+	fmt.Printf("Content of %v:\n", delPath)
+	printNiceNumbr(" - unlinked files            ", uint64(info.NumberOfUnlinkedFiles))
+	printNiceBytes(" - bytes in unlinked files   ", info.SizeOfUnlinkedFiles)
+	printNiceNumbr(" - hard-linked files         ", uint64(info.NumberOfLinkedFiles))
+	printNiceBytes(" - bytes in hard-linked files", info.SizeOfLinkedFiles)
+	printNiceNumbr(" - directories               ", uint64(info.NumberOfSubdirs))
+	printNiceNumbr(" - append-only-flagged files ", uint64(info.NrApnd))
+	printNiceNumbr(" - exclusive-flagged files   ", uint64(info.NrExcl))
+	printNiceNumbr(" - temporary-flagged files   ", uint64(info.NrTmp))
+	printNiceNumbr(" - symlinks                  ", uint64(info.NrSym))
+	printNiceNumbr(" - device nodes              ", uint64(info.NrDev))
+	printNiceNumbr(" - named pipes               ", uint64(info.NrPipe))
+	printNiceNumbr(" - sockets                   ", uint64(info.NrSock))
+	if info.NumberOfPermissionErrorsFiles+info.NumberOfPermissionErrorsDirs+info.NumberOfOtherErrorsFiles+info.NumberOfOtherErrorsDirs == 0 {
+		fmt.Println("No I/O errors occurred scanning the directory tree.")
+	} else {
+		fmt.Printf("%v errors occurred scanning the directory tree:\n", info.NumberOfPermissionErrorsFiles+info.NumberOfPermissionErrorsDirs+info.NumberOfOtherErrorsFiles+info.NumberOfOtherErrorsDirs)
+		printNiceNumbr(" - permission denial accessing directories ", uint64(info.NumberOfPermissionErrorsDirs))
+		printNiceNumbr(" - permission denial accessing files       ", uint64(info.NumberOfPermissionErrorsFiles))
+		printNiceNumbr(" - other errors accessing directories      ", uint64(info.NumberOfOtherErrorsDirs))
+		printNiceNumbr(" - other errors accessing files            ", uint64(info.NumberOfOtherErrorsFiles))
+	}
+}
+
+func printNiceNumbr(prefix string, val uint64) {
+	if val > 999 {
+		fmt.Printf("%s : %v (i.e. %v)\n", prefix, val, formatSI(val))
+	} else {
+		fmt.Printf("%s : %v\n", prefix, val)
+	}
+}
+
+func printNiceBytes(prefix string, val uint64) {
+	if val > 999 {
+		fmt.Printf("%s : %v Bytes (i.e. %vBytes)\n", prefix, val, formatSI(val))
+	} else {
+		fmt.Printf("%s : %v Bytes\n", prefix, val)
 	}
-	return result
 }
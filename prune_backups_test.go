@@ -2,18 +2,30 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/kong"
+
+	"prune_backups/internal/s3test"
+	"prune_backups/retention"
+	"prune_backups/snapshot"
+	"prune_backups/stats"
+	"prune_backups/vfs"
+	"prune_backups/vfs/s3fs"
+	"prune_backups/vfs/sftpfs"
 )
 
 func compareArrays(result []string, want []string, t *testing.T) {
@@ -50,11 +62,12 @@ func TestVersionCmd_Run(t *testing.T) {
 	}
 }
 
-// captureOutput captures the output of a function for testing
+// captureOutput captures the output of a function for testing, i.e. the
+// results written to stdout and the diagnostics logged to stderr
 func captureOutput(f func()) string {
-	old := os.Stdout
+	old, oldErr := os.Stdout, os.Stderr
 	r, w, _ := os.Pipe()
-	os.Stdout = w
+	os.Stdout, os.Stderr = w, w
 
 	f()
 
@@ -62,7 +75,7 @@ func captureOutput(f func()) string {
 	if closerror != nil {
 		return fmt.Sprintf("Error closing writer: %v", closerror)
 	}
-	os.Stdout = old
+	os.Stdout, os.Stderr = old, oldErr
 
 	var buf bytes.Buffer
 	_, err := io.Copy(&buf, r)
@@ -78,7 +91,7 @@ func TestCLI_StatsCommand(t *testing.T) {
 		kong.Name("prune_backups"),
 	)
 
-	args := []string{"stats", "ghjaiersughydfiasptohgyhjash"}
+	args := []string{"stats", "ghjaiersughydfiasptohgyhjash", "--no-sandbox"}
 	ctx, err := parser.Parse(args)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -105,7 +118,7 @@ func TestCLI_PruneCommand(t *testing.T) {
 		kong.Name("prune_backups"),
 	)
 
-	args := []string{"from", "ghjaiersughydfiasptohgyhjash"}
+	args := []string{"from", "ghjaiersughydfiasptohgyhjash", "--no-sandbox"}
 	ctx, err := parser.Parse(args)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -124,10 +137,10 @@ func TestCLI_PruneCommand(t *testing.T) {
 }
 
 func TestCLI_PruneCommandStatsNotSupported(t *testing.T) {
-	prev := Stats_SupportedOS
-	Stats_SupportedOS = false
+	prev := stats.SupportedOS
+	stats.SupportedOS = false
 	defer func() {
-		Stats_SupportedOS = prev
+		stats.SupportedOS = prev
 	}()
 
 	cli := CLI{}
@@ -135,7 +148,7 @@ func TestCLI_PruneCommandStatsNotSupported(t *testing.T) {
 		kong.Name("prune_backups"),
 	)
 
-	args := []string{"from", "./testdata/", "--stats"}
+	args := []string{"from", "./testdata/", "--stats", "--no-sandbox"}
 	ctx, err := parser.Parse(args)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -153,10 +166,10 @@ func TestCLI_PruneCommandStatsNotSupported(t *testing.T) {
 }
 
 func TestCLI_StatsCommandStatsNotSupported(t *testing.T) {
-	prev := Stats_SupportedOS
-	Stats_SupportedOS = false
+	prev := stats.SupportedOS
+	stats.SupportedOS = false
 	defer func() {
-		Stats_SupportedOS = prev
+		stats.SupportedOS = prev
 	}()
 
 	cli := CLI{}
@@ -164,7 +177,7 @@ func TestCLI_StatsCommandStatsNotSupported(t *testing.T) {
 		kong.Name("prune_backups"),
 	)
 
-	args := []string{"stats", "./testdata/"}
+	args := []string{"stats", "./testdata/", "--no-sandbox"}
 	ctx, err := parser.Parse(args)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -180,6 +193,245 @@ func TestCLI_StatsCommandStatsNotSupported(t *testing.T) {
 	}
 }
 
+func TestCLI_PruneCommandDeleteLocal(t *testing.T) {
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+
+	ctx, err := parser.Parse([]string{"from", "--delete", "--workers", "2", "--stats", t.TempDir()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = ctx.Run(&cli)
+	expectedText := "stats flag cannot be combined with the delete flag"
+	if err == nil || !strings.Contains(err.Error(), expectedText) {
+		t.Fatalf("expected %q, got %v", expectedText, err)
+	}
+	if cli.From.Workers != 2 {
+		t.Errorf("expected 2 workers, got %d", cli.From.Workers)
+	}
+}
+
+func Test_pruneDeleteLocal(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_08-49", "2024-06-17_07-49"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot, "etc"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, snapshot, "etc", "hosts"), []byte("127.0.0.1 localhost\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// pretend the snapshots were made with rsync --link-dest
+	if err := os.Remove(filepath.Join(dir, "2024-06-17_07-49", "etc", "hosts")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(dir, "2024-06-17_09-49", "etc", "hosts"), filepath.Join(dir, "2024-06-17_07-49", "etc", "hosts")); err != nil {
+		t.Fatal(err)
+	}
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{
+			fsys:      vfs.OS{},
+			dir:       dir,
+			now:       time.Date(2025, 2, 15, 22, 45, 0, 0, time.UTC),
+			to:        "to_delete",
+			verbosity: 2,
+			delete:    true,
+			workers:   2,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	expectOutput(t, output, "Deleted "+filepath.Join(dir, "2024-06-17_08-49")+".")
+	expectOutput(t, output, "I deleted 2 directories from "+dir)
+	expectOutput(t, output, " - files                      : 2\n - bytes in files             : 40 Bytes\n - bytes freed                : 20 Bytes\n - directories                : 4\n")
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 2 || entries[0].Name() != retention.LockName || entries[1].Name() != "2024-06-17_09-49" {
+		t.Errorf("expected only the lock file and the latest snapshot to remain, got %v, %v", entries, err)
+	}
+}
+
+func TestCLI_PruneCommandFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"db-2024-06-17_09-49.sql.zst", "db-2024-06-17_09-15.sql.zst", "db-2024-06-17_09-15.sql.zst.sha256", "db-2024-06-17_09-15.sig",
+		"www-2024-06-17_09-15.tar.gz", "notes.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "2024-06-17_09-10"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+	if ctx, err := parser.Parse([]string{"from", "--match", "*.sql.zst", dir}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := ctx.Run(&cli); err == nil || err.Error() != "match flag requires the files flag" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := parser.Parse([]string{"from", "--files", "--match", "db-*", dir}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cli.From.Files || cli.From.Match != "db-*" || !reflect.DeepEqual(cli.From.Sidecar, []string{".sha256", ".sig"}) {
+		t.Errorf("unexpected flags %+v", cli.From)
+	}
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{
+			fsys:      vfs.OS{},
+			dir:       dir,
+			now:       time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC),
+			to:        "to_delete",
+			verbosity: 2,
+			files:     true,
+			match:     "www-*",
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, "I found 1 files in "+dir)
+	expectOutput(t, output, "I moved 0 files to ")
+
+	output = captureOutput(func() {
+		err := prune(pruneOptions{
+			fsys:      vfs.OS{},
+			dir:       dir,
+			now:       time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC),
+			to:        "to_delete",
+			verbosity: 2,
+			files:     true,
+			sidecars:  []string{".sha256", ".sig"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	// without --match, www-2024-06-17_09-15.tar.gz shares the time slot of
+	// db-2024-06-17_09-49.sql.zst
+	expectOutput(t, output, "I found 4 files in "+dir)
+	expectOutput(t, output, `sidecars="[db-2024-06-17_09-15.sql.zst.sha256 db-2024-06-17_09-15.sig]"`)
+	expectOutput(t, output, "I moved 2 files to "+filepath.Join(dir, "to_delete"))
+	for _, name := range []string{"db-2024-06-17_09-15.sql.zst", "db-2024-06-17_09-15.sql.zst.sha256", "db-2024-06-17_09-15.sig"} {
+		if _, err := os.Stat(filepath.Join(dir, "to_delete", name)); err != nil {
+			t.Errorf("expected %s in the trash: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-10")); err != nil {
+		t.Errorf("expected directories to be left alone in files mode: %v", err)
+	}
+}
+
+func Test_openLocation(t *testing.T) {
+	fsys, dir, err := openLocation("/srv/backup", S3Options{}, SFTPOptions{})
+	if err != nil || dir != "/srv/backup" || fsys != (vfs.OS{}) {
+		t.Errorf("openLocation() of a local path = %v, %q, %v", fsys, dir, err)
+	}
+
+	fsys, dir, err = openLocation("s3://backups/host", S3Options{Endpoint: "localhost:9000", Insecure: true}, SFTPOptions{})
+	if err != nil || dir != "host" {
+		t.Fatalf("openLocation() of an s3 URL = %v, %q, %v", fsys, dir, err)
+	}
+	if s3, ok := fsys.(*s3fs.FS); !ok || s3.Bucket != "backups" {
+		t.Errorf("expected an s3fs.FS for bucket backups, got %#v", fsys)
+	}
+
+	if _, _, err = openLocation("s3:///host", S3Options{Endpoint: "localhost:9000"}, SFTPOptions{}); err == nil {
+		t.Errorf("expected an error for a missing bucket name")
+	}
+	if _, _, err = openLocation("s3://backups/host", S3Options{}, SFTPOptions{}); err == nil {
+		t.Errorf("expected an error for a missing endpoint")
+	}
+	if _, _, err = openLocation("sftp://nas.example.com/srv/backup", S3Options{}, SFTPOptions{}); err == nil {
+		t.Errorf("expected an error for a missing user name")
+	}
+	missing := filepath.Join(t.TempDir(), "missing")
+	if _, _, err = openLocation("sftp://backup@127.0.0.1:1/srv/backup", S3Options{}, SFTPOptions{Identity: []string{missing}, KnownHosts: missing}); err == nil {
+		t.Errorf("expected an error for a missing known_hosts file")
+	}
+}
+
+func TestSFTPOptions_dialOptions(t *testing.T) {
+	opts := SFTPOptions{Identity: []string{"/keys/backup"}}.dialOptions()
+	if !reflect.DeepEqual(opts.IdentityFiles, []string{"/keys/backup"}) || opts.KnownHostsFile != sftpfs.DefaultDialOptions().KnownHostsFile {
+		t.Errorf("dialOptions() = %+v", opts)
+	}
+	opts = SFTPOptions{KnownHosts: "/etc/ssh/ssh_known_hosts"}.dialOptions()
+	if opts.KnownHostsFile != "/etc/ssh/ssh_known_hosts" || !reflect.DeepEqual(opts.IdentityFiles, sftpfs.DefaultDialOptions().IdentityFiles) {
+		t.Errorf("dialOptions() = %+v", opts)
+	}
+}
+
+func Test_pruneS3(t *testing.T) {
+	client := s3test.NewMemClient()
+	for _, dir := range []string{"2024-06-17_09-49", "2024-06-17_08-49", "2024-06-16_23-49", "2024-05-31_23-49"} {
+		client.Put("backups", "host/"+dir+"/data", 10)
+	}
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{
+			fsys:      s3fs.New(client, "backups"),
+			dir:       "host",
+			now:       time.Date(2025, 2, 15, 22, 45, 0, 0, time.UTC),
+			to:        "to_delete",
+			verbosity: 2,
+			delete:    true,
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	expectOutput(t, output, "Deleted host/2024-06-17_08-49.")
+	expectOutput(t, output, "I deleted 2 directories from host")
+	want := []string{"host/2024-05-31_23-49/data", "host/2024-06-17_09-49/data"}
+	if got := client.Keys("backups"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining objects = %v, want %v", got, want)
+	}
+}
+
+func Test_pruneS3Files(t *testing.T) {
+	client := s3test.NewMemClient()
+	for _, name := range []string{"db-2024-06-17_09-49.sql.zst", "db-2024-06-17_09-15.sql.zst", "db-2024-06-17_09-15.sql.zst.sha256"} {
+		client.Put("backups", "host/"+name, 10)
+	}
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{
+			fsys:      s3fs.New(client, "backups"),
+			dir:       "host",
+			now:       time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC),
+			to:        "to_delete",
+			verbosity: 2,
+			files:     true,
+			sidecars:  []string{".sha256"},
+		})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	expectOutput(t, output, "I moved 1 files to host/to_delete")
+	want := []string{
+		"host/db-2024-06-17_09-49.sql.zst",
+		"host/to_delete/" + retention.JournalName, "host/to_delete/" + retention.TrashIndexName,
+		"host/to_delete/db-2024-06-17_09-15.sql.zst", "host/to_delete/db-2024-06-17_09-15.sql.zst.sha256",
+	}
+	if got := client.Keys("backups"); !reflect.DeepEqual(got, want) {
+		t.Errorf("remaining objects = %v, want %v", got, want)
+	}
+}
+
 func Test_pruneDirectoryHourlyForFourMonths(t *testing.T) {
 	testTime_gen := time.Date(2024, 6, 17, 9, 49, 33, 0, time.UTC)
 	testTime_prune := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
@@ -335,659 +587,24 @@ func generateTestDirectories(t *testing.T, directories []string) string {
 	return dir
 }
 
-func Test_getFiltersForDailies(t *testing.T) {
-	for _, tt := range testsFor30Dailies {
-		t.Run(tt.name, func(t *testing.T) {
-			gotFilters, gotMonth := getFiltersForDailies(tt.testTime, tt.existingDirs)
-			if gotMonth != tt.nextMonth {
-				t.Errorf("The month to continue diverges: expected=%v, got=%v", gotMonth, tt.nextMonth)
-			}
-			if !reflect.DeepEqual(gotFilters, tt.filterDates) {
-				compareArrays(gotFilters, tt.filterDates, t)
-				t.Errorf("getFiltersForDailies() result not as expected!")
-			}
-		})
-	}
-}
-
-var testsFor30Dailies = []struct {
-	name          string
-	testTime     time.Time
-	nextMonth    time.Time
-	existingDirs []string
-	filterDates  []string
-}{
-	{
-		name:       "Test Case 1a - middle of the month, all existing",
-		testTime:  time.Date(2014, 7, 15, 9, 54, 21, 0, time.UTC),
-		nextMonth: time.Date(2014, 5, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2014-07-17_23-54", "2014-07-16_23-54", "2014-07-15_23-54", "2014-07-14_23-54", "2014-07-13_23-54", "2014-07-12_23-54",
-			"2014-07-11_23-54", "2014-07-10_23-54", "2014-07-09_23-54", "2014-07-08_23-54", "2014-07-07_23-54", "2014-07-06_23-54",
-			"2014-07-05_23-54", "2014-07-04_23-54", "2014-07-03_23-54", "2014-07-02_23-54", "2014-07-01_23-54", "2014-06-30_23-54",
-			"2014-06-29_23-54", "2014-06-28_23-54", "2014-06-27_23-54", "2014-06-26_23-54", "2014-06-25_23-54", "2014-06-24_23-54",
-			"2014-06-23_23-54", "2014-06-22_23-54", "2014-06-21_23-54", "2014-06-20_23-54", "2014-06-19_23-54", "2014-06-18_23-54",
-			"2014-06-17_23-54", "2014-06-16_23-54", "2014-06-15_23-54", "2014-06-14_23-54", "2014-06-13_23-54", "2014-06-12_23-54",
-		},
-		filterDates: []string{
-			// today and yesterday and 15 days in a month
-			/*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/
-			/*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ "2014-07-15", "2014-07-14", "2014-07-13", "2014-07-12", "2014-07-11",
-			"2014-07-10", "2014-07-09", "2014-07-08", "2014-07-07", "2014-07-06", "2014-07-05", "2014-07-04", "2014-07-03", "2014-07-02", "2014-07-01",
-			// ... and 15 days in the other month
-			"2014-06-30", "2014-06-29", "2014-06-28", "2014-06-27", "2014-06-26", "2014-06-25", "2014-06-24", "2014-06-23", "2014-06-22", "2014-06-21",
-			"2014-06-20", "2014-06-19", "2014-06-18", "2014-06-17", "2014-06-16", /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/
-			/*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/
-		},
-	},
-	{
-		name:          "Test Case 1b - middle of the month, none existing",
-		testTime:     time.Date(2014, 7, 15, 9, 54, 21, 0, time.UTC),
-		nextMonth:    time.Date(2014, 5, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{},
-		filterDates: []string{
-			// today and yesterday and 15 days in a month
-			/*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/
-			/*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ /*XXXXXXXXX*/ "2014-07-15", "2014-07-14", "2014-07-13", "2014-07-12", "2014-07-11",
-			"2014-07-10", "2014-07-09", "2014-07-08", "2014-07-07", "2014-07-06", "2014-07-05", "2014-07-04", "2014-07-03", "2014-07-02", "2014-07-01",
-			// complete June
-			"2014-06",
-		},
-	},
-	{
-		name:       "Test Case 2a - 1st of the month, prev 31 days, all existing",
-		testTime:  time.Date(2022, 8, 1, 23, 54, 21, 0, time.UTC),
-		nextMonth: time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2022-08-01_23-54", "2022-07-31_23-54", "2022-07-30_23-54", "2022-07-29_23-54", "2022-07-28_23-54", "2022-07-27_23-54",
-			"2022-07-26_23-54", "2022-07-25_23-54", "2022-07-24_23-54", "2022-07-23_23-54", "2022-07-22_23-54", "2022-07-21_23-54",
-			"2022-07-20_23-54", "2022-07-19_23-54", "2022-07-18_23-54", "2022-07-17_23-54", "2022-07-16_23-54", "2022-07-15_23-54",
-			"2022-07-14_23-54", "2022-07-13_23-54", "2022-07-12_23-54", "2022-07-11_23-54", "2022-07-10_23-54", "2022-07-09_23-54",
-			"2022-07-08_23-54", "2022-07-07_23-54", "2022-07-06_23-54", "2022-07-05_23-54", "2022-07-04_23-54", "2022-07-03_23-54",
-			"2022-07-02_23-54", "2022-07-01_23-54", "2022-06-30_23-54", "2022-06-29_23-54", "2022-06-28_23-54", "2022-06-27_23-54",
-		},
-		filterDates: []string{
-			"2022-08-01", "2022-07-31", "2022-07-30", "2022-07-29", "2022-07-28", "2022-07-27",
-			"2022-07-26", "2022-07-25", "2022-07-24", "2022-07-23", "2022-07-22", "2022-07-21",
-			"2022-07-20", "2022-07-19", "2022-07-18", "2022-07-17", "2022-07-16", "2022-07-15",
-			"2022-07-14", "2022-07-13", "2022-07-12", "2022-07-11", "2022-07-10", "2022-07-09",
-			"2022-07-08", "2022-07-07", "2022-07-06", "2022-07-05", "2022-07-04", "2022-07-03",
-		},
-	},
-	{
-		name:          "Test Case 2b - 1st of the month, prev 31 days, none existing",
-		testTime:     time.Date(2022, 8, 1, 23, 54, 21, 0, time.UTC),
-		nextMonth:    time.Date(2022, 6, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{},
-		filterDates: []string{
-			"2022-08-01",
-			// complete July
-			"2022-07",
-		},
-	},
-	{
-		name:       "Test Case 3a - 1st of the month, prev 29 days, all existing",
-		testTime:  time.Date(2024, 3, 1, 23, 54, 21, 0, time.UTC),
-		nextMonth: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2024-03-01_23-54", "2024-02-29_23-54", "2024-02-28_23-54", "2024-02-27_23-54", "2024-02-26_23-54", "2024-02-25_23-54",
-			"2024-02-24_23-54", "2024-02-23_23-54", "2024-02-22_23-54", "2024-02-21_23-54", "2024-02-20_23-54", "2024-02-19_23-54",
-			"2024-02-18_23-54", "2024-02-17_23-54", "2024-02-16_23-54", "2024-02-15_23-54", "2024-02-14_23-54", "2024-02-13_23-54",
-			"2024-02-12_23-54", "2024-02-11_23-54", "2024-02-10_23-54", "2024-02-09_23-54", "2024-02-08_23-54", "2024-02-07_23-54",
-			"2024-02-06_23-54", "2024-02-05_23-54", "2024-02-04_23-54", "2024-02-03_23-54", "2024-02-02_23-54", "2024-02-01_23-54",
-			"2024-01-31_23-54", "2024-01-30_23-54", "2024-01-29_23-54", "2024-01-28_23-54", "2024-01-27_23-54", "2024-01-26_23-54",
-		},
-		filterDates: []string{
-			"2024-03-01", "2024-02-29", "2024-02-28", "2024-02-27", "2024-02-26", "2024-02-25",
-			"2024-02-24", "2024-02-23", "2024-02-22", "2024-02-21", "2024-02-20", "2024-02-19",
-			"2024-02-18", "2024-02-17", "2024-02-16", "2024-02-15", "2024-02-14", "2024-02-13",
-			"2024-02-12", "2024-02-11", "2024-02-10", "2024-02-09", "2024-02-08", "2024-02-07",
-			"2024-02-06", "2024-02-05", "2024-02-04", "2024-02-03", "2024-02-02", "2024-02-01",
-		},
-	},
-	{
-		name:          "Test Case 3a - 1st of the month, prev 29 days, none existing",
-		testTime:     time.Date(2024, 3, 1, 23, 54, 21, 0, time.UTC),
-		nextMonth:    time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{},
-		filterDates: []string{
-			"2024-03-01", "2024-02-29", "2024-02-28", "2024-02-27", "2024-02-26", "2024-02-25",
-			"2024-02-24", "2024-02-23", "2024-02-22", "2024-02-21", "2024-02-20", "2024-02-19",
-			"2024-02-18", "2024-02-17", "2024-02-16", "2024-02-15", "2024-02-14", "2024-02-13",
-			"2024-02-12", "2024-02-11", "2024-02-10", "2024-02-09", "2024-02-08", "2024-02-07",
-			"2024-02-06", "2024-02-05", "2024-02-04", "2024-02-03", "2024-02-02", "2024-02-01",
-		},
-	},
-	{
-		name:       "Test Case 4a - 1st of the month, prev 28 days (3 months coverage), all existing",
-		testTime:  time.Date(2023, 3, 1, 23, 54, 21, 0, time.UTC),
-		nextMonth: time.Date(2022, 12, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2023-03-01_23-54", "2023-02-28_23-54", "2023-02-27_23-54", "2023-02-26_23-54", "2023-02-25_23-54", "2023-02-24_23-54",
-			"2023-02-23_23-54", "2023-02-22_23-54", "2023-02-21_23-54", "2023-02-20_23-54", "2023-02-19_23-54", "2023-02-18_23-54",
-			"2023-02-17_23-54", "2023-02-16_23-54", "2023-02-15_23-54", "2023-02-14_23-54", "2023-02-13_23-54", "2023-02-12_23-54",
-			"2023-02-11_23-54", "2023-02-10_23-54", "2023-02-09_23-54", "2023-02-08_23-54", "2023-02-07_23-54", "2023-02-06_23-54",
-			"2023-02-05_23-54", "2023-02-04_23-54", "2023-02-03_23-54", "2023-02-02_23-54", "2023-02-01_23-54", "2023-01-31_23-54",
-			"2023-01-30_23-54", "2023-01-29_23-54", "2023-01-28_23-54", "2023-01-27_23-54", "2023-01-26_23-54", "2023-01-25_23-54",
-		},
-		filterDates: []string{
-			"2023-03-01", "2023-02-28", "2023-02-27", "2023-02-26", "2023-02-25", "2023-02-24",
-			"2023-02-23", "2023-02-22", "2023-02-21", "2023-02-20", "2023-02-19", "2023-02-18",
-			"2023-02-17", "2023-02-16", "2023-02-15", "2023-02-14", "2023-02-13", "2023-02-12",
-			"2023-02-11", "2023-02-10", "2023-02-09", "2023-02-08", "2023-02-07", "2023-02-06",
-			"2023-02-05", "2023-02-04", "2023-02-03", "2023-02-02", "2023-02-01", "2023-01-31",
-		},
-	},
-	{
-		name:          "Test Case 4a - 1st of the month, prev 28 days (3 months coverage), none existing",
-		testTime:     time.Date(2023, 3, 1, 23, 54, 21, 0, time.UTC),
-		nextMonth:    time.Date(2022, 12, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{},
-		filterDates: []string{
-			"2023-03-01", "2023-02-28", "2023-02-27", "2023-02-26", "2023-02-25", "2023-02-24",
-			"2023-02-23", "2023-02-22", "2023-02-21", "2023-02-20", "2023-02-19", "2023-02-18",
-			"2023-02-17", "2023-02-16", "2023-02-15", "2023-02-14", "2023-02-13", "2023-02-12",
-			"2023-02-11", "2023-02-10", "2023-02-09", "2023-02-08", "2023-02-07", "2023-02-06",
-			"2023-02-05", "2023-02-04", "2023-02-03", "2023-02-02", "2023-02-01",
-			// complete January
-			"2023-01",
-		},
-	},
-	{
-		name:       "Test Case 5a - 30th of the month, has >30 days (only 1 month coverage), all existing",
-		testTime:  time.Date(2024, 4, 30, 23, 54, 21, 0, time.UTC),
-		nextMonth: time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2024-04-30_23-54", "2024-04-29_23-54", "2024-04-28_23-54", "2024-04-27_23-54", "2024-04-26_23-54", "2024-04-25_23-54",
-			"2024-04-24_23-54", "2024-04-23_23-54", "2024-04-22_23-54", "2024-04-21_23-54", "2024-04-20_23-54", "2024-04-19_23-54",
-			"2024-04-18_23-54", "2024-04-17_23-54", "2024-04-16_23-54", "2024-04-15_23-54", "2024-04-14_23-54", "2024-04-13_23-54",
-			"2024-04-12_23-54", "2024-04-11_23-54", "2024-04-10_23-54", "2024-04-09_23-54", "2024-04-08_23-54", "2024-04-07_23-54",
-			"2024-04-06_23-54", "2024-04-05_23-54", "2024-04-04_23-54", "2024-04-03_23-54", "2024-04-02_23-54", "2024-04-01_23-54",
-			"2024-03-31_23-54", "2024-03-30_23-54", "2024-03-29_23-54", "2024-03-28_23-54", "2024-03-27_23-54", "2024-03-26_23-54",
-		},
-		filterDates: []string{
-			"2024-04-30", "2024-04-29", "2024-04-28", "2024-04-27", "2024-04-26", "2024-04-25",
-			"2024-04-24", "2024-04-23", "2024-04-22", "2024-04-21", "2024-04-20", "2024-04-19",
-			"2024-04-18", "2024-04-17", "2024-04-16", "2024-04-15", "2024-04-14", "2024-04-13",
-			"2024-04-12", "2024-04-11", "2024-04-10", "2024-04-09", "2024-04-08", "2024-04-07",
-			"2024-04-06", "2024-04-05", "2024-04-04", "2024-04-03", "2024-04-02", "2024-04-01",
-		},
-	},
-	{
-		name:          "Test Case 5b - 30th of the month, has 30 days (only 1 month coverage), none existing",
-		testTime:     time.Date(2024, 4, 30, 23, 54, 21, 0, time.UTC),
-		nextMonth:    time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{},
-		filterDates: []string{
-			"2024-04-30", "2024-04-29", "2024-04-28", "2024-04-27", "2024-04-26", "2024-04-25",
-			"2024-04-24", "2024-04-23", "2024-04-22", "2024-04-21", "2024-04-20", "2024-04-19",
-			"2024-04-18", "2024-04-17", "2024-04-16", "2024-04-15", "2024-04-14", "2024-04-13",
-			"2024-04-12", "2024-04-11", "2024-04-10", "2024-04-09", "2024-04-08", "2024-04-07",
-			"2024-04-06", "2024-04-05", "2024-04-04", "2024-04-03", "2024-04-02", "2024-04-01",
-		},
-	},
-	{
-		name:          "Test Case 5c - 30th of the month, has 31 days (only 1 month coverage), none existing",
-		testTime:     time.Date(2024, 5, 30, 23, 54, 21, 0, time.UTC),
-		nextMonth:    time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{},
-		filterDates: []string{
-			"2024-05-30", "2024-05-29", "2024-05-28", "2024-05-27", "2024-05-26", "2024-05-25",
-			"2024-05-24", "2024-05-23", "2024-05-22", "2024-05-21", "2024-05-20", "2024-05-19",
-			"2024-05-18", "2024-05-17", "2024-05-16", "2024-05-15", "2024-05-14", "2024-05-13",
-			"2024-05-12", "2024-05-11", "2024-05-10", "2024-05-09", "2024-05-08", "2024-05-07",
-			"2024-05-06", "2024-05-05", "2024-05-04", "2024-05-03", "2024-05-02", "2024-05-01",
-		},
-	},
-	{
-		name:       "Test Case 6a - 31st of the month, all existing",
-		testTime:  time.Date(2024, 5, 31, 23, 54, 21, 0, time.UTC),
-		nextMonth: time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2024-05-31_23-54", "2024-05-30_23-54", "2024-05-29_23-54", "2024-05-28_23-54", "2024-05-27_23-54", "2024-05-26_23-54",
-			"2024-05-25_23-54", "2024-05-24_23-54", "2024-05-23_23-54", "2024-05-22_23-54", "2024-05-21_23-54", "2024-05-20_23-54",
-			"2024-05-19_23-54", "2024-05-18_23-54", "2024-05-17_23-54", "2024-05-16_23-54", "2024-05-15_23-54", "2024-05-14_23-54",
-			"2024-05-13_23-54", "2024-05-12_23-54", "2024-05-11_23-54", "2024-05-10_23-54", "2024-05-09_23-54", "2024-05-08_23-54",
-			"2024-05-07_23-54", "2024-05-06_23-54", "2024-05-05_23-54", "2024-05-04_23-54", "2024-05-03_23-54", "2024-05-02_23-54",
-			"2024-05-01_23-54", "2024-04-30_23-54", "2024-04-29_23-54", "2024-04-28_23-54", "2024-04-27_23-54", "2024-04-26_23-54",
-		},
-		filterDates: []string{
-			"2024-05-31", "2024-05-30", "2024-05-29", "2024-05-28", "2024-05-27", "2024-05-26",
-			"2024-05-25", "2024-05-24", "2024-05-23", "2024-05-22", "2024-05-21", "2024-05-20",
-			"2024-05-19", "2024-05-18", "2024-05-17", "2024-05-16", "2024-05-15", "2024-05-14",
-			"2024-05-13", "2024-05-12", "2024-05-11", "2024-05-10", "2024-05-09", "2024-05-08",
-			"2024-05-07", "2024-05-06", "2024-05-05", "2024-05-04", "2024-05-03", "2024-05-02",
-		},
-	},
-	{
-		name:          "Test Case 6b - 31st of the month, none existing",
-		testTime:     time.Date(2024, 5, 31, 23, 54, 21, 0, time.UTC),
-		nextMonth:    time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{},
-		filterDates: []string{
-			"2024-05",
-		},
-	},
-	{
-		name:       "Test Case 7a - 2nd of the month, prev 28 days, all existing",
-		testTime:  time.Date(2023, 3, 2, 20, 34, 58, 0, time.UTC),
-		nextMonth: time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2023-03-02_20-34", "2023-03-01_20-34", "2023-02-28_20-34", "2023-02-27_20-34", "2023-02-26_20-34", "2023-02-25_20-34",
-			"2023-02-24_20-34", "2023-02-23_20-34", "2023-02-22_20-34", "2023-02-21_20-34", "2023-02-20_20-34", "2023-02-19_20-34",
-			"2023-02-18_20-34", "2023-02-17_20-34", "2023-02-16_20-34", "2023-02-15_20-34", "2023-02-14_20-34", "2023-02-13_20-34",
-			"2023-02-12_20-34", "2023-02-11_20-34", "2023-02-10_20-34", "2023-02-09_20-34", "2023-02-08_20-34", "2023-02-07_20-34",
-			"2023-02-06_20-34", "2023-02-05_20-34", "2023-02-04_20-34", "2023-02-03_20-34", "2023-02-02_20-34", "2023-02-01_20-34",
-			"2023-01-31_20-34", "2023-01-30_20-34", "2023-01-29_20-34", "2023-01-28_20-34", "2023-01-27_20-34", "2023-01-26_20-34",
-		},
-		filterDates: []string{
-			"2023-03-02", "2023-03-01", "2023-02-28", "2023-02-27", "2023-02-26", "2023-02-25",
-			"2023-02-24", "2023-02-23", "2023-02-22", "2023-02-21", "2023-02-20", "2023-02-19",
-			"2023-02-18", "2023-02-17", "2023-02-16", "2023-02-15", "2023-02-14", "2023-02-13",
-			"2023-02-12", "2023-02-11", "2023-02-10", "2023-02-09", "2023-02-08", "2023-02-07",
-			"2023-02-06", "2023-02-05", "2023-02-04", "2023-02-03", "2023-02-02", "2023-02-01",
-		},
-	},
-	{
-		name:       "Test Case 7b - 2nd of the month, prev 29 days, all existing",
-		testTime:  time.Date(2024, 3, 2, 23, 54, 21, 0, time.UTC),
-		nextMonth: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
-		existingDirs: []string{
-			"2024-03-02_23-54", "2024-03-01_23-54", "2024-02-29_23-54", "2024-02-28_23-54", "2024-02-27_23-54", "2024-02-26_23-54",
-			"2024-02-25_23-54", "2024-02-24_23-54", "2024-02-23_23-54", "2024-02-22_23-54", "2024-02-21_23-54", "2024-02-20_23-54",
-			"2024-02-19_23-54", "2024-02-18_23-54", "2024-02-17_23-54", "2024-02-16_23-54", "2024-02-15_23-54", "2024-02-14_23-54",
-			"2024-02-13_23-54", "2024-02-12_23-54", "2024-02-11_23-54", "2024-02-10_23-54", "2024-02-09_23-54", "2024-02-08_23-54",
-			"2024-02-07_23-54", "2024-02-06_23-54", "2024-02-05_23-54", "2024-02-04_23-54", "2024-02-03_23-54", "2024-02-02_23-54",
-			"2024-02-01_23-54", "2024-01-31_23-54", "2024-01-30_23-54", "2024-01-29_23-54", "2024-01-28_23-54", "2024-01-27_23-54",
-		},
-		filterDates: []string{
-			"2024-03-02", "2024-03-01", "2024-02-29", "2024-02-28", "2024-02-27", "2024-02-26",
-			"2024-02-25", "2024-02-24", "2024-02-23", "2024-02-22", "2024-02-21", "2024-02-20",
-			"2024-02-19", "2024-02-18", "2024-02-17", "2024-02-16", "2024-02-15", "2024-02-14",
-			"2024-02-13", "2024-02-12", "2024-02-11", "2024-02-10", "2024-02-09", "2024-02-08",
-			"2024-02-07", "2024-02-06", "2024-02-05", "2024-02-04", "2024-02-03", "2024-02-02",
-		},
-	},
-}
-
-func Test_getFiltersForHourlies(t *testing.T) {
-	for _, tt := range testsForHourlies {
-		t.Run(tt.name, func(t *testing.T) {
-			expected := append([]string{}, tt.filterDatesToday...)
-			expected = append(expected, tt.filterDatesYesterday...)
-			got := getFiltersForHourlies(tt.testTime, tt.existingDirs)
-			if !reflect.DeepEqual(got, expected) {
-				compareArrays(got, expected, t)
-				t.Errorf("getFiltersForHourlies() result not as expected!")
-			}
-		})
-	}
-}
-
-func Test_getFiltersForToday(t *testing.T) {
-	for _, tt := range testsForHourlies {
-		t.Run(tt.name, func(t *testing.T) {
-			got := getFiltersForToday(tt.testTime)
-			if !reflect.DeepEqual(got, tt.filterDatesToday) {
-				compareArrays(got, tt.filterDatesToday, t)
-				t.Errorf("getFiltersForToday() result not as expected!")
-			}
-		})
-	}
-}
-
-func Test_getFiltersForYesterday(t *testing.T) {
-	for _, tt := range testsForHourlies {
-		t.Run(tt.name, func(t *testing.T) {
-			remaining := 24 - len(tt.filterDatesToday)
-			got := getFiltersForYesterday(tt.testTime, remaining, tt.existingDirs)
-			if !reflect.DeepEqual(got, tt.filterDatesYesterday) {
-				compareArrays(got, tt.filterDatesYesterday, t)
-				t.Errorf("getFiltersForYesterday() result not as expected!")
-			}
-		})
-	}
-}
-
-var testsForHourlies = []struct {
-	name                   string
-	testTime              time.Time
-	existingDirs          []string
-	extraDailyNeeded     bool
-	extraDaily            string
-	filterDatesToday     []string
-	filterDatesYesterday []string
-}{
-	{
-		name:      "Test Case 1 - all and more, with Feb in leap year",
-		testTime: time.Date(2024, 3, 1, 20, 34, 58, 0, time.UTC),
-		existingDirs: []string{
-			"2024-03-01_20-13", "2024-03-01_19-13", "2024-03-01_18-13", "2024-03-01_17-13", "2024-03-01_16-13", "2024-03-01_15-13" /* extra: */, "2024-03-01_15-03",
-			"2024-03-01_14-13", "2024-03-01_13-13", "2024-03-01_12-13", "2024-03-01_11-13", "2024-03-01_10-13", "2024-03-01_09-13",
-			"2024-03-01_08-13", "2024-03-01_07-13", "2024-03-01_06-13", "2024-03-01_05-13", "2024-03-01_04-13", "2024-03-01_03-13",
-			"2024-03-01_02-13", "2024-03-01_01-13", "2024-03-01_00-13", "2024-02-29_23-13", "2024-02-29_22-13", "2024-02-29_21-13",
-			// extra:
-			"2024-02-29_20-13", "2024-02-29_19-13",
-		},
-		extraDailyNeeded: false,
-		extraDaily:        "2024-02-29",
-		filterDatesToday: []string{
-			"2024-03-01_20", "2024-03-01_19", "2024-03-01_18", "2024-03-01_17", "2024-03-01_16", "2024-03-01_15",
-			"2024-03-01_14", "2024-03-01_13", "2024-03-01_12", "2024-03-01_11", "2024-03-01_10", "2024-03-01_09",
-			"2024-03-01_08", "2024-03-01_07", "2024-03-01_06", "2024-03-01_05", "2024-03-01_04", "2024-03-01_03",
-			"2024-03-01_02", "2024-03-01_01", "2024-03-01_00", /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/
-		},
-		filterDatesYesterday: []string{
-			/*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ "2024-02-29_23", "2024-02-29_22", "2024-02-29_21",
-		},
-	},
-	{
-		name:               "Test Case 2 - no existing dirs, with Feb in leap year",
-		testTime:          time.Date(2024, 3, 1, 20, 34, 58, 0, time.UTC),
-		existingDirs:      []string{},
-		extraDailyNeeded: true,
-		extraDaily:        "2024-02-29",
-		filterDatesToday: []string{
-			"2024-03-01_20", "2024-03-01_19", "2024-03-01_18", "2024-03-01_17", "2024-03-01_16", "2024-03-01_15",
-			"2024-03-01_14", "2024-03-01_13", "2024-03-01_12", "2024-03-01_11", "2024-03-01_10", "2024-03-01_09",
-			"2024-03-01_08", "2024-03-01_07", "2024-03-01_06", "2024-03-01_05", "2024-03-01_04", "2024-03-01_03",
-			"2024-03-01_02", "2024-03-01_01", "2024-03-01_00", /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/
-		},
-		filterDatesYesterday: []string{
-			"2024-02-29",
-		},
-	},
-	{
-		name:      "Test Case 3 - sparse, one hit yesterday, with Feb in leap year",
-		testTime: time.Date(2024, 3, 1, 20, 34, 58, 0, time.UTC),
-		existingDirs: []string{
-			/*XXXXXXXXXXXXXXX*/ "2024-03-01_19-13", "2024-03-01_18-13" /*XXXXXXXXXXXXXXX*/, "2024-03-01_16-13", /*XXXXXXXXXXXXXXX*/
-			"2024-03-01_14-13" /*XXXXXXXXXXXXXXX*/, "2024-03-01_12-13", /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/
-			/*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ "2024-03-01_05-13", "2024-03-01_04-13", /*XXXXXXXXXXXXXXX*/
-			"2024-03-01_02-13", "2024-03-01_01-13" /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/, "2024-02-29_21-13",
-			// extra:
-			/*XXXXXXXXXXXXXXX*/ "2024-02-29_19-13",
-		},
-		extraDailyNeeded: false,
-		extraDaily:        "2024-02-29",
-		filterDatesToday: []string{
-			"2024-03-01_20", "2024-03-01_19", "2024-03-01_18", "2024-03-01_17", "2024-03-01_16", "2024-03-01_15",
-			"2024-03-01_14", "2024-03-01_13", "2024-03-01_12", "2024-03-01_11", "2024-03-01_10", "2024-03-01_09",
-			"2024-03-01_08", "2024-03-01_07", "2024-03-01_06", "2024-03-01_05", "2024-03-01_04", "2024-03-01_03",
-			"2024-03-01_02", "2024-03-01_01", "2024-03-01_00", /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/
-		},
-		filterDatesYesterday: []string{
-			/*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ "2024-02-29_23", "2024-02-29_22", "2024-02-29_21",
-		},
-	},
-	{
-		name:      "Test Case 4 - sparse, no hit yesterday, with Feb in leap year",
-		testTime: time.Date(2024, 3, 1, 20, 34, 58, 0, time.UTC),
-		existingDirs: []string{
-			/*XXXXXXXXXXXXXXX*/ "2024-03-01_19-13", "2024-03-01_18-13" /*XXXXXXXXXXXXXXX*/, "2024-03-01_16-13", /*XXXXXXXXXXXXXXX*/
-			"2024-03-01_14-13" /*XXXXXXXXXXXXXXX*/, "2024-03-01_12-13", /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/
-			/*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ "2024-03-01_05-13", "2024-03-01_04-13", /*XXXXXXXXXXXXXXX*/
-			"2024-03-01_02-13", "2024-03-01_01-13", /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/ /*XXXXXXXXXXXXXXX*/
-			// extra:
-			/*XXXXXXXXXXXXXXX*/ "2024-02-29_19-13",
-		},
-		extraDailyNeeded: true,
-		extraDaily:        "2024-02-29",
-		filterDatesToday: []string{
-			"2024-03-01_20", "2024-03-01_19", "2024-03-01_18", "2024-03-01_17", "2024-03-01_16", "2024-03-01_15",
-			"2024-03-01_14", "2024-03-01_13", "2024-03-01_12", "2024-03-01_11", "2024-03-01_10", "2024-03-01_09",
-			"2024-03-01_08", "2024-03-01_07", "2024-03-01_06", "2024-03-01_05", "2024-03-01_04", "2024-03-01_03",
-			"2024-03-01_02", "2024-03-01_01", "2024-03-01_00", /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/ /*XXXXXXXXXXXX*/
-		},
-		filterDatesYesterday: []string{
-			"2024-02-29",
-		},
-	},
-	{
-		name:      "Test Case 5 - 24 on a day, with Feb in leap year",
-		testTime: time.Date(2024, 3, 1, 23, 34, 58, 0, time.UTC),
-		existingDirs: []string{
-			"2024-03-01_23-13", "2024-03-01_22-13", "2024-03-01_21-13", "2024-03-01_20-13", "2024-03-01_19-13", "2024-03-01_18-13",
-			"2024-03-01_17-13", "2024-03-01_16-13", "2024-03-01_15-13", "2024-03-01_14-13", "2024-03-01_13-13", "2024-03-01_12-13",
-			"2024-03-01_11-13", "2024-03-01_10-13", "2024-03-01_09-13", "2024-03-01_08-13", "2024-03-01_07-13", "2024-03-01_06-13",
-			"2024-03-01_05-13", "2024-03-01_04-13", "2024-03-01_03-13", "2024-03-01_02-13", "2024-03-01_01-13", "2024-03-01_00-13",
-			// extra:
-			"2024-02-29_23-13", "2024-02-29_22-13",
-		},
-		extraDailyNeeded: true,
-		extraDaily:        "2024-02-29",
-		filterDatesToday: []string{
-
-			"2024-03-01_23", "2024-03-01_22", "2024-03-01_21", "2024-03-01_20", "2024-03-01_19", "2024-03-01_18",
-			"2024-03-01_17", "2024-03-01_16", "2024-03-01_15", "2024-03-01_14", "2024-03-01_13", "2024-03-01_12",
-			"2024-03-01_11", "2024-03-01_10", "2024-03-01_09", "2024-03-01_08", "2024-03-01_07", "2024-03-01_06",
-			"2024-03-01_05", "2024-03-01_04", "2024-03-01_03", "2024-03-01_02", "2024-03-01_01", "2024-03-01_00",
-		},
-		filterDatesYesterday: []string{
-			"2024-02-29",
-		},
-	},
-	{
-		name:      "Test Case 6 - 00 o'clock",
-		testTime: time.Date(2024, 3, 2, 0, 34, 58, 0, time.UTC),
-		existingDirs: []string{
-			"2024-03-02_00-13",
-			"2024-03-01_23-13", "2024-03-01_22-13", "2024-03-01_21-13", "2024-03-01_20-13", "2024-03-01_19-13", "2024-03-01_18-13",
-			"2024-03-01_17-13", "2024-03-01_16-13", "2024-03-01_15-13", "2024-03-01_14-13", "2024-03-01_13-13", "2024-03-01_12-13",
-			"2024-03-01_11-13", "2024-03-01_10-13", "2024-03-01_09-13", "2024-03-01_08-13", "2024-03-01_07-13", "2024-03-01_06-13",
-			"2024-03-01_05-13", "2024-03-01_04-13", "2024-03-01_03-13", "2024-03-01_02-13", "2024-03-01_01-13", "2024-03-01_00-13",
-			// extra:
-			"2024-02-29_23-13", "2024-02-29_22-13",
-		},
-		extraDailyNeeded: false,
-		extraDaily:        "2024-03-01",
-		filterDatesToday: []string{
-			"2024-03-02_00",
-		},
-		filterDatesYesterday: []string{
-			"2024-03-01_23", "2024-03-01_22", "2024-03-01_21", "2024-03-01_20", "2024-03-01_19", "2024-03-01_18",
-			"2024-03-01_17", "2024-03-01_16", "2024-03-01_15", "2024-03-01_14", "2024-03-01_13", "2024-03-01_12",
-			"2024-03-01_11", "2024-03-01_10", "2024-03-01_09", "2024-03-01_08", "2024-03-01_07", "2024-03-01_06",
-			"2024-03-01_05", "2024-03-01_04", "2024-03-01_03", "2024-03-01_02", "2024-03-01_01",
-		},
-	},
-}
-
-var testsForAllFilters = []struct {
-	name             string
-	testTime        time.Time
-	existingDirs    []string
-	expectedFilters []string
-}{
-	{
-		name:      "Test Case 1 - all there",
-		testTime: time.Date(2024, 3, 1, 20, 34, 58, 0, time.UTC),
-		existingDirs: []string{
-			// 24 hourlys
-			"2024-03-01_20-13", "2024-03-01_19-13", "2024-03-01_18-13", "2024-03-01_17-13", "2024-03-01_16-13", "2024-03-01_15-13",
-			"2024-03-01_14-13", "2024-03-01_13-13", "2024-03-01_12-13", "2024-03-01_11-13", "2024-03-01_10-13", "2024-03-01_09-13",
-			"2024-03-01_08-13", "2024-03-01_07-13", "2024-03-01_06-13", "2024-03-01_05-13", "2024-03-01_04-13", "2024-03-01_03-13",
-			"2024-03-01_02-13", "2024-03-01_01-13", "2024-03-01_00-13", "2024-02-29_23-13", "2024-02-29_22-13", "2024-02-29_21-13",
-			// 32 dailys
-			"2024-02-28_23-13", "2024-02-27_23-13", "2024-02-26_23-13", "2024-02-25_23-13", "2024-02-24_23-13", "2024-02-23_23-13",
-			"2024-02-22_23-13", "2024-02-21_23-13", "2024-02-20_23-13", "2024-02-19_23-13", "2024-02-18_23-13", "2024-02-17_23-13",
-			"2024-02-16_23-13", "2024-02-15_23-13", "2024-02-14_23-13", "2024-02-13_23-13", "2024-02-12_23-13", "2024-02-11_23-13",
-			"2024-02-10_23-13", "2024-02-09_23-13", "2024-02-08_23-13", "2024-02-07_23-13", "2024-02-06_23-13", "2024-02-05_23-13",
-			"2024-02-04_23-13", "2024-02-03_23-13", "2024-02-02_23-13", "2024-02-01_23-13", "2024-01-31_23-13", "2024-01-30_23-13",
-			"2024-01-29_23-13", "2024-01-28_23-13",
-			// 6 monthlys
-			"2023-12-28_23-13", "2023-11-28_23-13", "2023-10-28_23-13", "2023-09-28_23-13", "2023-08-28_23-13", "2023-07-28_23-13",
-		},
-		expectedFilters: []string{
-			// 24 for the hours
-			"2024-03-01_20", "2024-03-01_19", "2024-03-01_18", "2024-03-01_17", "2024-03-01_16", "2024-03-01_15",
-			"2024-03-01_14", "2024-03-01_13", "2024-03-01_12", "2024-03-01_11", "2024-03-01_10", "2024-03-01_09",
-			"2024-03-01_08", "2024-03-01_07", "2024-03-01_06", "2024-03-01_05", "2024-03-01_04", "2024-03-01_03",
-			"2024-03-01_02", "2024-03-01_01", "2024-03-01_00", "2024-02-29_23", "2024-02-29_22", "2024-02-29_21",
-			// 30 for the days
-			"2024-02-28", "2024-02-27", "2024-02-26", "2024-02-25", "2024-02-24", "2024-02-23", "2024-02-22", "2024-02-21", "2024-02-20", "2024-02-19",
-			"2024-02-18", "2024-02-17", "2024-02-16", "2024-02-15", "2024-02-14", "2024-02-13", "2024-02-12", "2024-02-11", "2024-02-10", "2024-02-09",
-			"2024-02-08", "2024-02-07", "2024-02-06", "2024-02-05", "2024-02-04", "2024-02-03", "2024-02-02", "2024-02-01", "2024-01-31", "2024-01-30",
-			// 119 for the normal monthlys
-			"2023-12", "2023-11", "2023-10", "2023-09", "2023-08", "2023-07", "2023-06", "2023-05", "2023-04", "2023-03", "2023-02", "2023-01",
-			"2022-12", "2022-11", "2022-10", "2022-09", "2022-08", "2022-07", "2022-06", "2022-05", "2022-04", "2022-03", "2022-02", "2022-01",
-			"2021-12", "2021-11", "2021-10", "2021-09", "2021-08", "2021-07", "2021-06", "2021-05", "2021-04", "2021-03", "2021-02", "2021-01",
-			"2020-12", "2020-11", "2020-10", "2020-09", "2020-08", "2020-07", "2020-06", "2020-05", "2020-04", "2020-03", "2020-02", "2020-01",
-			"2019-12", "2019-11", "2019-10", "2019-09", "2019-08", "2019-07", "2019-06", "2019-05", "2019-04", "2019-03", "2019-02", "2019-01",
-			"2018-12", "2018-11", "2018-10", "2018-09", "2018-08", "2018-07", "2018-06", "2018-05", "2018-04", "2018-03", "2018-02", "2018-01",
-			"2017-12", "2017-11", "2017-10", "2017-09", "2017-08", "2017-07", "2017-06", "2017-05", "2017-04", "2017-03", "2017-02", "2017-01",
-			"2016-12", "2016-11", "2016-10", "2016-09", "2016-08", "2016-07", "2016-06", "2016-05", "2016-04", "2016-03", "2016-02", "2016-01",
-			"2015-12", "2015-11", "2015-10", "2015-09", "2015-08", "2015-07", "2015-06", "2015-05", "2015-04", "2015-03", "2015-02", "2015-01",
-			"2014-12", "2014-11", "2014-10", "2014-09", "2014-08", "2014-07", "2014-06", "2014-05", "2014-04", "2014-03", "2014-02",
-		},
-	},
-	{
-		name:      "Test Case 2 - no Hourlys for the 29th of February, no January Dailys",
-		testTime: time.Date(2024, 3, 1, 20, 34, 58, 0, time.UTC),
-		existingDirs: []string{
-			// hourlys - none for the 29th
-			"2024-03-01_20-13", "2024-03-01_19-13", "2024-03-01_18-13", "2024-03-01_17-13", "2024-03-01_16-13", "2024-03-01_15-13",
-			"2024-03-01_14-13", "2024-03-01_13-13", "2024-03-01_12-13", "2024-03-01_11-13", "2024-03-01_10-13", "2024-03-01_09-13",
-			"2024-03-01_08-13", "2024-03-01_07-13", "2024-03-01_06-13", "2024-03-01_05-13", "2024-03-01_04-13", "2024-03-01_03-13",
-			"2024-03-01_02-13", "2024-03-01_01-13", "2024-03-01_00-13",
-			// dailys - none in January but 1.1.
-			"2024-02-28_23-13", "2024-02-27_23-13", "2024-02-26_23-13", "2024-02-25_23-13", "2024-02-24_23-13", "2024-02-23_23-13",
-			"2024-02-22_23-13", "2024-02-21_23-13", "2024-02-20_23-13", "2024-02-19_23-13", "2024-02-18_23-13", "2024-02-17_23-13",
-			"2024-02-16_23-13", "2024-02-15_23-13", "2024-02-14_23-13", "2024-02-13_23-13", "2024-02-12_23-13", "2024-02-11_23-13",
-			"2024-02-10_23-13", "2024-02-09_23-13", "2024-02-08_23-13", "2024-02-07_23-13", "2024-02-06_23-13", "2024-02-05_23-13",
-			"2024-02-04_23-13", "2024-02-03_23-13", "2024-02-02_23-13", "2024-02-01_23-13",
-			"2024-01-01_23-13",
-			// 6 monthlys
-			"2023-12-28_23-13", "2023-11-28_23-13", "2023-10-28_23-13", "2023-09-28_23-13", "2023-08-28_23-13", "2023-07-28_23-13",
-		},
-		expectedFilters: []string{
-			// 21 Hourlys for the 3.1.
-			"2024-03-01_20", "2024-03-01_19", "2024-03-01_18", "2024-03-01_17", "2024-03-01_16", "2024-03-01_15",
-			"2024-03-01_14", "2024-03-01_13", "2024-03-01_12", "2024-03-01_11", "2024-03-01_10", "2024-03-01_09",
-			"2024-03-01_08", "2024-03-01_07", "2024-03-01_06", "2024-03-01_05", "2024-03-01_04", "2024-03-01_03",
-			"2024-03-01_02", "2024-03-01_01", "2024-03-01_00",
-			// 1 Daily for the 29th
-			"2024-02-29",
-			// 28 for the days
-			"2024-02-28", "2024-02-27", "2024-02-26", "2024-02-25", "2024-02-24", "2024-02-23", "2024-02-22", "2024-02-21", "2024-02-20", "2024-02-19",
-			"2024-02-18", "2024-02-17", "2024-02-16", "2024-02-15", "2024-02-14", "2024-02-13", "2024-02-12", "2024-02-11", "2024-02-10", "2024-02-09",
-			"2024-02-08", "2024-02-07", "2024-02-06", "2024-02-05", "2024-02-04", "2024-02-03", "2024-02-02", "2024-02-01",
-			// 1 monthly for January
-			"2024-01",
-			// 119 for the normal monthlys
-			"2023-12", "2023-11", "2023-10", "2023-09", "2023-08", "2023-07", "2023-06", "2023-05", "2023-04", "2023-03", "2023-02", "2023-01",
-			"2022-12", "2022-11", "2022-10", "2022-09", "2022-08", "2022-07", "2022-06", "2022-05", "2022-04", "2022-03", "2022-02", "2022-01",
-			"2021-12", "2021-11", "2021-10", "2021-09", "2021-08", "2021-07", "2021-06", "2021-05", "2021-04", "2021-03", "2021-02", "2021-01",
-			"2020-12", "2020-11", "2020-10", "2020-09", "2020-08", "2020-07", "2020-06", "2020-05", "2020-04", "2020-03", "2020-02", "2020-01",
-			"2019-12", "2019-11", "2019-10", "2019-09", "2019-08", "2019-07", "2019-06", "2019-05", "2019-04", "2019-03", "2019-02", "2019-01",
-			"2018-12", "2018-11", "2018-10", "2018-09", "2018-08", "2018-07", "2018-06", "2018-05", "2018-04", "2018-03", "2018-02", "2018-01",
-			"2017-12", "2017-11", "2017-10", "2017-09", "2017-08", "2017-07", "2017-06", "2017-05", "2017-04", "2017-03", "2017-02", "2017-01",
-			"2016-12", "2016-11", "2016-10", "2016-09", "2016-08", "2016-07", "2016-06", "2016-05", "2016-04", "2016-03", "2016-02", "2016-01",
-			"2015-12", "2015-11", "2015-10", "2015-09", "2015-08", "2015-07", "2015-06", "2015-05", "2015-04", "2015-03", "2015-02", "2015-01",
-			"2014-12", "2014-11", "2014-10", "2014-09", "2014-08", "2014-07", "2014-06", "2014-05", "2014-04", "2014-03", "2014-02",
-		},
-	},
-}
-
-func Test_getAllFilters(t *testing.T) {
-	for _, tt := range testsForAllFilters {
-		t.Run(tt.name, func(t *testing.T) {
-			got := getAllFilters(tt.testTime, tt.existingDirs)
-			if !reflect.DeepEqual(got, tt.expectedFilters) {
-				compareArrays(got, tt.expectedFilters, t)
-				t.Errorf("getAllFilters() result not as expected!")
-			}
-		})
-	}
-}
-
-func Test_getDateDirectoriesNotMatchingAnyPrefix(t *testing.T) {
-	tests := []struct {
-		name     string
-		allDirs  []string
-		prefixes []string
-		want     []string
-	}{
-		{
-			name:     "Test 1: No directory matches the prefix",
-			allDirs:  []string{"2024-06-16test", "2024-06-17test", "2024-06-18test", "to_delete"},
-			prefixes: []string{"2025"},
-			want:     []string{"2024-06-16test", "2024-06-17test", "2024-06-18test"},
-		},
-		{
-			name:     "Test 2: Some directories match the prefix",
-			allDirs:  []string{"2024-06-16test", "2024-06-17test", "2024-06-18test"},
-			prefixes: []string{"2024-06-16"},
-			want:     []string{"2024-06-17test", "2024-06-18test"},
-		},
-		{
-			name:     "Test 3: All directories match the prefix",
-			allDirs:  []string{"2024-06-16test", "2024-06-17test", "2024-06-18test"},
-			prefixes: []string{"2024"},
-			want:     []string{},
-		},
-		{
-			name:     "Test 4: No directory matches any prefix",
-			allDirs:  []string{"2024-06-16test", "2024-06-17test", "2024-06-18test", "to_delete"},
-			prefixes: []string{"2025", "2027"},
-			want:     []string{"2024-06-16test", "2024-06-17test", "2024-06-18test"},
-		},
-		{
-			name:     "Test 5: Some directories match some prefixes",
-			allDirs:  []string{"2024-06-16test", "2024-06-17test", "2024-06-18test", "hello"},
-			prefixes: []string{"2027", "2024-06-16", "2024-06-18"},
-			want:     []string{"2024-06-17test"},
-		},
-		{
-			name:     "Test 6: All directories match a prefix",
-			allDirs:  []string{"2024-06-16test", "2024-06-17test", "2024-06-18test"},
-			prefixes: []string{"2024-06-18", "2027", "2024-06-16", "2024-06-17"},
-			want:     []string{},
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := getDateDirectoriesNotMatchingAnyPrefix(tt.allDirs, tt.prefixes, 0); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("getDateDirectoriesNotMatchingAnyPrefix() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func Test_getDateDirectoriesNotMatchingAnyPrefix_Verbosity(t *testing.T) {
-	// Save the original stdout
-	originalStdout := os.Stdout
-
-	// Create a pipe to capture the output
-	r, w, _ := os.Pipe()
-	os.Stdout = w
+func Test_pruneDirectory_SkippingVerbosity(t *testing.T) {
+	test_dir := generateTestDirectories(t, []string{"a", "b", "2024-06-17_09-49"})
+	defer func() {
+		_ = os.RemoveAll(test_dir) // clean up
+	}()
 
 	// no output for verbosity 1
-	getDateDirectoriesNotMatchingAnyPrefix([]string{"a", "b", "c"}, []string{}, 1)
+	output := captureOutput(func() {
+		_ = pruneDirectory(test_dir, time.Now(), "to_delete", 1, false)
+	})
+	unexpectOutput(t, output, "Skipping")
 
 	// output for verbosity 2
-	getDateDirectoriesNotMatchingAnyPrefix([]string{"1", "2", "3"}, []string{}, 2)
-
-	// Close the writer and restore the original stdout
-	closerror := w.Close()
-	if closerror != nil {
-		t.Errorf("Error closing writer: %v", closerror)
-	}
-	os.Stdout = originalStdout
-
-	// Read the captured output
-	var buf bytes.Buffer
-	_, err := buf.ReadFrom(r)
-	if err != nil {
-		t.Errorf("Error reading back from stdout: %v", err)
-	}
-	capturedOutput := buf.String()
-
-	// Check if the output is as expected
-	expectedOutput := "Skipping 1 as it is not in date format.\nSkipping 2 as it is not in date format.\nSkipping 3 as it is not in date format.\n"
-	if capturedOutput != expectedOutput {
-		t.Errorf("Expected %q but got %q", expectedOutput, capturedOutput)
+	output = captureOutput(func() {
+		_ = pruneDirectory(test_dir, time.Now(), "to_delete", 2, false)
+	})
+	for _, name := range []string{"to_delete", "b", "a"} {
+		expectOutput(t, output, "Skipping "+name+" as it is not in date format.")
 	}
 }
 
@@ -1195,15 +812,23 @@ func Test_pruneDirectory(t *testing.T) {
 			err = pruneDirectory(pruneDir, relativeTime, "to_delete", 0, false)
 		})
 
-		// Verify: failed moves are logged to stdout AND returned as an error
+		// Verify: the pre-flight checks find all directories that cannot be moved, and nothing is moved
 		if err == nil {
-			t.Fatalf("Expected an error for failed moves, got nil")
+			t.Fatalf("Expected an error for unmovable directories, got nil")
 		}
-		if !strings.Contains(err.Error(), "could not be moved") {
-			t.Fatalf("Expected error to mention failed moves, got %q", err.Error())
+		for _, dir := range []string{"2024-06-17_08-49", "2024-06-17_07-49", "2024-06-16_23-49", "2024-06-15_23-49"} {
+			if !strings.Contains(err.Error(), filepath.Join(pruneDir, dir)+": not writable") {
+				t.Fatalf("Expected error to report %s, got %q", dir, err.Error())
+			}
+			if _, statErr := os.Stat(filepath.Join(pruneDir, dir)); statErr != nil {
+				t.Fatalf("Expected %s to stay: %v", dir, statErr)
+			}
 		}
-		if !strings.Contains(output, "Error moving ") {
-			t.Fatalf("Expected output to contain \"Error moving \", got %q", output)
+		if !strings.Contains(err.Error(), "nothing was pruned") {
+			t.Fatalf("Expected error to state that nothing was pruned, got %q", err.Error())
+		}
+		if strings.Contains(output, "Error moving ") {
+			t.Fatalf("Expected no move to be attempted, got %q", output)
 		}
 	})
 
@@ -1238,14 +863,9 @@ func Test_pruneDirectory(t *testing.T) {
 		if err == nil {
 			t.Fatalf("Expected error, got nil")
 		}
-		if !strings.Contains(err.Error(), "Error creating directory") {
-			t.Fatalf("Expected error to contain %q, got %q", "Error creating directory", err.Error())
-		}
-		if !strings.Contains(err.Error(), "I would have moved the following directories there") {
-			t.Fatalf("Expected error to list directories, got %q", err.Error())
-		}
-		if !strings.Contains(err.Error(), "2024-06-17_08-49") {
-			t.Fatalf("Expected error to name specific directories, got %q", err.Error())
+		// the pre-flight checks find that neither the directory nor the trash directory can be written to
+		if !strings.Contains(err.Error(), "found 1 problems before moving anything") || !strings.Contains(err.Error(), pruneDir+": not writable") {
+			t.Fatalf("Expected error to report %s, got %q", pruneDir, err.Error())
 		}
 	})
 
@@ -1253,7 +873,7 @@ func Test_pruneDirectory(t *testing.T) {
 		if runtime.GOOS == "windows" {
 			t.Skip("This test does not work on Windows")
 		}
-		if !Stats_SupportedOS {
+		if !stats.SupportedOS {
 			t.Skip("stats not supported on this OS")
 		}
 
@@ -1281,10 +901,10 @@ func Test_pruneDirectory(t *testing.T) {
 		})
 
 		if err == nil {
-			t.Fatalf("Expected an error for failed moves, got nil")
+			t.Fatalf("Expected an error for unmovable directories, got nil")
 		}
-		if !strings.Contains(err.Error(), "could not be moved") {
-			t.Fatalf("Expected error to mention failed moves, got %q", err.Error())
+		if !strings.Contains(err.Error(), "nothing was pruned") {
+			t.Fatalf("Expected error to come from the pre-flight checks, got %q", err.Error())
 		}
 	})
 
@@ -1316,11 +936,12 @@ func Test_pruneDirectory(t *testing.T) {
 		})
 
 		if err == nil {
-			t.Fatalf("Expected an error for failed moves, got nil")
+			t.Fatalf("Expected an error for unmovable directories, got nil")
 		}
-		// verbosity=2 prints "Moving X to Y... <raw error>", not the "Error moving" prefix
-		expectOutput(t, output, "Moving ")
+		// the pre-flight checks fail, so no move is attempted or reported
+		unexpectOutput(t, output, "Moved ")
 		unexpectOutput(t, output, "Error moving ")
+		unexpectOutput(t, output, "I moved ")
 	})
 
 	t.Run("Test_pruneDirectory_Success", func(t *testing.T) {
@@ -1365,11 +986,11 @@ func Test_pruneDirectory(t *testing.T) {
 		}
 
 		expectOutput(t, output, "I found 7 directories in ")
-		unexpectOutput(t, output, "Moving ")
+		unexpectOutput(t, output, "Moved ")
 		for _, d := range movedDirs {
 			unexpectOutput(t, output, d)
 		}
-		unexpectOutput(t, output, "... done.")
+		unexpectOutput(t, output, "event=move")
 		expectOutput(t, output, "I moved 4 directories to ")
 
 		// Check that the directories were moved
@@ -1433,11 +1054,11 @@ func Test_pruneDirectory(t *testing.T) {
 		}
 
 		expectOutput(t, output, "I found 6 directories in ")
-		expectOutput(t, output, "Moving ")
+		expectOutput(t, output, "Moved ")
 		for _, d := range movedDirs {
 			expectOutput(t, output, d)
 		}
-		expectOutput(t, output, "... done.")
+		expectOutput(t, output, "event=move")
 		expectOutput(t, output, "I moved 4 directories to ")
 
 	})
@@ -1463,3 +1084,839 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
+
+// fakeSnapshotRunner answers "zfs list" with list and records all other
+// commands.
+type fakeSnapshotRunner struct {
+	list     string
+	commands []string
+}
+
+func (r *fakeSnapshotRunner) Run(name string, args ...string) ([]byte, error) {
+	if name == "zfs" && args[0] == "list" {
+		return []byte(r.list), nil
+	}
+	r.commands = append(r.commands, name+" "+strings.Join(args, " "))
+	return nil, nil
+}
+
+func Test_pruneSnapshots(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	runner := &fakeSnapshotRunner{list: "pool/data@2024-06-17_09-49\npool/data@2024-06-17_09-15\npool/data@manual\n"}
+	driver := newSnapshotDriver("zfs", "pool/data", runner)
+
+	output := captureOutput(func() {
+		if err := pruneSnapshots(driver, now, 2, false); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, "I found 3 snapshots")
+	expectOutput(t, output, "Skipping pool/data@manual as it is not in date format.")
+	expectOutput(t, output, "I would destroy the following 1 snapshots (use --destroy to do so):\n - pool/data@2024-06-17_09-15\n")
+	if len(runner.commands) != 0 {
+		t.Errorf("expected no snapshot to be destroyed without --destroy, got %q", runner.commands)
+	}
+
+	output = captureOutput(func() {
+		if err := pruneSnapshots(driver, now, 2, true); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, "Destroyed pool/data@2024-06-17_09-15.")
+	expectOutput(t, output, "I destroyed 1 snapshots")
+	if !reflect.DeepEqual(runner.commands, []string{"zfs destroy pool/data@2024-06-17_09-15"}) {
+		t.Errorf("unexpected commands %q", runner.commands)
+	}
+
+	if _, ok := newSnapshotDriver("btrfs", "/mnt/snapshots", runner).(snapshot.Btrfs); !ok {
+		t.Errorf("expected a btrfs driver")
+	}
+}
+
+func Test_prunePurgeAfter(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "to_delete/2024-06-01_00-00/etc"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	run := func(now time.Time) string {
+		return captureOutput(func() {
+			err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 2, purgeAfter: days(30)})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	output := run(now)
+	expectOutput(t, output, "I moved 1 directories to "+filepath.Join(dir, "to_delete"))
+	expectOutput(t, output, "Starting quarantine of 2024-06-01_00-00 as it was not moved to the trash by prune_backups.")
+	expectOutput(t, output, "I purged 0 entries from "+filepath.Join(dir, "to_delete")+", 2 are still in quarantine")
+
+	output = run(now.AddDate(0, 0, 31))
+	expectOutput(t, output, "Purged "+filepath.Join(dir, "to_delete", "2024-06-01_00-00")+".")
+	expectOutput(t, output, "Purged "+filepath.Join(dir, "to_delete", "2024-06-17_09-15")+".")
+	expectOutput(t, output, "I purged 2 entries from "+filepath.Join(dir, "to_delete")+", 0 are still in quarantine")
+	entries, err := os.ReadDir(filepath.Join(dir, "to_delete"))
+	if err != nil || len(entries) != 2 || entries[0].Name() != retention.JournalName || entries[1].Name() != retention.TrashIndexName {
+		t.Errorf("expected only the journal and the trash index to remain, got %v, %v", entries, err)
+	}
+}
+
+func TestCLI_PurgeCommand(t *testing.T) {
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "to_delete", "2024-06-17_08-49"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err := parser.Parse([]string{"purge", "--after", "30", "-v", "0", dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ctx.Run(&cli); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	index, err := retention.ReadTrashIndex(vfs.OS{}, filepath.Join(dir, "to_delete"))
+	if err != nil || len(index) != 1 {
+		t.Errorf("expected the quarantine to be recorded, got %v, %v", index, err)
+	}
+
+	ctx, err = parser.Parse([]string{"from", "--purge-after", "30", "--delete", dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ctx.Run(&cli); err == nil || err.Error() != "purge-after flag cannot be combined with the delete flag" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_prunePerRunTrash(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, perRun: true})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	runDir := filepath.Join(dir, "to_delete", "2024-06-17_09-54-21")
+	expectOutput(t, output, "I moved 1 directories to "+runDir)
+	if _, err := os.Stat(filepath.Join(runDir, "2024-06-17_09-15")); err != nil {
+		t.Errorf("expected the snapshot in the run directory: %v", err)
+	}
+	manifest, err := retention.ReadManifest(vfs.OS{}, runDir)
+	if err != nil || !reflect.DeepEqual(manifest.Prune, []string{"2024-06-17_09-15"}) || manifest.Dir != dir {
+		t.Errorf("unexpected manifest %+v, %v", manifest, err)
+	}
+
+	// nothing left to prune, so no run directory is created or named
+	output = captureOutput(func() {
+		err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now.Add(time.Minute), to: "to_delete", verbosity: 1, perRun: true})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, "I moved 0 directories to "+filepath.Join(dir, "to_delete")+"\"")
+	if _, err := os.Stat(filepath.Join(dir, "to_delete", "2024-06-17_09-55-21")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no run directory, got %v", err)
+	}
+
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+	if _, err := parser.Parse([]string{"from", dir}); err != nil || !cli.From.PerRunTrash {
+		t.Errorf("expected per-run trash directories by default, got %v", err)
+	}
+	if _, err := parser.Parse([]string{"from", "--no-per-run-trash", dir}); err != nil || cli.From.PerRunTrash {
+		t.Errorf("expected --no-per-run-trash to disable per-run trash directories, got %v", err)
+	}
+}
+
+func TestCLI_RestoreCommand(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_09-20"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+	if err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", perRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	// the name of one of the pruned snapshots is occupied again
+	if err := os.MkdirAll(filepath.Join(dir, "2024-06-17_09-20"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+	ctx, err := parser.Parse([]string{"restore", "2024-06-17_09-54-21", dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	output := captureOutput(func() {
+		err = ctx.Run(&cli)
+	})
+	if err == nil || err.Error() != "1 of 2 entries of run 2024-06-17_09-54-21 could not be restored" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	expectOutput(t, output, "I restored 1 of 2 entries of run 2024-06-17_09-54-21")
+	expectOutput(t, output, "The restore is partial, 1 entries are still in "+filepath.Join(dir, "to_delete", "2024-06-17_09-54-21"))
+	if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-15")); err != nil {
+		t.Errorf("expected the snapshot to be restored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "to_delete", "2024-06-17_09-54-21", "2024-06-17_09-20")); err != nil {
+		t.Errorf("expected the occupied snapshot to stay in the trash: %v", err)
+	}
+}
+
+func Test_pruneInterruptedRun(t *testing.T) {
+	const run = "2024-06-17_09-54-21"
+	now := time.Date(2024, 6, 17, 10, 54, 21, 0, time.Local)
+	// simulates a run killed after moving 2024-06-17_09-15 but before moving 2024-06-17_09-20
+	interrupted := func(t *testing.T) string {
+		dir := t.TempDir()
+		runDir := filepath.Join(dir, "to_delete", run)
+		for _, name := range []string{"2024-06-17_09-49", "2024-06-17_09-20", filepath.Join("to_delete", run, "2024-06-17_09-15")} {
+			if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rename := func(name string) retention.Rename {
+			return retention.Rename{From: name, To: name}
+		}
+		if err := retention.WriteManifest(vfs.OS{}, runDir, retention.Manifest{Run: run, Dir: dir}); err != nil {
+			t.Fatal(err)
+		}
+		j := retention.Journal{Run: run, InProgress: true, Planned: []retention.Rename{rename("2024-06-17_09-15"), rename("2024-06-17_09-20")}, Renames: []retention.Rename{rename("2024-06-17_09-15")}}
+		if err := retention.WriteJournal(vfs.OS{}, runDir, j); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	t.Run("Refuse", func(t *testing.T) {
+		dir := interrupted(t)
+		err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", perRun: true})
+		if err == nil || !strings.Contains(err.Error(), "run "+run+" in "+filepath.Join(dir, "to_delete")+" was interrupted after 1 of 2 planned moves") || !strings.Contains(err.Error(), "--resume") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-20")); err != nil {
+			t.Errorf("expected nothing to be moved: %v", err)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		dir := interrupted(t)
+		output := captureOutput(func() {
+			err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, perRun: true, resume: true})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+		expectOutput(t, output, "I completed interrupted run "+run+", moving 1 more entries to "+filepath.Join(dir, "to_delete", run))
+		expectOutput(t, output, "I found 2 directories in "+dir)
+		if _, err := os.Stat(filepath.Join(dir, "to_delete", run, "2024-06-17_09-20")); err != nil {
+			t.Errorf("expected the interrupted run to be completed: %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		dir := interrupted(t)
+		output := captureOutput(func() {
+			err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, perRun: true, rollback: true})
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+		expectOutput(t, output, "I rolled back interrupted run "+run+", moving 1 entries back")
+		unexpectOutput(t, output, "I found")
+		for _, name := range []string{"2024-06-17_09-15", "2024-06-17_09-20"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("expected %s in the backup directory: %v", name, err)
+			}
+		}
+	})
+
+	t.Run("NoPerRunTrash", func(t *testing.T) {
+		dir := t.TempDir()
+		trash := filepath.Join(dir, "to_delete")
+		for _, name := range []string{"2024-06-17_09-49", "2024-06-17_09-20", filepath.Join("to_delete", "2024-06-17_09-15")} {
+			if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rename := func(name string) retention.Rename {
+			return retention.Rename{From: name, To: name}
+		}
+		j := retention.Journal{InProgress: true, Planned: []retention.Rename{rename("2024-06-17_09-15"), rename("2024-06-17_09-20")}, Renames: []retention.Rename{rename("2024-06-17_09-15")}}
+		if err := retention.WriteJournal(vfs.OS{}, trash, j); err != nil {
+			t.Fatal(err)
+		}
+
+		err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete"})
+		if err == nil || !strings.Contains(err.Error(), "run in "+trash+" was interrupted after 1 of 2 planned moves") || exitCode(err) != ExitSafety {
+			t.Errorf("unexpected error: %v", err)
+		}
+		output := captureOutput(func() {
+			if err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, resume: true}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+		expectOutput(t, output, "I completed interrupted run, moving 1 more entries to "+trash)
+		if _, err := os.Stat(filepath.Join(trash, "2024-06-17_09-20")); err != nil {
+			t.Errorf("expected the interrupted run to be completed: %v", err)
+		}
+	})
+
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+	if _, err := parser.Parse([]string{"from", "--resume", "--rollback", t.TempDir()}); err == nil {
+		t.Errorf("expected --resume and --rollback to be exclusive")
+	}
+}
+
+func TestCLI_PlanAndApply(t *testing.T) {
+	now := time.Now()
+	// all but the latest snapshot of the previous hour are pruned
+	current := now.Truncate(time.Hour).Add(-time.Hour)
+	snapshots := []string{
+		current.Add(3 * time.Minute).Format("2006-01-02_15-04"),
+		current.Add(2 * time.Minute).Format("2006-01-02_15-04"),
+		current.Add(time.Minute).Format("2006-01-02_15-04"),
+	}
+	setup := func(t *testing.T) (dir, planFile string) {
+		dir = t.TempDir()
+		for _, snapshot := range snapshots {
+			if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		planFile = filepath.Join(t.TempDir(), "plan.json")
+		run(t, "plan", "--out", planFile, "-v", "0", dir)
+		return dir, planFile
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(name)
+		return err == nil
+	}
+
+	t.Run("Apply", func(t *testing.T) {
+		dir, planFile := setup(t)
+		plan, err := retention.ReadPlanFile(planFile)
+		if err != nil || !reflect.DeepEqual(plan.Prune, snapshots[1:]) || plan.Location != dir {
+			t.Fatalf("unexpected plan %+v, %v", plan, err)
+		}
+		if !exists(filepath.Join(dir, snapshots[1])) {
+			t.Errorf("expected plan not to move anything")
+		}
+		if err := runErr(t, "apply", "-v", "0", planFile); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if exists(filepath.Join(dir, snapshots[1])) || exists(filepath.Join(dir, snapshots[2])) || !exists(filepath.Join(dir, snapshots[0])) {
+			t.Errorf("expected the plan to be executed")
+		}
+	})
+
+	t.Run("Drift", func(t *testing.T) {
+		dir, planFile := setup(t)
+		if err := os.Remove(filepath.Join(dir, snapshots[2])); err != nil {
+			t.Fatal(err)
+		}
+		err := runErr(t, "apply", "-v", "0", planFile)
+		if err == nil || !strings.Contains(err.Error(), snapshots[2]+" was planned to be pruned, but vanished") || !strings.Contains(err.Error(), "--force") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !exists(filepath.Join(dir, snapshots[1])) {
+			t.Errorf("expected nothing to be moved")
+		}
+
+		if err := runErr(t, "apply", "-v", "0", "--force", planFile); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if exists(filepath.Join(dir, snapshots[1])) {
+			t.Errorf("expected the plan to be forced")
+		}
+	})
+}
+
+func run(t *testing.T, args ...string) {
+	t.Helper()
+	if err := runErr(t, args...); err != nil {
+		t.Fatalf("%v: unexpected error: %v", args, err)
+	}
+}
+
+func runErr(t *testing.T, args ...string) error {
+	t.Helper()
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+	ctx, err := parser.Parse(args)
+	if err != nil {
+		t.Fatalf("%v: unexpected error: %v", args, err)
+	}
+	return ctx.Run(&cli)
+}
+
+func Test_pruneAbsoluteTrash(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+	newBackup := func(t *testing.T) string {
+		dir := t.TempDir()
+		for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15"} {
+			if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "2024-06-17_09-15", "file"), []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	t.Run("SameDevice", func(t *testing.T) {
+		dir := newBackup(t)
+		trash := filepath.Join(t.TempDir(), "trash")
+		if err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: trash}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(trash, "2024-06-17_09-15", "file")); err != nil {
+			t.Errorf("expected the snapshot in the absolute trash directory: %v", err)
+		}
+	})
+
+	t.Run("CrossDevice", func(t *testing.T) {
+		dir := newBackup(t)
+		other, err := os.MkdirTemp("/dev/shm", "prune_backups")
+		if err != nil {
+			t.Skipf("no second filesystem: %v", err)
+		}
+		t.Cleanup(func() { _ = os.RemoveAll(other) })
+		if cross, err := retention.CrossDevice(vfs.OS{}, dir, other); err != nil || !cross {
+			t.Skipf("%s is on the same filesystem as %s", other, dir)
+		}
+		trash := filepath.Join(other, "trash")
+
+		err = prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: trash})
+		if err == nil || !strings.Contains(err.Error(), "--copy-across-devices") {
+			t.Errorf("expected cross-device moves to be refused, got %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-15")); err != nil {
+			t.Errorf("expected nothing to be moved: %v", err)
+		}
+
+		if err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: trash, copyAcross: true}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if data, err := os.ReadFile(filepath.Join(trash, "2024-06-17_09-15", "file")); err != nil || string(data) != "content" {
+			t.Errorf("expected a copy in the trash, got %q, %v", data, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-15")); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected the original to be removed, got %v", err)
+		}
+	})
+}
+
+func Test_pruneCollision(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+	newBackup := func(t *testing.T) string {
+		dir := t.TempDir()
+		for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "to_delete/2024-06-17_09-15"} {
+			if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	pruneWith := func(t *testing.T, dir string, collision retention.Collision) string {
+		return captureOutput(func() {
+			if err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, collision: collision}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+	trash := func(dir, name string) string { return filepath.Join(dir, "to_delete", name) }
+
+	t.Run("Suffix", func(t *testing.T) {
+		dir := newBackup(t)
+		output := pruneWith(t, dir, retention.CollisionSuffix)
+		expectOutput(t, output, trash(dir, "2024-06-17_09-15")+" already exists, moving "+filepath.Join(dir, "2024-06-17_09-15")+" to "+trash(dir, "2024-06-17_09-15_2")+" instead.")
+		if _, err := os.Stat(trash(dir, "2024-06-17_09-15_2")); err != nil {
+			t.Errorf("expected the snapshot under a new name: %v", err)
+		}
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		dir := newBackup(t)
+		output := pruneWith(t, dir, retention.CollisionSkip)
+		expectOutput(t, output, "I left 1 directories in place as their names are already taken in "+filepath.Join(dir, "to_delete"))
+		if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-15")); err != nil {
+			t.Errorf("expected the snapshot to stay: %v", err)
+		}
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		dir := newBackup(t)
+		if err := os.WriteFile(filepath.Join(dir, "2024-06-17_09-15", "new"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		output := pruneWith(t, dir, retention.CollisionReplace)
+		expectOutput(t, output, "Replacing "+trash(dir, "2024-06-17_09-15")+" with "+filepath.Join(dir, "2024-06-17_09-15")+".")
+		if _, err := os.Stat(filepath.Join(trash(dir, "2024-06-17_09-15"), "new")); err != nil {
+			t.Errorf("expected the old copy to be replaced: %v", err)
+		}
+	})
+}
+
+func Test_pruneLocked(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("This test requires flock")
+	}
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+	unlock, err := retention.Lock(vfs.OS{}, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete"})
+	if err == nil || !strings.Contains(err.Error(), "is locked by another run of prune_backups: process "+strconv.Itoa(os.Getpid())) || !strings.Contains(err.Error(), "--wait") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if code := exitCode(err); code != ExitLocked {
+		t.Errorf("exitCode() = %d, want %d", code, ExitLocked)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-15")); err != nil {
+		t.Errorf("expected nothing to be moved: %v", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = unlock()
+	}()
+	output := captureOutput(func() {
+		if err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, wait: true}); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, ". Waiting for it to finish...")
+	expectOutput(t, output, "I moved 1 directories to ")
+}
+
+func Test_pruneIncomplete(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15/.complete", "2024-06-17_08-49/.complete", "2024-06-17_08-15/.complete"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, complete: retention.Completeness{Marker: ".complete"}})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, "Leaving 2024-06-17_09-49 alone as it is incomplete.")
+	expectOutput(t, output, "I moved 1 directories to ")
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_08-49"} {
+		if _, err := os.Stat(filepath.Join(dir, snapshot)); err != nil {
+			t.Errorf("expected %s to stay: %v", snapshot, err)
+		}
+	}
+
+	cli := CLI{}
+	parser := kong.Must(&cli,
+		kong.Name("prune_backups"),
+	)
+	ctx, err := parser.Parse([]string{"from", "--files", "--complete-marker", ".complete", dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ctx.Run(&cli); err == nil || err.Error() != "complete-marker flag cannot be combined with the files flag" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func Test_pruneImplausible(t *testing.T) {
+	if !stats.SupportedOS {
+		t.Skip("stats not supported on this OS")
+	}
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "2024-06-17_09-15", "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1, plausible: retention.Plausibility{MinFiles: 1}})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, "Flagging 2024-06-17_09-49 as implausible as it contains only 0 files.")
+	if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-15", "file")); err != nil {
+		t.Errorf("expected the last good snapshot to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "to_delete", "2024-06-17_09-49")); err != nil {
+		t.Errorf("expected the empty snapshot to be pruned: %v", err)
+	}
+}
+
+func Test_pruneSymlinkTarget(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_08-49"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("2024-06-17_09-15", filepath.Join(dir, "latest")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+
+	output := captureOutput(func() {
+		err := prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 1})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	expectOutput(t, output, "Protecting 2024-06-17_09-15 as it is the target of latest.")
+	if _, err := os.Stat(filepath.Join(dir, "latest", ".")); err != nil {
+		t.Errorf("expected latest to still point to a snapshot: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2024-06-17_08-49")); err != nil {
+		t.Errorf("expected the winner of the previous hour to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2024-06-17_09-49")); err != nil {
+		t.Errorf("expected the winner of the hour to be kept: %v", err)
+	}
+}
+
+func Test_pruneLogJSON(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+	if err := (LogFlags{Format: "json", Level: "debug"}).apply(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { logFlags = LogFlags{Format: "text"} })
+
+	var err error
+	output := captureOutput(func() {
+		err = prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", verbosity: 0})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := map[string]map[string]any{}
+	for line := range strings.Lines(output) {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON lines, got %q: %v", line, err)
+		}
+		events[record["event"].(string)] = record
+	}
+	move, ok := events["move"]
+	if !ok {
+		t.Fatalf("expected a move event as --log-level overrides the verbosity, got %q", output)
+	}
+	if move["dir"] != dir || move["src"] != filepath.Join(dir, "2024-06-17_09-15") || move["dst"] != filepath.Join(dir, "to_delete", "2024-06-17_09-15") {
+		t.Errorf("unexpected keys of the move event: %v", move)
+	}
+	if pruned := events["pruned"]; pruned == nil || pruned["count"] != float64(1) {
+		t.Errorf("unexpected pruned event: %v", pruned)
+	}
+}
+
+func TestLogFlags_InvalidLevel(t *testing.T) {
+	err := LogFlags{Format: "text", Level: "chatty"}.apply()
+	if err == nil || err.Error() != `invalid log level "chatty", expected debug, info, warn or error` {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func Test_exitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"other", errors.New("boom"), ExitFailure},
+		{"config", configErrorf("match flag requires the files flag"), ExitConfig},
+		{"unsupported", stats.ErrUnsupported, ExitConfig},
+		{"locked", fmt.Errorf("%w. Use --wait to wait for it to finish", &retention.LockedError{Path: "/backup"}), ExitLocked},
+		{"preflight", &retention.PreflightError{}, ExitSafety},
+		{"drift", &SafetyError{Err: errors.New("changed")}, ExitSafety},
+		{"unreadable", &ReadDirError{Dir: "/backup", Err: fs.ErrPermission}, ExitAccess},
+		{"trash", fmt.Errorf("%w\nI would have moved ...", &retention.TrashError{Path: "/backup/to_delete", Err: fs.ErrPermission}), ExitAccess},
+		{"partial", errors.Join(&retention.PartialError{Failed: 1, Total: 2, What: "entries could not be deleted"}, errors.New("index")), ExitPartial},
+		{"stale", &retention.StaleError{MaxAge: time.Hour}, ExitStale},
+		{"check", nagiosCritical, 2},
+		{"partial and stale", errors.Join(&retention.StaleError{MaxAge: time.Hour}, &retention.PartialError{Failed: 1, Total: 2}), ExitPartial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_pruneStale(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 12, 54, 21, 0, time.Local)
+
+	var err error
+	captureOutput(func() {
+		err = prune(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now, to: "to_delete", staleAfter: 2 * time.Hour})
+	})
+	var stale *retention.StaleError
+	if !errors.As(err, &stale) || stale.Newest != "2024-06-17_09-49" || exitCode(err) != ExitStale {
+		t.Errorf("expected the newest snapshot to be reported as stale, got %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(dir, "to_delete", "2024-06-17_09-15")); statErr != nil {
+		t.Errorf("expected pruning to happen anyway: %v", statErr)
+	}
+}
+
+func Test_checkSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_07-49", "2024-06-17_06-49", "2024-06-14_23-49", "to_delete"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 12, 54, 21, 0, time.Local)
+	thresholds := checkThresholds{warningAge: 2 * time.Hour, criticalAge: 26 * time.Hour, warningGaps: 1, tiers: []retention.Tier{retention.TierHourly, retention.TierDaily}}
+
+	result, err := checkSnapshots(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now}, thresholds.tiers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var gaps []string
+	for _, gap := range result.gaps {
+		gaps = append(gaps, gap.Prefix)
+	}
+	wantGaps := []string{"2024-06-17_08", "2024-06-17_05", "2024-06-17_04", "2024-06-17_03", "2024-06-17_02", "2024-06-17_01", "2024-06-17_00", "2024-06-16", "2024-06-15"}
+	if result.newest != "2024-06-17_09-49" || result.age != 3*time.Hour+5*time.Minute+21*time.Second || result.snapshots != 4 || !reflect.DeepEqual(gaps, wantGaps) {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	status := thresholds.status(result)
+	if status != nagiosWarning {
+		t.Errorf("status = %d, want WARNING", status)
+	}
+	output := result.format(status, thresholds)
+	expectOutput(t, output, "BACKUPS WARNING - the newest snapshot 2024-06-17_09-49 was made 3h5m21s ago, 9 gaps in the hourly and daily slots | age=11121s;7200;93600;0; gaps=9;0;;0; snapshots=4;;;0;\n")
+	expectOutput(t, output, "no daily snapshot in 2024-06-16\n")
+
+	thresholds.criticalAge = 3 * time.Hour
+	if status := thresholds.status(result); status != nagiosCritical {
+		t.Errorf("status = %d, want CRITICAL", status)
+	}
+	if status := thresholds.status(checkResult{}); status != nagiosCritical {
+		t.Errorf("status = %d, want CRITICAL without snapshots", status)
+	}
+	thresholds = checkThresholds{warningAge: 4 * time.Hour, tiers: []retention.Tier{retention.TierDaily}}
+	if status := thresholds.status(result); status != nagiosOK {
+		t.Errorf("status = %d, want OK with the gap check disabled", status)
+	}
+}
+
+func TestCheckCmd_Run(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, time.Now().Format("2006-01-02_15-04")), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cmd := CheckCmd{WarningAge: 2, CriticalAge: 26, GapTiers: []string{"hourly", "daily"}, Sidecar: []string{".sha256"}, Dir: dir}
+
+	var err error
+	output := captureOutput(func() {
+		err = cmd.Run(nil)
+	})
+	if err != nil || exitCode(err) != ExitOK {
+		t.Errorf("expected no error for OK, got %v", err)
+	}
+	expectOutput(t, output, "BACKUPS OK - ")
+
+	cmd.Dir = filepath.Join(dir, "missing")
+	output = captureOutput(func() {
+		err = cmd.Run(nil)
+	})
+	if !errors.Is(err, nagiosUnknown) || exitCode(err) != 3 {
+		t.Errorf("expected UNKNOWN, got %v", err)
+	}
+	expectOutput(t, output, "BACKUPS UNKNOWN - ")
+}
+
+func Test_reportCoverage(t *testing.T) {
+	dir := t.TempDir()
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_07-49", "2024-06-14_23-49", "2024-03-02_23-49", "to_delete"} {
+		if err := os.MkdirAll(filepath.Join(dir, snapshot), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.Local)
+
+	report, err := reportCoverage(pruneOptions{fsys: vfs.OS{}, dir: dir, now: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Filled != 4 || len(report.Slots) != len(retention.DefaultPolicy().Plan(now, nil).Filters) {
+		t.Errorf("expected 4 filled slots of the policy, got %d of %d", report.Filled, len(report.Slots))
+	}
+	output := report.text()
+	expectOutput(t, output, "Slots of "+dir+" at 2024-06-17 09:54:21: 4 of ")
+	expectOutput(t, output, " hourly   2024-06-17_09  2024-06-17_09-49\n hourly   2024-06-17_08  -\n")
+	expectOutput(t, output, " - no hourlies 2024-06-17_08\n - no hourlies 2024-06-17_00..2024-06-17_06\n")
+	expectOutput(t, output, " - no dailies 2024-06-15..2024-06-16\n")
+	expectOutput(t, output, " - no monthlies 2024-04..2024-05\n")
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectOutput(t, string(data), `{"tier":"daily","from":"2024-06-15","to":"2024-06-16","slots":2}`)
+	expectOutput(t, string(data), `{"prefix":"2024-06-14","tier":"daily","snapshot":"2024-06-14_23-49"}`)
+}
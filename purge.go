@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"prune_backups/retention"
+	"prune_backups/vfs"
+)
+
+type PurgeCmd struct {
+	After     int         `help:"REQUIRED. The quarantine period in days. Entries moved to the trash directory longer ago are deleted." required:"true"`
+	To        string      `help:"OPTIONAL. The name of the trash directory the pruned directories were moved to, relative to <dir>, or an absolute path." default:"to_delete" short:"t"`
+	Workers   int         `help:"OPTIONAL. The number of directories removed in parallel." default:"64"`
+	Wait      bool        `help:"OPTIONAL. If another run of prune_backups is working on <dir>, wait for it to finish instead of failing." default:"false" negatable:""`
+	Verbosity int         `help:"OPTIONAL. Set verbosity. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
+	S3        S3Options   `embed:"" prefix:"s3-" group:"S3"`
+	SFTP      SFTPOptions `embed:"" prefix:"sftp-" group:"SFTP"`
+	Dir       string      `arg:"" help:"REQUIRED. The directory that was pruned, or an s3://bucket/prefix or sftp://user@host/path location." required:"true"`
+}
+
+func (p *PurgeCmd) Run(cli *CLI) error {
+	if p.After < 0 {
+		return configErrorf("the quarantine period must not be negative")
+	}
+	fsys, dir, err := openLocation(p.Dir, p.S3, p.SFTP)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	unlock, err := lockDir(fsys, dir, p.Wait, p.Verbosity)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return purgeTrash(fsys, trashPath(dir, p.To), time.Now(), days(p.After), p.Workers, p.Verbosity)
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// purgeTrash deletes the entries of trash that were moved there more than
+// quarantine ago.
+func purgeTrash(fsys vfs.FS, trash string, now time.Time, quarantine time.Duration, workers, verbosity int) error {
+	logger := newLogger(verbosity).With("dir", trash)
+	result, err := retention.Purge(retention.PurgeOptions{
+		FS:      fsys,
+		Trash:   trash,
+		Before:  now.Add(-quarantine),
+		Now:     now,
+		Workers: workers,
+		Progress: func(m retention.Move) {
+			if m.Err != nil {
+				logger.Error("Error purging "+m.From+".", "event", "purge", "src", m.From, "err", m.Err)
+			} else {
+				logger.Debug("Purged "+m.From+".", "event", "purge", "src", m.From)
+			}
+		},
+	})
+	for _, name := range result.Recorded {
+		logger.Debug("Starting quarantine of "+name+" as it was not moved to the trash by prune_backups.", "event", "quarantine", "src", name)
+	}
+	logger.Info(fmt.Sprint("I purged ", result.Moved(), " entries from ", trash, ", ", len(result.Quarantined)+len(result.Recorded), " are still in quarantine"), "event", "purged", "count", result.Moved())
+	if removed := result.Removed(); verbosity > 0 && (removed.Files > 0 || removed.Dirs > 0) {
+		printRemoval(removed)
+	}
+	return err
+}
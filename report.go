@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"prune_backups/retention"
+	"prune_backups/sandbox"
+	"prune_backups/vfs"
+)
+
+type ReportCmd struct {
+	Format     string      `help:"OPTIONAL. The format of the report written to stdout: text or json." enum:"text,json" default:"text"`
+	Marker     string      `name:"complete-marker" help:"OPTIONAL. A file that a backup tool creates in a snapshot directory when it is complete, e.g. .complete. Snapshots without it fill no slot." group:"Completeness"`
+	Unmodified int         `name:"unmodified-for" help:"OPTIONAL. Snapshots modified within this number of minutes fill no slot. 0 disables the check." default:"0" group:"Completeness"`
+	Files      bool        `help:"OPTIONAL. Report on regular files like database dumps or archives (e.g. db-2024-06-17_09-49.sql.zst) instead of directories." default:"false" group:"Files"`
+	Match      string      `help:"OPTIONAL. Only consider files matching this glob pattern, e.g. '*.tar.gz'. Requires --files." group:"Files"`
+	Sidecar    []string    `help:"OPTIONAL. Extensions of sidecar files that are not snapshots themselves, see the from command." default:".sha256,.sig" group:"Files"`
+	Sandbox    bool        `help:"OPTIONAL. On Linux, restrict the process to reading <dir> with Landlock before reading anything." default:"true" negatable:""`
+	Verbosity  int         `help:"OPTIONAL. Set verbosity of the diagnostics written to stderr. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
+	S3         S3Options   `embed:"" prefix:"s3-" group:"S3"`
+	SFTP       SFTPOptions `embed:"" prefix:"sftp-" group:"SFTP"`
+	Dir        string      `arg:"" help:"REQUIRED. The directory of the snapshots, or an s3://bucket/prefix or sftp://user@host/path location." required:"true"`
+}
+
+// coverageReport lists the slots of the policy at Time and the gaps in them.
+type coverageReport struct {
+	Time     time.Time        `json:"time"`
+	Location string           `json:"location"`
+	Filled   int              `json:"filled"` // the number of filled slots
+	Slots    []retention.Slot `json:"slots"`  // newest first
+	Gaps     []retention.Gap  `json:"gaps"`   // newest first
+}
+
+func (r *ReportCmd) Run(cli *CLI) error {
+	if r.Match != "" && !r.Files {
+		return configErrorf("match flag requires the files flag")
+	}
+	complete, err := completeness(r.Marker, r.Unmodified, r.Files)
+	if err != nil {
+		return err
+	}
+	fsys, dir, err := openLocation(r.Dir, r.S3, r.SFTP)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	if _, local := fsys.(vfs.OS); local && r.Sandbox {
+		if err := restrict(r.Verbosity, sandbox.Path{Name: dir, Access: sandbox.ReadOnly}); err != nil {
+			return err
+		}
+	}
+
+	report, err := reportCoverage(pruneOptions{
+		fsys:      fsys,
+		dir:       dir,
+		now:       time.Now(),
+		verbosity: r.Verbosity,
+		files:     r.Files,
+		match:     r.Match,
+		sidecars:  r.Sidecar,
+		complete:  complete,
+	})
+	if err != nil {
+		return err
+	}
+	report.Location = r.Dir
+	if r.Format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	fmt.Print(report.text())
+	return nil
+}
+
+// reportCoverage lists the slots of the default policy at o.now and the
+// snapshots in o.dir that fill them.
+func reportCoverage(o pruneOptions) (coverageReport, error) {
+	report := coverageReport{Time: o.now, Location: o.dir}
+	names, err := listCandidates(o)
+	if err != nil {
+		return report, err
+	}
+	incomplete, err := findIncomplete(o, names)
+	if err != nil {
+		return report, err
+	}
+	policy := retention.DefaultPolicy()
+	if len(incomplete) > 0 {
+		policy.Incomplete = retention.IsListed(incomplete)
+	}
+	if o.files {
+		report.Slots = policy.CoverageFiles(o.now, names)
+	} else {
+		report.Slots = policy.Coverage(o.now, names)
+	}
+	for _, slot := range report.Slots {
+		if slot.Filled() {
+			report.Filled++
+		}
+	}
+	report.Gaps = retention.Gaps(report.Slots)
+	if report.Gaps == nil {
+		report.Gaps = []retention.Gap{} // make sure it's not null in JSON
+	}
+	return report, nil
+}
+
+// tierPlurals names the snapshots of each tier in the gaps of the text
+// report.
+var tierPlurals = map[retention.Tier]string{
+	retention.TierHourly:  "hourlies",
+	retention.TierDaily:   "dailies",
+	retention.TierMonthly: "monthlies",
+}
+
+// text returns the report as a table of the slots followed by the gaps,
+// e.g. "no dailies 2024-05-03..2024-05-07".
+func (r coverageReport) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Slots of %s at %s: %d of %d filled\n", r.Location, r.Time.Format(time.DateTime), r.Filled, len(r.Slots))
+	for _, slot := range r.Slots {
+		snapshot := slot.Snapshot
+		if !slot.Filled() {
+			snapshot = "-"
+		}
+		fmt.Fprintf(&b, " %-7s  %-13s  %s\n", slot.Tier, slot.Prefix, snapshot)
+	}
+	if len(r.Gaps) == 0 {
+		b.WriteString("No gaps.\n")
+		return b.String()
+	}
+	b.WriteString("Gaps:\n")
+	for _, gap := range r.Gaps {
+		if gap.Slots == 1 {
+			fmt.Fprintf(&b, " - no %s %s\n", tierPlurals[gap.Tier], gap.From)
+		} else {
+			fmt.Fprintf(&b, " - no %s %s..%s\n", tierPlurals[gap.Tier], gap.From, gap.To)
+		}
+	}
+	return b.String()
+}
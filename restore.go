@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"prune_backups/retention"
+	"prune_backups/vfs"
+)
+
+type RestoreCmd struct {
+	To         string      `help:"OPTIONAL. The name of the trash directory the pruned directories were moved to, relative to <dir>, or an absolute path." default:"to_delete" short:"t"`
+	CopyAcross bool        `name:"copy-across-devices" help:"OPTIONAL. If the trash directory is on another filesystem, copy the directories back, verify the copies and remove them from the trash." default:"false"`
+	Wait       bool        `help:"OPTIONAL. If another run of prune_backups is working on <dir>, wait for it to finish instead of failing." default:"false" negatable:""`
+	Verbosity  int         `help:"OPTIONAL. Set verbosity. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
+	S3         S3Options   `embed:"" prefix:"s3-" group:"S3"`
+	SFTP       SFTPOptions `embed:"" prefix:"sftp-" group:"SFTP"`
+	RunID      string      `arg:"" name:"run-id" help:"REQUIRED. The run to undo, i.e. the name of its subdirectory of the trash directory, e.g. 2024-06-17_09-54-21." required:"true"`
+	Dir        string      `arg:"" help:"REQUIRED. The directory that was pruned, or an s3://bucket/prefix or sftp://user@host/path location." required:"true"`
+}
+
+func (r *RestoreCmd) Run(cli *CLI) error {
+	fsys, dir, err := openLocation(r.Dir, r.S3, r.SFTP)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fsys.(io.Closer); ok {
+		defer func() {
+			_ = closer.Close()
+		}()
+	}
+	unlock, err := lockDir(fsys, dir, r.Wait, r.Verbosity)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return restoreRun(fsys, dir, trashPath(dir, r.To), r.RunID, r.CopyAcross, r.Verbosity)
+}
+
+// restoreRun moves the directories and files of a run back from trash to
+// dir, where they were pruned from.
+func restoreRun(fsys vfs.FS, dir, trash, run string, copyAcross bool, verbosity int) error {
+	logger := newLogger(verbosity).With("dir", trash, "run", run)
+	result, err := retention.Restore(retention.RestoreOptions{
+		FS:    fsys,
+		Dir:   dir,
+		Trash: trash,
+		Run:   run,
+
+		CopyAcrossDevices: copyAcross,
+		Progress: func(m retention.Move) {
+			if m.Err != nil {
+				logger.Error("Error restoring "+m.From+" to "+m.To+".", "event", "restore", "src", m.From, "dst", m.To, "err", m.Err)
+			} else {
+				logger.Debug("Restored "+m.From+" to "+m.To+".", "event", "restore", "src", m.From, "dst", m.To)
+			}
+		},
+	})
+	if len(result.Moves) > 0 {
+		logger.Info(fmt.Sprint("I restored ", result.Moved(), " of ", len(result.Moves), " entries of run ", run), "event", "restored", "count", result.Moved())
+		if failed := result.Failed(); failed > 0 {
+			logger.Warn(fmt.Sprint("The restore is partial, ", failed, " entries are still in ", filepath.Join(trash, run), ". Run restore again after freeing their names."), "event", "partial_restore", "count", failed)
+		}
+	}
+	return err
+}
@@ -0,0 +1,405 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+// ApplyOptions controls where Apply moves pruned snapshots.
+type ApplyOptions struct {
+	FS       vfs.FS     // the filesystem to work on; nil means the operating system's filesystem
+	Dir      string     // the directory containing the snapshots
+	Trash    string     // the directory the pruned snapshots are moved to; created if missing
+	Run      string     // optional, a subdirectory of Trash for this run, see NewRunName
+	Now      time.Time  // the time of the moves recorded in the trash index; time.Now() if zero
+	Delete   bool       // remove the pruned snapshots instead of moving them to Trash, see RemoveTree
+	Workers  int        // the number of directories removed concurrently if Delete is set; see RemoveTree
+	Progress func(Move) // optional, called after each attempted move
+
+	// OnCollision is the strategy for snapshots whose name is already taken
+	// in the trash; CollisionSuffix if empty. The decision is made for all
+	// snapshots before the first move and reported in Move.Collision.
+	OnCollision Collision
+
+	// CopyAcrossDevices allows moving the snapshots to a Trash on another
+	// device by copying and removing them, see vfs.Copier. Otherwise Apply
+	// refuses with a CrossDeviceError.
+	CopyAcrossDevices bool
+
+	crossDevice bool        // Trash is on another device than Dir
+	dirs        *openedDirs // Dir and the destination, if fsys can open directories
+
+	// Sidecars are extensions of files accompanying a snapshot, e.g.
+	// ".sha256". They are moved or removed together with the snapshot, see
+	// SidecarNames.
+	Sidecars []string
+}
+
+// Move describes a single attempted move of a snapshot into the trash. To is
+// empty if the snapshot was deleted instead.
+type Move struct {
+	Name      string
+	From      string
+	To        string
+	Sidecars  []string  // names of the sidecar files moved or removed along with the snapshot
+	Collision Collision // how a name collision in the trash was handled; empty if there was none
+	Removed   Removal   // what was removed, if the snapshot was deleted or replaced an old copy in the trash
+	Err       error     // nil if the move succeeded
+}
+
+// Result lists all moves attempted by Apply.
+type Result struct {
+	Moves []Move
+}
+
+// TrashError is returned by Apply if the trash directory cannot be created.
+// No snapshot has been moved in this case.
+type TrashError struct {
+	Path string
+	Err  error
+}
+
+func (e *TrashError) Error() string {
+	return fmt.Sprintf("Error creating directory \"%s\": %s", e.Path, e.Err)
+}
+
+func (e *TrashError) Unwrap() error {
+	return e.Err
+}
+
+// PartialError is returned if some of the snapshots or trash entries a run
+// worked on could not be handled while the others were.
+type PartialError struct {
+	Failed, Total int
+	What          string // what happened to the failed ones, e.g. "entries could not be deleted"
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d %s", e.Failed, e.Total, e.What)
+}
+
+// CrossDeviceError is returned by Apply if the trash directory is on another
+// device than the snapshots and copying across devices was not allowed. No
+// snapshot has been moved in this case.
+type CrossDeviceError struct {
+	Dir   string
+	Trash string
+}
+
+func (e *CrossDeviceError) Error() string {
+	return fmt.Sprintf("the trash directory %s is on another device than %s, so the snapshots can only be moved there by copying them", e.Trash, e.Dir)
+}
+
+// CrossDevice reports whether to, or its nearest existing parent directory,
+// is on another device than from. It reports false if fsys cannot tell, see
+// vfs.Devicer.
+func CrossDevice(fsys vfs.FS, from, to string) (bool, error) {
+	devicer, ok := fsys.(vfs.Devicer)
+	if !ok {
+		return false, nil
+	}
+	fromDevice, err := devicer.Device(from)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for {
+		toDevice, err := devicer.Device(to)
+		if err == nil {
+			return fromDevice != toDevice, nil
+		}
+		parent := filepath.Dir(to)
+		if !errors.Is(err, fs.ErrNotExist) || parent == to {
+			return false, err
+		}
+		to = parent
+	}
+}
+
+// moveTree renames from to to, or copies and removes it if it is known to
+// be moved across devices.
+func moveTree(fsys vfs.FS, from, to string, crossDevice bool, workers int) error {
+	if !crossDevice {
+		return fsys.Rename(from, to)
+	}
+	copier, ok := fsys.(vfs.Copier)
+	if !ok {
+		return fmt.Errorf("cannot move %s to %s: copying is not supported", from, to)
+	}
+	if err := copier.CopyTree(from, to); err != nil {
+		return err
+	}
+	if _, err := RemoveTree(fsys, from, workers); err != nil {
+		return fmt.Errorf("copied %s to %s, but could not remove it: %w", from, to, err)
+	}
+	return nil
+}
+
+// Moved returns the number of successful moves.
+func (r Result) Moved() int {
+	return len(r.Moves) - r.Failed() - r.Skipped()
+}
+
+// Skipped returns the number of snapshots left in place as their name was
+// taken in the trash, see CollisionSkip.
+func (r Result) Skipped() int {
+	var skipped int
+	for _, m := range r.Moves {
+		if m.Err == nil && m.Collision == CollisionSkip {
+			skipped++
+		}
+	}
+	return skipped
+}
+
+// Failed returns the number of failed moves.
+func (r Result) Failed() int {
+	var failed int
+	for _, m := range r.Moves {
+		if m.Err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Removed sums up what was removed by all deletions.
+func (r Result) Removed() Removal {
+	var total Removal
+	for _, m := range r.Moves {
+		total.add(m.Removed)
+	}
+	return total
+}
+
+// List returns the names of all subdirectories of dir, i.e. the candidates
+// for Policy.Plan.
+func List(dir string) ([]string, error) {
+	return ListFS(vfs.OS{}, dir)
+}
+
+// ListFS is like List but reads dir from fsys.
+func ListFS(fsys vfs.FS, dir string) ([]string, error) {
+	files, err := readDir(vfs.OrOS(fsys), dir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0)
+	for _, file := range files {
+		if file.IsDir() {
+			dirs = append(dirs, file.Name())
+		}
+	}
+	return dirs, nil
+}
+
+// ListFiles returns the names of all regular files in dir matching the glob
+// pattern (see path/filepath.Match; "" matches everything), i.e. the
+// candidates for Policy.PlanFiles. Files with one of the sidecar extensions
+// and the lock file are left out.
+func ListFiles(dir, pattern string, sidecars []string) ([]string, error) {
+	return ListFilesFS(vfs.OS{}, dir, pattern, sidecars)
+}
+
+// ListFilesFS is like ListFiles but reads dir from fsys.
+func ListFilesFS(fsys vfs.FS, dir, pattern string, sidecars []string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	entries, err := readDir(vfs.OrOS(fsys), dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || entry.Name() == LockName || hasSidecarExtension(entry.Name(), sidecars) {
+			continue
+		}
+		if matched, _ := filepath.Match(pattern, entry.Name()); pattern == "" || matched {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, nil
+}
+
+func hasSidecarExtension(name string, sidecars []string) bool {
+	for _, ext := range sidecars {
+		if strings.HasSuffix(name, ext) && name != ext {
+			return true
+		}
+	}
+	return false
+}
+
+// SidecarNames returns the possible names of the sidecar files of the
+// snapshot name: name itself with one of the extensions appended, and name
+// with its extensions stripped one by one, as long as a date remains, and one
+// of the extensions appended. For db-2024-06-17_09-49.sql.zst and ".sha256",
+// these are db-2024-06-17_09-49.sql.zst.sha256, db-2024-06-17_09-49.sql.sha256
+// and db-2024-06-17_09-49.sha256.
+func SidecarNames(name string, sidecars []string) []string {
+	var result []string
+	for stem := name; dateInName.MatchString(stem); {
+		for _, ext := range sidecars {
+			result = append(result, stem+ext)
+		}
+		ext := filepath.Ext(stem)
+		if ext == "" {
+			break
+		}
+		stem = strings.TrimSuffix(stem, ext)
+	}
+	return result
+}
+
+// moveSidecars moves or removes the sidecar files of m, passing the renames
+// to record.
+func moveSidecars(fsys vfs.FS, m *Move, sidecars []target, opts ApplyOptions, record func(Rename)) {
+	var errs []error
+	for _, t := range sidecars {
+		from := filepath.Join(opts.Dir, t.name)
+		var err error
+		if opts.Delete {
+			_, err = removeIn(fsys, opts.dirs.rootDir(), from, opts.Workers)
+		} else if t.collision == CollisionSkip {
+			continue
+		} else if _, err = moveTarget(fsys, t, opts); err == nil {
+			record(Rename{From: t.name, To: filepath.Base(t.to)})
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.Sidecars = append(m.Sidecars, t.name)
+	}
+	m.Err = errors.Join(errs...)
+}
+
+// destination returns the directory the snapshots are moved to.
+func (opts ApplyOptions) destination() string {
+	if opts.Run == "" {
+		return opts.Trash
+	}
+	return filepath.Join(opts.Trash, opts.Run)
+}
+
+// Apply moves all snapshots in d.Prune from opts.Dir to opts.Trash, or to
+// its subdirectory opts.Run if given, or removes them if opts.Delete is set,
+// together with their sidecar files. Names already taken in the trash are
+// handled according to opts.OnCollision. The time of the moves is recorded
+// in the trash index, see Purge. A Journal listing the planned renames is
+// written to the run directory, or to opts.Trash itself if opts.Run is
+// empty, before the first rename, and it is updated after each rename, see
+// Restore and Resume. If opts.Run is given, a Manifest is written too.
+// Nothing is changed if Preflight finds a problem. Otherwise all snapshots
+// are attempted, even if some of them fail; an error
+// summarizing the failures is returned in that case.
+func Apply(d Decisions, opts ApplyOptions) (Result, error) {
+	fsys := vfs.OrOS(opts.FS)
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var result Result
+	var w *journalWriter
+	if !opts.Delete {
+		// if the devices cannot be determined, renaming reports the problem
+		if cross, err := CrossDevice(fsys, opts.Dir, opts.Trash); err == nil && cross {
+			opts.crossDevice = true
+		}
+	}
+	plan := planMoves(fsys, d, opts)
+	if err := preflight(fsys, plan, opts); err != nil {
+		return result, err
+	}
+	if !opts.Delete {
+		if err := fsys.MkdirAll(opts.Trash, 0755); err != nil {
+			return result, &TrashError{Path: opts.Trash, Err: err}
+		}
+		if opts.Run != "" && len(d.Prune) > 0 {
+			if err := fsys.MkdirAll(opts.destination(), 0755); err != nil {
+				return result, &TrashError{Path: opts.destination(), Err: err}
+			}
+		}
+	}
+	dirs, err := openDirs(fsys, opts, len(d.Prune) > 0)
+	if err != nil {
+		return result, err
+	}
+	defer dirs.close()
+	opts.dirs = dirs
+	if !opts.Delete && len(d.Prune) > 0 {
+		if opts.Run != "" {
+			if err := WriteManifest(fsys, opts.destination(), NewManifest(opts.Run, now, opts.Dir, d, result)); err != nil {
+				return result, fmt.Errorf("could not write manifest: %w", err)
+			}
+		}
+		w = &journalWriter{fsys: fsys, runDir: opts.destination(), j: Journal{Run: opts.Run, InProgress: true, Planned: renames(plan)}}
+		if w.write(); w.err != nil {
+			return result, fmt.Errorf("could not write journal: %w", w.err)
+		}
+	}
+
+	var moved []Rename
+	record := func(r Rename) {
+		moved = append(moved, r)
+		w.record(r)
+	}
+	for _, pm := range plan {
+		move := Move{
+			Name:      pm.name,
+			From:      filepath.Join(opts.Dir, pm.name),
+			Collision: pm.collision,
+		}
+		if opts.Delete {
+			move.Removed, move.Err = removeIn(fsys, opts.dirs.rootDir(), move.From, opts.Workers)
+		} else {
+			move.To = pm.to
+			move.Removed, move.Err = moveTarget(fsys, pm.target, opts)
+			if move.Err == nil && pm.collision != CollisionSkip {
+				record(Rename{From: pm.name, To: filepath.Base(pm.to)})
+			}
+		}
+		if move.Err == nil && pm.collision != CollisionSkip && len(pm.sidecars) > 0 {
+			moveSidecars(fsys, &move, pm.sidecars, opts, record)
+		}
+		result.Moves = append(result.Moves, move)
+		if opts.Progress != nil {
+			opts.Progress(move)
+		}
+	}
+
+	var indexErr error
+	if !opts.Delete {
+		if err := recordMoves(fsys, opts.Trash, opts.Run, moved, now); err != nil {
+			indexErr = fmt.Errorf("could not update trash index: %w", err)
+		}
+		if w != nil {
+			if opts.Run != "" {
+				if err := WriteManifest(fsys, opts.destination(), NewManifest(opts.Run, now, opts.Dir, d, result)); err != nil {
+					indexErr = errors.Join(indexErr, fmt.Errorf("could not write manifest: %w", err))
+				}
+			}
+			w.j.InProgress = false
+			if w.write(); w.err != nil {
+				indexErr = errors.Join(indexErr, fmt.Errorf("could not write journal: %w", w.err))
+			}
+		}
+	}
+
+	if failed := result.Failed(); failed > 0 {
+		if opts.Delete {
+			return result, &PartialError{Failed: failed, Total: len(d.Prune), What: "entries could not be deleted"}
+		}
+		return result, errors.Join(&PartialError{Failed: failed, Total: len(d.Prune), What: "entries could not be moved to " + opts.destination()}, indexErr)
+	}
+	return result, indexErr
+}
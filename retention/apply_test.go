@@ -0,0 +1,416 @@
+package retention
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"prune_backups/vfs"
+)
+
+func makeDirs(t *testing.T, root string, names ...string) {
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(root, name), 0755); err != nil {
+			t.Fatalf("Failed to create directory %s: %v", name, err)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	dir := t.TempDir()
+	makeDirs(t, dir, "2024-06-17_09-49", "latest_dir")
+	if err := os.WriteFile(filepath.Join(dir, "2024-06-17_08-49"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := List(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(got)
+	want := []string{"2024-06-17_09-49", "latest_dir"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	_, err = List(filepath.Join(dir, "nonexisting"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	makeDirs(t, dir, "a", "b", "c")
+	trash := filepath.Join(dir, "to_delete")
+
+	var progress []string
+	result, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{
+		Dir:      dir,
+		Trash:    trash,
+		Progress: func(m Move) { progress = append(progress, m.Name) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Moved() != 2 || result.Failed() != 0 {
+		t.Errorf("expected 2 moved and 0 failed, got %d and %d", result.Moved(), result.Failed())
+	}
+	if !reflect.DeepEqual(progress, []string{"a", "b"}) {
+		t.Errorf("unexpected progress calls: %v", progress)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Stat(filepath.Join(trash, name)); err != nil {
+			t.Errorf("expected %s in trash: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c")); err != nil {
+		t.Errorf("expected c to remain: %v", err)
+	}
+}
+
+func TestApply_FailedMoves(t *testing.T) {
+	dir := t.TempDir()
+	makeDirs(t, dir, "a")
+
+	result, err := Apply(Decisions{Prune: []string{"a", "missing"}}, ApplyOptions{Dir: dir, Trash: filepath.Join(dir, "to_delete")})
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if err.Error() != "1 of 2 entries could not be moved to "+filepath.Join(dir, "to_delete") {
+		t.Errorf("unexpected error message: %q", err.Error())
+	}
+	var partial *PartialError
+	if !errors.As(err, &partial) || partial.Failed != 1 || partial.Total != 2 {
+		t.Errorf("expected a *PartialError, got %#v", err)
+	}
+	if result.Moved() != 1 || result.Failed() != 1 {
+		t.Errorf("expected 1 moved and 1 failed, got %d and %d", result.Moved(), result.Failed())
+	}
+}
+
+func TestApply_TrashError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("This test does not work on Windows")
+	}
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "to_delete")
+	if err := os.WriteFile(blocker, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{Dir: dir, Trash: blocker})
+	var trashErr *TrashError
+	if !errors.As(err, &trashErr) {
+		t.Fatalf("expected a TrashError, got %v", err)
+	}
+	if trashErr.Path != blocker {
+		t.Errorf("expected path %q, got %q", blocker, trashErr.Path)
+	}
+}
+
+func newMemBackup(t *testing.T, names ...string) *vfs.MemFS {
+	m := vfs.NewMemFS()
+	if err := m.MkdirAll("/backup", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if err := m.MkdirAll(filepath.Join("/backup", name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return m
+}
+
+func TestApply_InjectedFailures(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault func(op, name string) error
+		fail  []string
+	}{
+		{
+			name: "cross-device move",
+			fault: func(op, name string) error {
+				if op == "rename" && filepath.Base(name) == "b" {
+					return &os.LinkError{Op: "rename", Old: name, New: name, Err: syscall.EXDEV}
+				}
+				return nil
+			},
+			fail: []string{"b"},
+		},
+		{
+			name: "permission flip mid-run",
+			fault: func() func(op, name string) error {
+				renames := 0
+				return func(op, name string) error {
+					if op == "rename" {
+						renames++
+						if renames > 1 {
+							return &os.LinkError{Op: "rename", Old: name, New: name, Err: syscall.EACCES}
+						}
+					}
+					return nil
+				}
+			}(),
+			fail: []string{"b", "c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMemBackup(t, "a", "b", "c")
+			m.Fault = tt.fault
+
+			result, err := Apply(Decisions{Prune: []string{"a", "b", "c"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete"})
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			var failed []string
+			for _, move := range result.Moves {
+				if move.Err != nil {
+					failed = append(failed, move.Name)
+				}
+			}
+			if !reflect.DeepEqual(failed, tt.fail) {
+				t.Errorf("failed moves = %v, want %v", failed, tt.fail)
+			}
+			if _, err := m.Stat("/backup/to_delete/a"); err != nil {
+				t.Errorf("expected a to be moved: %v", err)
+			}
+		})
+	}
+}
+
+func TestApply_NoSpaceForTrash(t *testing.T) {
+	m := newMemBackup(t, "a")
+	m.Fault = func(op, name string) error {
+		if op == "mkdir" {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOSPC}
+		}
+		return nil
+	}
+
+	_, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete"})
+	var trashErr *TrashError
+	if !errors.As(err, &trashErr) || !errors.Is(err, syscall.ENOSPC) {
+		t.Fatalf("expected a TrashError wrapping ENOSPC, got %v", err)
+	}
+	if _, err := m.Stat("/backup/a"); err != nil {
+		t.Errorf("expected a to stay in place: %v", err)
+	}
+}
+
+func TestListFS(t *testing.T) {
+	m := newMemBackup(t, "2024-06-17_09-49", "2024-06-16_09-49")
+	if err := m.CreateFile("/backup/2024-06-15_09-49", 1, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ListFS(m, "/backup")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"2024-06-16_09-49", "2024-06-17_09-49"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListFS() = %v, want %v", got, want)
+	}
+}
+
+func TestApply_Delete(t *testing.T) {
+	m := newMemBackup(t, "a", "b/sub", "c")
+	m.Fault = func(op, name string) error {
+		if op == "remove" && filepath.Base(name) == "c" {
+			return &os.PathError{Op: "unlinkat", Path: name, Err: syscall.EACCES}
+		}
+		return nil
+	}
+
+	result, err := Apply(Decisions{Prune: []string{"a", "b", "c"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Delete: true})
+	if err == nil || err.Error() != "1 of 3 entries could not be deleted" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Moved() != 2 || result.Moves[0].To != "" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	got, _ := ListFS(m, "/backup")
+	if !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("remaining directories = %v, want [c]", got)
+	}
+}
+
+func TestListFilesFS(t *testing.T) {
+	m := newMemBackup(t, "2024-06-17_09-49", "to_delete")
+	for _, name := range []string{"db-2024-06-17_09-49.sql.zst", "db-2024-06-17_09-49.sql.zst.sha256", "db-2024-06-17_09-49.sig", "www-2024-06-17_09-49.tar.gz", "README"} {
+		if err := m.CreateFile(filepath.Join("/backup", name), 1, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sidecars := []string{".sha256", ".sig"}
+
+	got, err := ListFilesFS(m, "/backup", "", sidecars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"README", "db-2024-06-17_09-49.sql.zst", "www-2024-06-17_09-49.tar.gz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListFilesFS() = %v, want %v", got, want)
+	}
+
+	got, err = ListFilesFS(m, "/backup", "*.sql.zst", sidecars)
+	if err != nil || !reflect.DeepEqual(got, []string{"db-2024-06-17_09-49.sql.zst"}) {
+		t.Errorf("ListFilesFS() with pattern = %v, %v", got, err)
+	}
+
+	if _, err := ListFilesFS(m, "/backup", "[", sidecars); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
+
+func TestSidecarNames(t *testing.T) {
+	got := SidecarNames("db-2024-06-17_09-49.sql.zst", []string{".sha256", ".sig"})
+	want := []string{
+		"db-2024-06-17_09-49.sql.zst.sha256", "db-2024-06-17_09-49.sql.zst.sig",
+		"db-2024-06-17_09-49.sql.sha256", "db-2024-06-17_09-49.sql.sig",
+		"db-2024-06-17_09-49.sha256", "db-2024-06-17_09-49.sig",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SidecarNames() = %v, want %v", got, want)
+	}
+}
+
+func TestApply_Sidecars(t *testing.T) {
+	for _, del := range []bool{false, true} {
+		m := newMemBackup(t)
+		for _, name := range []string{"a-2024-06-17_09-15.tar.gz", "a-2024-06-17_09-15.tar.gz.sha256", "a-2024-06-17_09-15.sig", "a-2024-06-17_09-49.tar.gz.sha256"} {
+			if err := m.CreateFile(filepath.Join("/backup", name), 1, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result, err := Apply(Decisions{Prune: []string{"a-2024-06-17_09-15.tar.gz"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Delete: del, Sidecars: []string{".sha256", ".sig"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		wantSidecars := []string{"a-2024-06-17_09-15.tar.gz.sha256", "a-2024-06-17_09-15.sig"}
+		if !reflect.DeepEqual(result.Moves[0].Sidecars, wantSidecars) {
+			t.Errorf("Sidecars = %v, want %v", result.Moves[0].Sidecars, wantSidecars)
+		}
+		remaining, _ := ListFilesFS(m, "/backup", "", nil)
+		if !reflect.DeepEqual(remaining, []string{"a-2024-06-17_09-49.tar.gz.sha256"}) {
+			t.Errorf("delete=%v: remaining files = %v", del, remaining)
+		}
+		if !del {
+			trash, _ := ListFilesFS(m, "/backup/to_delete", "", nil)
+			want := []string{JournalName, TrashIndexName, "a-2024-06-17_09-15.sig", "a-2024-06-17_09-15.tar.gz", "a-2024-06-17_09-15.tar.gz.sha256"}
+			if !reflect.DeepEqual(trash, want) {
+				t.Errorf("trash = %v, want %v", trash, want)
+			}
+		}
+	}
+}
+
+func TestApply_SidecarFailure(t *testing.T) {
+	m := newMemBackup(t)
+	for _, name := range []string{"2024-06-17_09-15.tar.gz", "2024-06-17_09-15.tar.gz.sig"} {
+		if err := m.CreateFile(filepath.Join("/backup", name), 1, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Fault = func(op, name string) error {
+		if op == "rename" && filepath.Ext(name) == ".sig" {
+			return &os.LinkError{Op: "rename", Old: name, New: name, Err: syscall.EACCES}
+		}
+		return nil
+	}
+
+	result, err := Apply(Decisions{Prune: []string{"2024-06-17_09-15.tar.gz"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Sidecars: []string{".sig"}})
+	if err == nil || !errors.Is(result.Moves[0].Err, syscall.EACCES) {
+		t.Errorf("expected the failed sidecar move to be reported, got %v, %+v", err, result)
+	}
+}
+
+// twoDevices puts everything below /trash on another device than the rest.
+type twoDevices struct {
+	*vfs.MemFS
+}
+
+func (f twoDevices) Device(name string) (uint64, error) {
+	if _, err := f.Stat(name); err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(name, "/trash") {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+func (f twoDevices) CopyTree(src, dst string) error {
+	info, err := f.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		data, err := f.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		return f.WriteFile(dst, data, info.Mode().Perm())
+	}
+	if err := f.MkdirAll(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	entries, err := f.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := f.CopyTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestApply_CrossDevice(t *testing.T) {
+	m := newMemBackup(t, "a")
+	if err := m.WriteFile("/backup/a/file", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MkdirAll("/trash", 0755); err != nil {
+		t.Fatal(err)
+	}
+	fsys := twoDevices{m}
+
+	result, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: fsys, Dir: "/backup", Trash: "/trash/to_delete"})
+	var crossErr *CrossDeviceError
+	if !errors.As(err, &crossErr) || len(result.Moves) != 0 {
+		t.Fatalf("expected a CrossDeviceError before moving, got %+v, %v", result, err)
+	}
+	if _, err := m.Stat("/trash/to_delete"); err == nil {
+		t.Errorf("expected the trash directory not to be created")
+	}
+
+	result, err = Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: fsys, Dir: "/backup", Trash: "/trash/to_delete", CopyAcrossDevices: true})
+	if err != nil || result.Moved() != 1 {
+		t.Fatalf("Apply() = %+v, %v", result, err)
+	}
+	if data, err := m.ReadFile("/trash/to_delete/a/file"); err != nil || string(data) != "content" {
+		t.Errorf("expected a copy in the trash, got %q, %v", data, err)
+	}
+	if _, err := m.Stat("/backup/a"); err == nil {
+		t.Errorf("expected the original to be removed")
+	}
+}
+
+func TestCrossDevice_OS(t *testing.T) {
+	dir := t.TempDir()
+	cross, err := CrossDevice(vfs.OS{}, dir, filepath.Join(dir, "to_delete", "run"))
+	if err != nil || cross {
+		t.Errorf("CrossDevice() = %v, %v", cross, err)
+	}
+}
@@ -0,0 +1,119 @@
+package retention
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"prune_backups/vfs"
+)
+
+// Collision is a strategy for snapshots whose name is already taken in the
+// trash directory, e.g. by a snapshot of the same name pruned before.
+type Collision string
+
+const (
+	// CollisionSuffix moves the snapshot under its name with a counter
+	// appended, e.g. 2024-06-17_09-49_2. This is the default.
+	CollisionSuffix Collision = "suffix"
+	// CollisionSkip leaves the snapshot where it is.
+	CollisionSkip Collision = "skip"
+	// CollisionReplace removes the old copy in the trash before moving.
+	CollisionReplace Collision = "replace"
+)
+
+// target is where a snapshot or sidecar file is moved to.
+type target struct {
+	name      string    // the name in the directory of the snapshots
+	to        string    // the path in the trash directory
+	collision Collision // how a collision was resolved; empty if there was none
+}
+
+// plannedMove is a snapshot to prune together with its existing sidecar files.
+type plannedMove struct {
+	target
+	sidecars []target
+}
+
+// planMoves finds the existing sidecar files of the snapshots in d.Prune
+// and, unless they are deleted, resolves their destinations in the trash
+// according to opts.OnCollision.
+func planMoves(fsys vfs.FS, d Decisions, opts ApplyOptions) []plannedMove {
+	taken := make(map[string]bool)
+	resolve := func(name string) target {
+		if opts.Delete {
+			return target{name: name}
+		}
+		return opts.resolve(fsys, name, taken)
+	}
+	var plan []plannedMove
+	for _, name := range d.Prune {
+		pm := plannedMove{target: resolve(name)}
+		for _, sidecar := range SidecarNames(name, opts.Sidecars) {
+			if _, err := fsys.Stat(filepath.Join(opts.Dir, sidecar)); err == nil {
+				pm.sidecars = append(pm.sidecars, resolve(sidecar))
+			}
+		}
+		plan = append(plan, pm)
+	}
+	return plan
+}
+
+// resolve returns the destination of name in the trash. taken holds the
+// destinations already assigned in this run.
+func (opts ApplyOptions) resolve(fsys vfs.FS, name string, taken map[string]bool) target {
+	free := func(to string) bool {
+		// other errors than fs.ErrNotExist are reported when moving
+		_, err := fsys.Stat(to)
+		return err != nil && !taken[to]
+	}
+	t := target{name: name, to: filepath.Join(opts.destination(), name)}
+	if free(t.to) {
+		taken[t.to] = true
+		return t
+	}
+	switch opts.OnCollision {
+	case CollisionSkip, CollisionReplace:
+		t.collision = opts.OnCollision
+	default:
+		t.collision = CollisionSuffix
+		for i := 2; !free(t.to); i++ {
+			t.to = filepath.Join(opts.destination(), fmt.Sprintf("%s_%d", name, i))
+		}
+	}
+	taken[t.to] = true
+	return t
+}
+
+// renames lists the renames of the planned moves, leaving out the skipped
+// ones.
+func renames(plan []plannedMove) []Rename {
+	var result []Rename
+	for _, pm := range plan {
+		for _, t := range append([]target{pm.target}, pm.sidecars...) {
+			if t.collision != CollisionSkip {
+				result = append(result, Rename{From: t.name, To: filepath.Base(t.to)})
+			}
+		}
+	}
+	return result
+}
+
+// moveTarget moves the snapshot or sidecar file name to t, replacing an old
+// copy in the trash if t says so. Skipped targets are not moved.
+func moveTarget(fsys vfs.FS, t target, opts ApplyOptions) (Removal, error) {
+	from := filepath.Join(opts.Dir, t.name)
+	var replaced Removal
+	switch t.collision {
+	case CollisionSkip:
+		return replaced, nil
+	case CollisionReplace:
+		var err error
+		if replaced, err = removeIn(fsys, opts.dirs.destDir(), t.to, opts.Workers); err != nil {
+			return replaced, fmt.Errorf("could not replace %s: %w", t.to, err)
+		}
+	}
+	if opts.dirs != nil && !opts.crossDevice {
+		return replaced, opts.dirs.rename(t.name, filepath.Base(t.to))
+	}
+	return replaced, moveTree(fsys, from, t.to, opts.crossDevice, opts.Workers)
+}
@@ -0,0 +1,81 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApply_Collisions(t *testing.T) {
+	tests := []struct {
+		strategy  Collision
+		wantTo    string
+		wantMoved int
+		wantOld   string // what remains of the old copy in the trash
+	}{
+		{"", "/backup/to_delete/a_3", 1, "/backup/to_delete/a/old"},
+		{CollisionSuffix, "/backup/to_delete/a_3", 1, "/backup/to_delete/a/old"},
+		{CollisionSkip, "/backup/to_delete/a", 0, "/backup/to_delete/a/old"},
+		{CollisionReplace, "/backup/to_delete/a", 1, "/backup/to_delete/a/new"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.strategy), func(t *testing.T) {
+			m := newMemBackup(t, "a/new", "to_delete/a/old", "to_delete/a_2")
+			now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+
+			result, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Now: now, OnCollision: tt.strategy})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			move := result.Moves[0]
+			wantCollision := tt.strategy
+			if wantCollision == "" {
+				wantCollision = CollisionSuffix
+			}
+			if move.To != tt.wantTo || move.Collision != wantCollision || result.Moved() != tt.wantMoved || result.Skipped() != 1-tt.wantMoved {
+				t.Errorf("unexpected result %+v", result)
+			}
+			if _, err := m.Stat(tt.wantOld); err != nil {
+				t.Errorf("expected %s: %v", tt.wantOld, err)
+			}
+			if _, err := m.Stat("/backup/a"); (err == nil) != (tt.strategy == CollisionSkip) {
+				t.Errorf("unexpected state of the snapshot: %v", err)
+			}
+		})
+	}
+}
+
+func TestApply_CollisionJournal(t *testing.T) {
+	m := newMemBackup(t, "a-2024-06-17_09-15", "to_delete/"+testRun+"/a-2024-06-17_09-15")
+	if err := m.CreateFile("/backup/a-2024-06-17_09-15.sha256", 10, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Apply(Decisions{Prune: []string{"a-2024-06-17_09-15"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun, Sidecars: []string{".sha256"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	j, err := ReadJournal(m, "/backup/to_delete/"+testRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rename{
+		{From: "a-2024-06-17_09-15", To: "a-2024-06-17_09-15_2"},
+		{From: "a-2024-06-17_09-15.sha256", To: "a-2024-06-17_09-15.sha256"},
+	}
+	if !reflect.DeepEqual(j.Planned, want) || !reflect.DeepEqual(j.Renames, want) {
+		t.Errorf("journal = %+v, want %+v", j, want)
+	}
+}
+
+func TestApply_CollisionIndex(t *testing.T) {
+	m := newMemBackup(t, "a", "to_delete/a")
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	if _, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Now: now}); err != nil {
+		t.Fatal(err)
+	}
+	index, err := ReadTrashIndex(m, "/backup/to_delete")
+	if err != nil || !reflect.DeepEqual(index, TrashIndex{"a_2": now}) {
+		t.Errorf("index = %v, %v", index, err)
+	}
+}
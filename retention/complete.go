@@ -0,0 +1,120 @@
+package retention
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+// Completeness tells complete snapshots from those still being written, for
+// tools that write directly into a directory named YYYY-MM-DD_HH-mm instead
+// of renaming it when done.
+type Completeness struct {
+	// Marker is a file in a snapshot directory that is created when the
+	// snapshot is complete, e.g. ".complete". Not required if empty.
+	Marker string
+	// Unmodified is how long a snapshot must not have been modified to be
+	// complete. Not checked if 0.
+	Unmodified time.Duration
+}
+
+// walkWindow limits the snapshots whose trees are walked to check
+// Completeness.Unmodified to those named after a time at most this long
+// before the period, as older ones are not written anymore and walking them
+// would be expensive.
+const walkWindow = 24 * time.Hour
+
+// Enabled reports whether any criterion is set.
+func (c Completeness) Enabled() bool {
+	return c.Marker != "" || c.Unmodified > 0
+}
+
+// Incomplete returns the snapshots among names in dir that are incomplete at
+// now, i.e. lack the marker or were modified within the last c.Unmodified.
+// For directories, the latest modification of any file in the tree counts.
+// Names without a date are not checked.
+func (c Completeness) Incomplete(fsys vfs.FS, dir string, names []string, now time.Time) ([]string, error) {
+	fsys = vfs.OrOS(fsys)
+	var incomplete []string
+	if !c.Enabled() {
+		return incomplete, nil
+	}
+	settled := now.Add(-c.Unmodified)
+	walkAfter := settled.Add(-walkWindow).Format("2006-01-02_15-04")
+	for _, name := range names {
+		loc := dateInName.FindStringIndex(name)
+		if loc == nil {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		info, err := fsys.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if c.Marker != "" && info.IsDir() {
+			if _, err := fsys.Stat(filepath.Join(path, c.Marker)); errors.Is(err, fs.ErrNotExist) {
+				incomplete = append(incomplete, name)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if c.Unmodified <= 0 {
+			continue
+		}
+		modified := info.ModTime().After(settled)
+		if !modified && info.IsDir() && name[loc[0]:] >= walkAfter {
+			if modified, err = modifiedAfter(fsys, path, settled); err != nil {
+				return nil, err
+			}
+		}
+		if modified {
+			incomplete = append(incomplete, name)
+		}
+	}
+	return incomplete, nil
+}
+
+// modifiedAfter reports whether any entry in the tree dir was modified after
+// t. It stops at the first one found. Entries vanishing meanwhile, e.g.
+// temporary files, are ignored.
+func modifiedAfter(fsys vfs.FS, dir string, t time.Time) (bool, error) {
+	entries, err := fsys.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if info.ModTime().After(t) {
+			return true, nil
+		}
+		if entry.IsDir() {
+			if modified, err := modifiedAfter(fsys, filepath.Join(dir, entry.Name()), t); modified || err != nil {
+				return modified, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// IsListed returns a function for Policy.Incomplete reporting the names
+// listed in incomplete.
+func IsListed(incomplete []string) func(string) bool {
+	set := make(map[string]bool, len(incomplete))
+	for _, name := range incomplete {
+		set[name] = true
+	}
+	return func(name string) bool { return set[name] }
+}
@@ -0,0 +1,97 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicy_PlanIncomplete(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	names := []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_08-49", "2024-06-17_08-15", "latest"}
+	p := DefaultPolicy()
+	p.Incomplete = IsListed([]string{"2024-06-17_09-49", "2024-06-17_08-15", "latest"})
+
+	d := p.Plan(now, names)
+	// the half-written 09-49 does not displace 09-15, and 08-15 is not pruned although 08-49 is newer
+	if !reflect.DeepEqual(d.Keep, []string{"2024-06-17_09-15", "2024-06-17_08-49"}) || len(d.Prune) != 0 {
+		t.Errorf("unexpected decisions %+v", d)
+	}
+	if !reflect.DeepEqual(d.Incomplete, []string{"2024-06-17_09-49", "2024-06-17_08-15"}) || !reflect.DeepEqual(d.Skipped, []string{"latest"}) {
+		t.Errorf("unexpected incomplete or skipped snapshots %+v", d)
+	}
+
+	files := []string{"db-2024-06-17_09-49.sql", "db-2024-06-17_09-15.sql"}
+	p.Incomplete = IsListed(files[:1])
+	d = p.PlanFiles(now, files)
+	if !reflect.DeepEqual(d.Keep, files[1:]) || len(d.Prune) != 0 || !reflect.DeepEqual(d.Incomplete, files[:1]) {
+		t.Errorf("unexpected decisions for files %+v", d)
+	}
+}
+
+func TestCompleteness_Incomplete(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	m := newMemBackup(t,
+		"2024-06-17_09-49/data/deep", // written right now, deep down
+		"2024-06-17_09-15/data",      // complete and settled
+		"2024-06-17_08-49/data",      // settled, but lacks the marker
+		"2024-06-01_00-00/data",      // too old to be walked
+		"latest/data",                // not a snapshot
+	)
+	old := now.Add(-time.Hour)
+	for _, name := range []string{"", "/data", "/data/deep"} {
+		for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_08-49", "2024-06-01_00-00"} {
+			if err := m.Chtimes("/backup/"+snapshot+name, old); err != nil && name != "/data/deep" {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, name := range []string{"/backup/2024-06-17_09-49/data/deep/file", "/backup/2024-06-01_00-00/data/file"} {
+		if err := m.CreateFile(name, 10, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Chtimes(name, now.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, snapshot := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-01_00-00"} {
+		if err := m.CreateFile("/backup/"+snapshot+"/.complete", 0, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Chtimes("/backup/"+snapshot+"/.complete", old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_08-49", "2024-06-01_00-00", "latest"}
+
+	tests := []struct {
+		name string
+		c    Completeness
+		want []string
+	}{
+		{"Disabled", Completeness{}, nil},
+		{"Marker", Completeness{Marker: ".complete"}, []string{"2024-06-17_08-49"}},
+		{"Unmodified", Completeness{Unmodified: 10 * time.Minute}, []string{"2024-06-17_09-49"}},
+		{"Both", Completeness{Marker: ".complete", Unmodified: 10 * time.Minute}, []string{"2024-06-17_09-49", "2024-06-17_08-49"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.c.Incomplete(m, "/backup", names, now)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Incomplete() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanFile_DecideIncomplete(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	p := PlanFile{Time: now, Incomplete: []string{"2024-06-17_09-49"}}
+	candidates := []string{"2024-06-17_09-49", "2024-06-17_09-15"}
+	if d := p.Decide(candidates); len(d.Prune) != 0 || !reflect.DeepEqual(d.Incomplete, p.Incomplete) {
+		t.Errorf("unexpected decisions %+v", d)
+	}
+	if drift := p.Drift(candidates); !drift.Empty() {
+		t.Errorf("expected no drift, got %+v", drift)
+	}
+}
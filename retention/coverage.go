@@ -0,0 +1,121 @@
+package retention
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Tier is the granularity of a time slot.
+type Tier string
+
+const (
+	TierHourly  Tier = "hourly"  // slots like 2024-06-17_09
+	TierDaily   Tier = "daily"   // slots like 2024-06-17
+	TierMonthly Tier = "monthly" // slots like 2024-06
+)
+
+// Slot is a time slot of a policy and the snapshot that fills it.
+type Slot struct {
+	Prefix   string `json:"prefix"` // YYYY-MM-DD_HH, YYYY-MM-DD or YYYY-MM
+	Tier     Tier   `json:"tier"`
+	Snapshot string `json:"snapshot,omitempty"` // the snapshot kept in the slot; empty if there is none
+}
+
+// Filled reports whether a snapshot is kept in s.
+func (s Slot) Filled() bool {
+	return s.Snapshot != ""
+}
+
+// tierOf returns the tier of the slot prefix.
+func tierOf(prefix string) Tier {
+	switch len(prefix) {
+	case len("2006-01-02_15"):
+		return TierHourly
+	case len("2006-01-02"):
+		return TierDaily
+	default:
+		return TierMonthly
+	}
+}
+
+// Coverage lists the time slots p keeps a snapshot in at now, newest first,
+// with the snapshot among names that fills each of them, i.e. the latest
+// one in the slot. As Plan does, it uses the snapshots that are there to
+// choose between the hourly slots and a daily slot for yesterday, and
+// between daily slots and a monthly slot for the days before the last
+// month. Names not in date format and incomplete snapshots, see
+// Policy.Incomplete, fill no slot.
+func (p Policy) Coverage(now time.Time, names []string) []Slot {
+	return p.coverage(now, names, func(name string) (string, bool) {
+		return name, isDateFormat(name)
+	})
+}
+
+// CoverageFiles is like Coverage for names that contain a date but may
+// start with something else, see PlanFiles.
+func (p Policy) CoverageFiles(now time.Time, names []string) []Slot {
+	return p.coverage(now, names, func(name string) (string, bool) {
+		loc := dateInName.FindStringIndex(name)
+		if loc == nil {
+			return "", false
+		}
+		return name[loc[0]:], true
+	})
+}
+
+// coverage implements Coverage with the part of each name starting at its
+// date returned by dated.
+func (p Policy) coverage(now time.Time, names []string, dated func(string) (string, bool)) []Slot {
+	// as in PlanFiles, the key starts with the date and ends with the
+	// original name after a "/"
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		stamp, ok := dated(name)
+		if !ok || p.Incomplete != nil && p.Incomplete(name) {
+			continue
+		}
+		keys = append(keys, stamp+"/"+name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+
+	filters := getAllFilters(now, keys, p.Monthlies)
+	slots := make([]Slot, 0, len(filters))
+	for _, filter := range filters {
+		slot := Slot{Prefix: filter, Tier: tierOf(filter)}
+		for _, key := range keys {
+			if strings.HasPrefix(key, filter) {
+				slot.Snapshot = key[strings.LastIndex(key, "/")+1:]
+				break
+			}
+		}
+		slots = append(slots, slot)
+	}
+	return slots
+}
+
+// Gap is a range of consecutive empty slots of the same tier.
+type Gap struct {
+	Tier  Tier   `json:"tier"`
+	From  string `json:"from"` // the prefix of the oldest empty slot
+	To    string `json:"to"`   // the prefix of the newest empty slot
+	Slots int    `json:"slots"`
+}
+
+// Gaps groups the empty slots among slots, newest first as returned by
+// Coverage, into ranges, newest first.
+func Gaps(slots []Slot) []Gap {
+	var gaps []Gap
+	for i, slot := range slots {
+		if slot.Filled() {
+			continue
+		}
+		if last := len(gaps) - 1; i > 0 && !slots[i-1].Filled() && slots[i-1].Tier == slot.Tier {
+			gaps[last].From = slot.Prefix
+			gaps[last].Slots++
+			continue
+		}
+		gaps = append(gaps, Gap{Tier: slot.Tier, From: slot.Prefix, To: slot.Prefix, Slots: 1})
+	}
+	return gaps
+}
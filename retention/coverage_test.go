@@ -0,0 +1,100 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Coverage(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	names := []string{
+		"2024-06-17_09-49", "2024-06-17_06-49", "2024-06-17_00-49",
+		"2024-06-16_03-49", "2024-06-16_02-49",
+		"2024-06-15_23-49", "2024-06-15_13-49",
+		"2024-06-13_23-49",
+		"2024-03-02_23-49",
+		"latest", "to_delete",
+	}
+	policy := DefaultPolicy()
+
+	slots := policy.Coverage(now, names)
+	plan := policy.Plan(now, names)
+	if len(slots) != len(plan.Filters) {
+		t.Fatalf("expected a slot per filter, got %d slots for %d filters", len(slots), len(plan.Filters))
+	}
+	var filled []string
+	for i, slot := range slots {
+		if slot.Prefix != plan.Filters[i] {
+			t.Errorf("slot %d is %s, want %s", i, slot.Prefix, plan.Filters[i])
+		}
+		if slot.Filled() {
+			filled = append(filled, slot.Snapshot)
+		}
+	}
+	if !reflect.DeepEqual(filled, plan.Keep) {
+		t.Errorf("filled slots %v, want the kept snapshots %v", filled, plan.Keep)
+	}
+
+	want := map[string]Slot{
+		"2024-06-17_09": {Prefix: "2024-06-17_09", Tier: TierHourly, Snapshot: "2024-06-17_09-49"},
+		"2024-06-17_08": {Prefix: "2024-06-17_08", Tier: TierHourly},
+		"2024-06-16":    {Prefix: "2024-06-16", Tier: TierDaily, Snapshot: "2024-06-16_03-49"}, // no hourlies within the last 24 hours
+		"2024-06-14":    {Prefix: "2024-06-14", Tier: TierDaily},
+		"2024-05":       {Prefix: "2024-05", Tier: TierMonthly}, // no dailies in May
+		"2024-03":       {Prefix: "2024-03", Tier: TierMonthly, Snapshot: "2024-03-02_23-49"},
+	}
+	for _, slot := range slots {
+		if w, ok := want[slot.Prefix]; ok {
+			if slot != w {
+				t.Errorf("slot = %+v, want %+v", slot, w)
+			}
+			delete(want, slot.Prefix)
+		}
+	}
+	if len(want) > 0 {
+		t.Errorf("missing slots %v", want)
+	}
+
+	// an incomplete snapshot fills no slot
+	policy.Incomplete = IsListed([]string{"2024-06-17_09-49"})
+	if slot := policy.Coverage(now, names)[0]; slot.Filled() {
+		t.Errorf("expected the slot of the incomplete snapshot to be empty, got %+v", slot)
+	}
+}
+
+func TestPolicy_CoverageFiles(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	slots := DefaultPolicy().CoverageFiles(now, []string{"db-2024-06-17_09-15.sql.zst", "db-2024-06-17_09-49.sql.zst", "notes.txt"})
+	if slots[0] != (Slot{Prefix: "2024-06-17_09", Tier: TierHourly, Snapshot: "db-2024-06-17_09-49.sql.zst"}) {
+		t.Errorf("unexpected slot %+v", slots[0])
+	}
+	if slots := DefaultPolicy().Coverage(now, []string{"db-2024-06-17_09-49.sql.zst"}); slots[0].Filled() {
+		t.Errorf("expected Coverage to ignore names not starting with a date, got %+v", slots[0])
+	}
+}
+
+func TestGaps(t *testing.T) {
+	slots := []Slot{
+		{Prefix: "2024-06-17_09", Tier: TierHourly},
+		{Prefix: "2024-06-17_08", Tier: TierHourly, Snapshot: "2024-06-17_08-49"},
+		{Prefix: "2024-06-17_07", Tier: TierHourly},
+		{Prefix: "2024-06-17_06", Tier: TierHourly},
+		{Prefix: "2024-06-16", Tier: TierDaily},
+		{Prefix: "2024-06-15", Tier: TierDaily},
+		{Prefix: "2024-06-14", Tier: TierDaily, Snapshot: "2024-06-14_23-49"},
+		{Prefix: "2024-05", Tier: TierMonthly},
+	}
+	want := []Gap{
+		{Tier: TierHourly, From: "2024-06-17_09", To: "2024-06-17_09", Slots: 1},
+		{Tier: TierHourly, From: "2024-06-17_06", To: "2024-06-17_07", Slots: 2},
+		{Tier: TierDaily, From: "2024-06-15", To: "2024-06-16", Slots: 2},
+		{Tier: TierMonthly, From: "2024-05", To: "2024-05", Slots: 1},
+	}
+	if got := Gaps(slots); !reflect.DeepEqual(got, want) {
+		t.Errorf("Gaps() = %+v, want %+v", got, want)
+	}
+	if got := Gaps(nil); got != nil {
+		t.Errorf("Gaps(nil) = %+v", got)
+	}
+}
@@ -0,0 +1,204 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"prune_backups/vfs"
+)
+
+// WorldWritableError is returned by Apply if the directory of the snapshots
+// or the trash directory is writable by everyone, as anyone could then swap
+// in symlinks to redirect the moves. No snapshot has been moved in this case.
+type WorldWritableError struct {
+	Path string
+}
+
+func (e *WorldWritableError) Error() string {
+	return fmt.Sprintf("%s is writable by everyone, so other users could redirect the moves; remove their write permission, e.g. with chmod o-w", e.Path)
+}
+
+// openedDirs are the directory of the snapshots and the directory they are
+// moved to, opened once, so that the renames are done relative to them and
+// never follow symlinks, see vfs.Dir.
+type openedDirs struct {
+	root vfs.Dir
+	dest vfs.Dir // nil if the snapshots are deleted
+	all  []vfs.Dir
+}
+
+// openDirs opens and checks opts.Dir and, unless the snapshots are deleted,
+// opts.Trash and the run directory if dest is set. It returns nil if fsys
+// cannot open directories, see vfs.DirOpener.
+func openDirs(fsys vfs.FS, opts ApplyOptions, dest bool) (*openedDirs, error) {
+	opener, ok := fsys.(vfs.DirOpener)
+	if !ok {
+		return nil, nil
+	}
+	root, err := opener.OpenDir(opts.Dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &openedDirs{root: root, all: []vfs.Dir{root}}
+	if !opts.Delete {
+		// a trash inside the snapshot directory is opened relative to it
+		var trash vfs.Dir
+		if rel, inside := within(opts.Dir, opts.Trash); inside {
+			trash, err = root.OpenDir(rel)
+		} else {
+			trash, err = opener.OpenDir(opts.Trash)
+		}
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+		d.dest = trash
+		d.all = append(d.all, trash)
+		if dest && opts.Run != "" {
+			if d.dest, err = trash.OpenDir(opts.Run); err != nil {
+				d.close()
+				return nil, err
+			}
+			d.all = append(d.all, d.dest)
+		}
+	}
+	for _, dir := range d.all {
+		info, err := dir.Stat()
+		if err == nil && info.Mode().Perm()&0o002 != 0 {
+			err = &WorldWritableError{Path: dir.Name()}
+		}
+		if err != nil {
+			d.close()
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// rename moves the entry name of the snapshot directory to newName in the
+// destination.
+func (d *openedDirs) rename(name, newName string) error {
+	return d.root.Rename(name, d.dest, newName)
+}
+
+// rootDir returns the opened directory of the snapshots, or nil if there
+// is none.
+func (d *openedDirs) rootDir() vfs.Dir {
+	if d == nil {
+		return nil
+	}
+	return d.root
+}
+
+// destDir returns the opened destination, or nil if there is none.
+func (d *openedDirs) destDir() vfs.Dir {
+	if d == nil {
+		return nil
+	}
+	return d.dest
+}
+
+func (d *openedDirs) close() {
+	if d == nil {
+		return
+	}
+	for _, dir := range d.all {
+		_ = dir.Close()
+	}
+}
+
+// within returns path relative to dir if it is inside of it.
+func within(dir, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// readDir reads dir through a file descriptor if fsys supports it, see
+// vfs.DirOpener, and through fsys.ReadDir otherwise.
+func readDir(fsys vfs.FS, dir string) ([]fs.DirEntry, error) {
+	opener, ok := fsys.(vfs.DirOpener)
+	if !ok {
+		return fsys.ReadDir(dir)
+	}
+	d, err := opener.OpenDir(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return fsys.ReadDir(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+	return d.ReadDir()
+}
+
+// openPathDir opens dir through fsys if it supports it, see vfs.DirOpener,
+// and returns a pathDir otherwise.
+func openPathDir(fsys vfs.FS, remover vfs.Remover, dir string) (vfs.Dir, error) {
+	if opener, ok := fsys.(vfs.DirOpener); ok {
+		d, err := opener.OpenDir(dir)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return d, err
+		}
+	}
+	return &pathDir{fsys: fsys, remover: remover, name: dir}, nil
+}
+
+// pathDir is a vfs.Dir for filesystems that cannot open directories. It
+// resolves names by their path on every call, so unlike vfs.Dir it follows
+// a symlink swapped in for a directory after it was opened. Lstat only
+// tells symlinks apart if fsys implements vfs.Lstater. It cannot rename.
+type pathDir struct {
+	fsys    vfs.FS
+	remover vfs.Remover
+	name    string
+}
+
+func (d *pathDir) Name() string {
+	return d.name
+}
+
+func (d *pathDir) Stat() (fs.FileInfo, error) {
+	return d.fsys.Stat(d.name)
+}
+
+func (d *pathDir) ReadDir() ([]fs.DirEntry, error) {
+	return d.fsys.ReadDir(d.name)
+}
+
+func (d *pathDir) OpenDir(name string) (vfs.Dir, error) {
+	return &pathDir{fsys: d.fsys, remover: d.remover, name: filepath.Join(d.name, name)}, nil
+}
+
+func (d *pathDir) Lstat(name string) (fs.FileInfo, error) {
+	if lstater, ok := d.fsys.(vfs.Lstater); ok {
+		return lstater.Lstat(filepath.Join(d.name, name))
+	}
+	return d.fsys.Stat(filepath.Join(d.name, name))
+}
+
+func (d *pathDir) SizeAndLinkCount(name string) (size, linkCount uint64, err error) {
+	return d.fsys.SizeAndLinkCount(filepath.Join(d.name, name))
+}
+
+func (d *pathDir) Remove(name string) error {
+	return d.remover.Remove(filepath.Join(d.name, name))
+}
+
+func (d *pathDir) Rename(name string, newDir vfs.Dir, newName string) error {
+	return fmt.Errorf("cannot rename %s: %w", filepath.Join(d.name, name), errors.ErrUnsupported)
+}
+
+func (d *pathDir) Close() error {
+	return nil
+}
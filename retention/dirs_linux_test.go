@@ -0,0 +1,208 @@
+package retention
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+func newOSBackup(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestApply_WorldWritable(t *testing.T) {
+	for _, name := range []string{"", "to_delete"} {
+		dir := newOSBackup(t, "a", "to_delete")
+		if err := os.Chmod(filepath.Join(dir, name), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: vfs.OS{}, Dir: dir, Trash: filepath.Join(dir, "to_delete")})
+		var wwErr *WorldWritableError
+		if !errors.As(err, &wwErr) || wwErr.Path != filepath.Join(dir, name) {
+			t.Errorf("expected %s to be refused as world-writable, got %v", filepath.Join(dir, name), err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "a")); err != nil {
+			t.Errorf("expected a to stay: %v", err)
+		}
+	}
+}
+
+func TestApply_SymlinkedTrash(t *testing.T) {
+	dir := newOSBackup(t, "a")
+	elsewhere := t.TempDir()
+	if err := os.Symlink(elsewhere, filepath.Join(dir, "to_delete")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: vfs.OS{}, Dir: dir, Trash: filepath.Join(dir, "to_delete"), Run: testRun})
+	if !errors.Is(err, vfs.ErrSymlink) {
+		t.Errorf("expected the symlinked trash to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a")); err != nil {
+		t.Errorf("expected a to stay: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, testRun, "a")); err == nil {
+		t.Errorf("expected a not to be moved through the symlink")
+	}
+}
+
+func TestPurge_SymlinkedTrash(t *testing.T) {
+	dir := newOSBackup(t)
+	elsewhere := newOSBackup(t, "a")
+	if err := WriteTrashIndex(vfs.OS{}, elsewhere, TrashIndex{"a": time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(elsewhere, filepath.Join(dir, "to_delete")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Purge(PurgeOptions{FS: vfs.OS{}, Trash: filepath.Join(dir, "to_delete"), Before: time.Now()}); !errors.Is(err, vfs.ErrSymlink) {
+		t.Errorf("expected the symlinked trash to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, "a")); err != nil {
+		t.Errorf("expected a to stay where the symlink points: %v", err)
+	}
+}
+
+func TestApply_SwappedSnapshot(t *testing.T) {
+	dir := newOSBackup(t, "b")
+	elsewhere := t.TempDir()
+	// a snapshot replaced by a symlink after planning is moved as a symlink
+	if err := os.Symlink(elsewhere, filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: vfs.OS{}, Dir: dir, Trash: filepath.Join(dir, "to_delete")}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info, err := os.Lstat(filepath.Join(dir, "to_delete", "a")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected the symlink to be moved, got %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "to_delete", "b")); err != nil {
+		t.Errorf("expected b to be moved: %v", err)
+	}
+}
+
+// newVictim returns a directory outside of the backup with a file in it,
+// which must survive removing symlinks to it.
+func newVictim(t *testing.T) string {
+	t.Helper()
+	victim := t.TempDir()
+	if err := os.WriteFile(filepath.Join(victim, "keep"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return victim
+}
+
+func TestApply_DeleteSwappedSnapshot(t *testing.T) {
+	dir := newOSBackup(t, "b/sub")
+	victim := newVictim(t)
+	// a snapshot replaced by a symlink after planning is deleted as a symlink
+	if err := os.Symlink(victim, filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(dir, "b", "sub", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: vfs.OS{}, Dir: dir, Delete: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(victim, "keep")); err != nil {
+		t.Errorf("expected the target of the symlinks to stay: %v", err)
+	}
+	for _, name := range []string{"a", "b"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be deleted, got %v", name, err)
+		}
+	}
+	if r := result.Moves[0].Removed; r.Files != 1 || r.Dirs != 0 {
+		t.Errorf("expected only the symlink a to be removed, got %+v", r)
+	}
+}
+
+func TestApply_ReplaceSwappedCopy(t *testing.T) {
+	dir := newOSBackup(t, "a/new", "to_delete")
+	victim := newVictim(t)
+	// the old copy in the trash replaced by a symlink is removed as a symlink
+	if err := os.Symlink(victim, filepath.Join(dir, "to_delete", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: vfs.OS{}, Dir: dir, Trash: filepath.Join(dir, "to_delete"), OnCollision: CollisionReplace}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(victim, "keep")); err != nil {
+		t.Errorf("expected the target of the symlink to stay: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "to_delete", "a", "new")); err != nil {
+		t.Errorf("expected a to replace the symlink: %v", err)
+	}
+}
+
+func TestRemoveTree_Symlink(t *testing.T) {
+	dir := newOSBackup(t)
+	victim := newVictim(t)
+	if err := os.Symlink(victim, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	removal, err := RemoveTree(vfs.OS{}, filepath.Join(dir, "link"), 0)
+	if err != nil || removal.Files != 1 || removal.Dirs != 0 {
+		t.Errorf("RemoveTree() = %+v, %v", removal, err)
+	}
+	if _, err := os.Stat(filepath.Join(victim, "keep")); err != nil {
+		t.Errorf("expected the target of the symlink to stay: %v", err)
+	}
+}
+
+func TestRestore_OS(t *testing.T) {
+	dir := newOSBackup(t, "a", "b")
+	trash := filepath.Join(dir, "to_delete")
+	if _, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: vfs.OS{}, Dir: dir, Trash: trash, Run: testRun}); err != nil {
+		t.Fatal(err)
+	}
+	// rename(2) would replace an empty directory
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Restore(RestoreOptions{FS: vfs.OS{}, Dir: dir, Trash: trash, Run: testRun})
+	if err == nil || result.Moved() != 1 || !errors.Is(result.Moves[1].Err, os.ErrExist) {
+		t.Errorf("expected only b to be restored, got %+v, %v", result, err)
+	}
+	if _, err := os.Stat(filepath.Join(trash, testRun, "a")); err != nil {
+		t.Errorf("expected a to stay in the trash: %v", err)
+	}
+}
+
+func TestRestore_SymlinkedRun(t *testing.T) {
+	dir := newOSBackup(t, "to_delete")
+	elsewhere := newOSBackup(t, "a")
+	if err := WriteJournal(vfs.OS{}, elsewhere, Journal{Run: testRun, Renames: []Rename{{From: "a", To: "a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(elsewhere, filepath.Join(dir, "to_delete", testRun)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Restore(RestoreOptions{FS: vfs.OS{}, Dir: dir, Trash: filepath.Join(dir, "to_delete"), Run: testRun}); !errors.Is(err, vfs.ErrSymlink) {
+		t.Errorf("expected the symlinked run directory to be refused, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, "a")); err != nil {
+		t.Errorf("expected a to stay where the symlink points: %v", err)
+	}
+}
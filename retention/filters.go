@@ -0,0 +1,230 @@
+package retention
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+func getAllFilters(startTime time.Time, existingDirs []string, monthlies int) []string {
+	var result = []string{}
+
+	// append hourly filters
+
+	result = append(result, getFiltersForHourlies(startTime, existingDirs)...)
+
+	// append daily filters
+
+	filtersForDailies, firstMonthForMonthlies := getFiltersForDailies(startTime.AddDate(0, 0, -2), existingDirs)
+	result = append(result, filtersForDailies...)
+
+	// append monthly filters
+
+	result = append(result, getFiltersForMonthlies(firstMonthForMonthlies, monthlies)...)
+
+	return result
+}
+
+func getFiltersForHourlies(startTime time.Time, existingDirs []string) []string {
+	var result = []string{}
+	filtersToday := getFiltersForToday(startTime)
+	result = append(result, filtersToday...)
+	remainingHourlies := 24 - len(filtersToday)
+	filtersYesterday := getFiltersForYesterday(startTime, remainingHourlies, existingDirs)
+	result = append(result, filtersYesterday...)
+	return result
+}
+
+func getFiltersForToday(currentTime time.Time) []string {
+	var result = []string{}
+
+	day := currentTime.Day()
+
+	for currentTime.Day() == day {
+		// Format the time in the format YYYY-MM-DD_hh
+		prefix := currentTime.Format("2006-01-02_15") // caution, this is a magic number in go!
+		result = append(result, prefix)
+
+		// Subtract one hour from the current timestamp
+		currentTime = currentTime.Add(-1 * time.Hour)
+	}
+	return result
+}
+
+func getFiltersForYesterday(currentTime time.Time, remainingHourlyBackups int, allDirs []string) []string {
+	var hourlyFilters = []string{}
+
+	yesterday := currentTime.Add(-24 * time.Hour)
+
+	var year = yesterday.Year()
+	var month = (int)(yesterday.Month())
+	var day = yesterday.Day()
+	yesterDateStr := toDateStr3(year, month, day)
+
+	for i := range remainingHourlyBackups {
+		prefix := yesterDateStr + "_" + twoDigit(23-i)
+		hourlyFilters = append(hourlyFilters, prefix)
+	}
+
+	anyMatches := getAnyMatchingAnyPrefixes(allDirs, hourlyFilters) // check what is actually there - the filter for yesterday will depend on it
+
+	if anyMatches {
+		// we found some hourly backup folders for yesterday, so return the filter for the hourly backups for yesterday, i.e. some YYYY-MM-DD_HH filters
+		return hourlyFilters
+	} else {
+		// we found no hourly backup folders for yesterday, so return the filter for the latest backup for yesterday, i.e. one YYYY-MM-DD filter
+		return []string{yesterDateStr}
+	}
+}
+
+func getFiltersForDailies(startDate time.Time, existingDirs []string) ([]string, time.Time) {
+	var result = []string{}
+	var firstMonthForMonthlies time.Time
+	M1 := get15thOfMonthBefore(startDate)
+	M2 := get15thOfMonthBefore(M1)
+	M3 := get15thOfMonthBefore(M2)
+	daysM0 := daysInMonth(startDate.Year(), startDate.Month())
+	daysM1 := daysInMonth(M1.Year(), M1.Month())
+
+	switch startDate.Day() {
+	case 1:
+		{
+			switch daysM1 {
+			case 28:
+				// The 30 days affect THREE months M0, M1, and M2 and M2 is NOT completely covered with daily backups.
+				// pin 29 normal dailies and test 1 daily in M2. continue with M3
+				result = append(result, getFiltersForDailiesSimple(startDate, 29)...)
+				result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M2.Year(), M2.Month()), 1, existingDirs)...)
+				firstMonthForMonthlies = M3
+			case 29:
+				// The 30 days affect TWO months M0 and M1 and M1 is completely covered with daily backups.
+				// pin 30 normal dailies and test nothing. continue with M2
+				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
+				firstMonthForMonthlies = M2
+			case 30, 31:
+				// The 30 days affect TWO months M0 and M1 and M1 is NOT completely covered with daily backups.
+				// pin 1 normal daily and test 29 dailies in M1. continue with M2
+				result = append(result, getFiltersForDailiesSimple(startDate, 1)...)
+				result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M1.Year(), M1.Month()), 29, existingDirs)...)
+				firstMonthForMonthlies = M2
+			}
+		}
+	case 2:
+		{
+			switch daysM1 {
+			case 28:
+				// The 30 days affect TWO months M0 and M1 and M1 is completely covered with daily backups.
+				// pin 30 normal dailies and test nothing. continue with M2
+				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
+				firstMonthForMonthlies = M2
+			case 29, 30, 31:
+				// The 30 days affect TWO months M0 and M1 and M1 is NOT completely covered with daily backups.
+				// pin 2 normal dailies and test 28 dailies in M1. continue with M2
+				result = append(result, getFiltersForDailiesSimple(startDate, 2)...)
+				result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M1.Year(), M1.Month()), 28, existingDirs)...)
+				firstMonthForMonthlies = M2
+			}
+		}
+	case 30:
+		{
+			switch daysM0 {
+			// case 28, 29:
+			// impossible in a month with a 30st day if daysInMonth() works correctly
+			case 30:
+				// The 30 days affect ONE month M0 and M0 is completely covered with daily backups.
+				// pin 30 normal dailies and test nothing. continue with M1
+				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
+				firstMonthForMonthlies = M1
+			case 31:
+				// The 30 days affect ONE month M0 and (the rest of) M0 is completely covered with daily backups.
+				// Please note: the 31. will already be covered by the hourly backup filter logic, so the 30 daily filters will indeed cover the rest of the month
+				// pin 30 normal dailies and test nothing. continue with M1
+				result = append(result, getFiltersForDailiesSimple(startDate, 30)...)
+				firstMonthForMonthlies = M1
+			}
+		}
+	case 31:
+		{
+			switch daysM0 {
+			// case 28, 29, 30:
+			// impossible in a month with a 31st day if daysInMonth() works correctly
+			case 31:
+				// The 30 days affect ONE month M0 and M0 is NOT completely covered with daily backups.
+				// pin 0 normal dailies and test 30 dailies in M0. continue with M1
+				result = append(result, getFiltersForDailiesOrForMonth(startDate, 30, existingDirs)...)
+				firstMonthForMonthlies = M1
+			}
+		}
+	default:
+		// The 30 days affect TWO months M0 and M1 and M1 is NOT completely covered with daily backups.
+		// pin daysM0 normal dailies and test 30-daysM0 dailies in M1. continue with M2
+		result = append(result, getFiltersForDailiesSimple(startDate, startDate.Day())...)
+		result = append(result, getFiltersForDailiesOrForMonth(getUltimo(M1.Year(), M1.Month()), 30-startDate.Day(), existingDirs)...)
+		firstMonthForMonthlies = M2
+	}
+	return result, firstMonthForMonthlies
+}
+
+func getFiltersForDailiesSimple(startDate time.Time, count int) []string {
+	var result = []string{}
+	for range count {
+		// Format the time in the format YYYY-MM-DD
+		prefix := startDate.Format("2006-01-02")
+		result = append(result, prefix)
+		startDate = startDate.AddDate(0, 0, -1)
+	}
+	return result
+}
+
+func getFiltersForDailiesOrForMonth(startDate time.Time, remaining int, existingDirs []string) []string {
+	filtersForDailies := getFiltersForDailiesSimple(startDate, remaining)
+	anyMatches := getAnyMatchingAnyPrefixes(existingDirs, filtersForDailies) // check what is actually there
+	if anyMatches {
+		// we found some daily backup folders, so return the filter for the daily backups, i.e. some YYYY-MM-DD filters
+		return filtersForDailies
+	} else {
+		// we found no daily backup folders within the specified range, so return a filter for month, i.e. one YYYY-MM filter
+		filter := toDateStr(startDate.Year(), int(startDate.Month()))
+		return []string{filter}
+	}
+}
+
+func getFiltersForMonthlies(current time.Time, count int) []string {
+	var result = []string{}
+	// don't use AddDate(0, -1, 0) as this function does not work as expected when we're on a March, 29th in a non-leap-year, e.g.
+	// use simpler and more robust approach, as from now on we don't need (leap-) days arithmetics anyhow
+	var year = current.Year()
+	var month = (int)(current.Month())
+
+	for range count {
+		// Format the time in the format YYYY-MM
+		result = append(result, toDateStr(year, month))
+		prevMonth(&year, &month)
+	}
+	return result
+}
+
+var dateFormat = regexp.MustCompile(`^[\d]{4}\-[\d]{2}\-[\d]{2}.*`)
+
+func isDateFormat(name string) bool {
+	return dateFormat.MatchString(name)
+}
+
+func getDateDirectoriesNotMatchingAnyPrefix(allDirs []string, prefixes []string) []string {
+	var result = []string{}
+	for _, dir := range allDirs {
+		if isDateFormat(dir) {
+			foundMatch := false
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(dir, prefix) {
+					foundMatch = true
+					break
+				}
+			}
+			if !foundMatch {
+				result = append(result, dir)
+			}
+		}
+	}
+	return result
+}
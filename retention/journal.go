@@ -0,0 +1,426 @@
+package retention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+// JournalName is the name of the file in a run directory listing the planned
+// and completed renames of the run, see Restore and Resume. Runs without a
+// run directory keep it in the trash directory itself.
+const JournalName = ".prune_backups_journal.json"
+
+// Rename is a single rename of a snapshot or sidecar file. Both are single
+// names, so that a journal edited by someone who can write to the trash
+// cannot make Restore, Resume or Rollback move anything else.
+type Rename struct {
+	From string `json:"from"` // the name in the directory of the snapshots
+	To   string `json:"to"`   // the name in the run directory, or in the trash directory without one
+}
+
+// Journal lists the renames planned for a run and the ones completed, in the
+// order they were made. It is written before the first rename and after each
+// further one, so that a run interrupted by a crash can be resumed or rolled
+// back. InProgress is only cleared when the run has finished.
+type Journal struct {
+	Run        string   `json:"run"`
+	InProgress bool     `json:"in_progress,omitempty"`
+	Planned    []Rename `json:"planned,omitempty"`
+	Renames    []Rename `json:"renames"`
+}
+
+// WriteJournal writes j to the run directory runDir.
+func WriteJournal(fsys vfs.FS, runDir string, j Journal) error {
+	if j.Renames == nil {
+		j.Renames = []Rename{}
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return vfs.OrOS(fsys).WriteFile(filepath.Join(runDir, JournalName), append(data, '\n'), 0644)
+}
+
+// ReadJournal reads the journal of the run directory runDir.
+func ReadJournal(fsys vfs.FS, runDir string) (Journal, error) {
+	var j Journal
+	data, err := vfs.OrOS(fsys).ReadFile(filepath.Join(runDir, JournalName))
+	if err != nil {
+		return j, err
+	}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return j, fmt.Errorf("invalid journal %s: %w", filepath.Join(runDir, JournalName), err)
+	}
+	for _, r := range slices.Concat(j.Planned, j.Renames) {
+		if !isEntryName(r.From) || !isEntryName(r.To) {
+			return j, fmt.Errorf("invalid journal %s: the rename of %q to %q is not between single names", filepath.Join(runDir, JournalName), r.From, r.To)
+		}
+	}
+	return j, nil
+}
+
+// isEntryName reports whether name is a single path component.
+func isEntryName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// InterruptedRuns returns the journals of the runs in trash that are still in
+// progress, i.e. that were interrupted. A run that moved into trash itself
+// comes first, with an empty Run. Only subdirectories named like a run, see
+// NewRunName, or recorded in the trash index are considered, so that a
+// journal inside a pruned snapshot is never taken for a run. A missing trash
+// directory is not an error.
+func InterruptedRuns(fsys vfs.FS, trash string) ([]Journal, error) {
+	fsys = vfs.OrOS(fsys)
+	entries, err := fsys.ReadDir(trash)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	index, err := ReadTrashIndex(fsys, trash)
+	if err != nil {
+		return nil, err
+	}
+	runDirs := []string{trash}
+	for _, entry := range entries {
+		_, indexed := index[entry.Name()]
+		if entry.IsDir() && (indexed || isRunName(entry.Name())) {
+			runDirs = append(runDirs, filepath.Join(trash, entry.Name()))
+		}
+	}
+	var interrupted []Journal
+	for _, runDir := range runDirs {
+		j, err := ReadJournal(fsys, runDir)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // not a run directory
+		}
+		if err != nil {
+			return interrupted, err
+		}
+		if j.InProgress {
+			interrupted = append(interrupted, j)
+		}
+	}
+	return interrupted, nil
+}
+
+// journalWriter keeps the journal of a run up to date while Apply, Resume or
+// Restore rename.
+// A nil journalWriter records nothing.
+type journalWriter struct {
+	fsys   vfs.FS
+	runDir string
+	j      Journal
+	err    error // the first error writing the journal
+}
+
+func (w *journalWriter) record(r Rename) {
+	if w == nil {
+		return
+	}
+	w.j.Renames = append(w.j.Renames, r)
+	w.write()
+}
+
+// undo removes the rename at index i, as it was undone.
+func (w *journalWriter) undo(i int) {
+	w.j.Renames = slices.Delete(w.j.Renames, i, i+1)
+	w.write()
+}
+
+func (w *journalWriter) write() {
+	if err := WriteJournal(w.fsys, w.runDir, w.j); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// runMover moves the entries of a run between the directory of the
+// snapshots and the run directory. If fsys can open directories, see
+// vfs.DirOpener, it renames relative to the opened directories without
+// following symlinks or replacing existing entries, as Apply does.
+type runMover struct {
+	fsys vfs.FS
+	opts RestoreOptions
+	dirs *openedDirs // nil if fsys cannot open directories
+}
+
+// openRun opens the directories of the run opts.Run.
+func openRun(fsys vfs.FS, opts RestoreOptions) (*runMover, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("the directory of the snapshots is not given")
+	}
+	dirs, err := openDirs(fsys, ApplyOptions{Dir: opts.Dir, Trash: opts.Trash, Run: opts.Run}, true)
+	if err != nil {
+		return nil, err
+	}
+	return &runMover{fsys: fsys, opts: opts, dirs: dirs}, nil
+}
+
+func (m *runMover) close() {
+	m.dirs.close()
+}
+
+// from returns the path of the original location of r.
+func (m *runMover) from(r Rename) string {
+	return filepath.Join(m.opts.Dir, r.From)
+}
+
+// to returns the path of r in the run directory.
+func (m *runMover) to(r Rename) string {
+	return filepath.Join(m.opts.Trash, m.opts.Run, r.To)
+}
+
+// exists reports whether the entry name is in the run directory, or in the
+// directory of the snapshots if inRun is false, and the error if that
+// cannot be told.
+func (m *runMover) exists(inRun bool, name string) (bool, error) {
+	var err error
+	switch {
+	case m.dirs != nil && inRun:
+		_, err = m.dirs.dest.Lstat(name)
+	case m.dirs != nil:
+		_, err = m.dirs.root.Lstat(name)
+	case inRun:
+		_, err = m.fsys.Stat(filepath.Join(m.opts.Trash, m.opts.Run, name))
+	default:
+		_, err = m.fsys.Stat(filepath.Join(m.opts.Dir, name))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// move makes the rename r, or undoes it if back is set. An occupied target
+// is an error wrapping fs.ErrExist.
+func (m *runMover) move(r Rename, back bool) error {
+	from, to := m.from(r), m.to(r)
+	if back {
+		from, to = to, from
+	}
+	cross := m.opts.crossDevice(m.fsys, from, to)
+	if m.dirs != nil && !cross {
+		if back {
+			return m.dirs.dest.Rename(r.To, m.dirs.root, r.From)
+		}
+		return m.dirs.rename(r.From, r.To)
+	}
+	if _, err := m.fsys.Stat(to); err == nil {
+		return &fs.PathError{Op: "rename", Path: to, Err: fs.ErrExist}
+	}
+	return moveTree(m.fsys, from, to, cross, 0)
+}
+
+// reconcile adds the planned renames to j that were made but not recorded,
+// as the process was killed between the rename and the journal update.
+func (m *runMover) reconcile(j *Journal) {
+	done := make(map[Rename]bool, len(j.Renames))
+	for _, r := range j.Renames {
+		done[r] = true
+	}
+	for _, r := range j.Planned {
+		if done[r] {
+			continue
+		}
+		if exists, err := m.exists(false, r.From); exists || err != nil {
+			continue
+		}
+		if exists, _ := m.exists(true, r.To); exists {
+			j.Renames = append(j.Renames, r)
+		}
+	}
+}
+
+// RestoreOptions controls which run Restore, Resume or Rollback work on.
+type RestoreOptions struct {
+	FS       vfs.FS     // the filesystem to work on; nil means the operating system's filesystem
+	Dir      string     // the directory the snapshots were pruned from
+	Trash    string     // the trash directory
+	Run      string     // the run directory in Trash, see NewRunName; empty for a run that moved into Trash itself
+	Now      time.Time  // the time recorded in the trash index by Resume and Rollback; time.Now() if zero
+	Progress func(Move) // optional, called after each attempted move
+
+	// CopyAcrossDevices allows moving snapshots across devices by copying
+	// and removing them, see ApplyOptions.
+	CopyAcrossDevices bool
+}
+
+// crossDevice reports whether moving from to to needs copying, i.e. if
+// copying is allowed and they are on different devices.
+func (opts RestoreOptions) crossDevice(fsys vfs.FS, from, to string) bool {
+	if !opts.CopyAcrossDevices {
+		return false
+	}
+	cross, _ := CrossDevice(fsys, from, to) // if unknown, renaming reports the problem
+	return cross
+}
+
+// Restore moves the snapshots and sidecar files of a run back to opts.Dir,
+// undoing the renames of its journal in reverse order. A rename is refused
+// if its original name is occupied again. All renames are attempted, even if
+// some of them fail; each one undone is removed from the journal right away,
+// so that Restore can be repeated after fixing the cause or after being
+// interrupted itself. The moves of the result go from the trash to the
+// original location.
+func Restore(opts RestoreOptions) (Result, error) {
+	fsys := vfs.OrOS(opts.FS)
+	var result Result
+	runDir := filepath.Join(opts.Trash, opts.Run)
+	j, err := ReadJournal(fsys, runDir)
+	if err != nil {
+		return result, fmt.Errorf("could not read the journal of run %s: %w", opts.Run, err)
+	}
+	m, err := openRun(fsys, opts)
+	if err != nil {
+		return result, err
+	}
+	defer m.close()
+	m.reconcile(&j)
+	w := &journalWriter{fsys: fsys, runDir: runDir, j: j}
+
+	renames := slices.Clone(j.Renames)
+	for i := len(renames) - 1; i >= 0; i-- {
+		r := renames[i]
+		move := Move{Name: r.From, From: m.to(r), To: m.from(r)}
+		move.Err = m.move(r, true)
+		if move.Err == nil {
+			w.undo(i)
+		}
+		result.Moves = append(result.Moves, move)
+		if opts.Progress != nil {
+			opts.Progress(move)
+		}
+	}
+
+	var journalErr error
+	if w.err != nil {
+		journalErr = fmt.Errorf("could not update journal: %w", w.err)
+	}
+	if failed := result.Failed(); failed > 0 {
+		return result, errors.Join(&PartialError{Failed: failed, Total: len(result.Moves), What: "entries of run " + opts.Run + " could not be restored"}, journalErr)
+	}
+	return result, journalErr
+}
+
+// Resume completes the interrupted run opts.Run, see InterruptedRuns: the
+// planned renames not made yet are made, the journal is finished and the run
+// is recorded in the trash index and manifest. A planned snapshot that has
+// vanished in the meantime is reported as failed.
+func Resume(opts RestoreOptions) (Result, error) {
+	fsys := vfs.OrOS(opts.FS)
+	var result Result
+	runDir := filepath.Join(opts.Trash, opts.Run)
+	j, err := ReadJournal(fsys, runDir)
+	if err != nil {
+		return result, fmt.Errorf("could not read the journal of run %s: %w", opts.Run, err)
+	}
+	m, err := openRun(fsys, opts)
+	if err != nil {
+		return result, err
+	}
+	defer m.close()
+	m.reconcile(&j)
+	w := &journalWriter{fsys: fsys, runDir: runDir, j: j}
+
+	done := make(map[Rename]bool, len(j.Renames))
+	for _, r := range j.Renames {
+		done[r] = true
+	}
+	for _, r := range j.Planned {
+		if done[r] {
+			continue
+		}
+		move := Move{Name: r.From, From: m.from(r), To: m.to(r)}
+		move.Err = m.move(r, false)
+		if move.Err == nil {
+			w.record(r)
+		}
+		result.Moves = append(result.Moves, move)
+		if opts.Progress != nil {
+			opts.Progress(move)
+		}
+	}
+	w.j.InProgress = false
+	w.write()
+
+	errs := []error{finishRun(fsys, opts, w.j.Renames, result)}
+	if w.err != nil {
+		errs = append(errs, fmt.Errorf("could not update journal: %w", w.err))
+	}
+	if failed := result.Failed(); failed > 0 {
+		errs = append([]error{&PartialError{Failed: failed, Total: len(result.Moves), What: "remaining entries of run " + opts.Run + " could not be moved"}}, errs...)
+	}
+	return result, errors.Join(errs...)
+}
+
+// Rollback undoes the interrupted run opts.Run, see InterruptedRuns and
+// Restore. The journal is finished first, so that a failed rollback can be
+// completed with Restore.
+func Rollback(opts RestoreOptions) (Result, error) {
+	fsys := vfs.OrOS(opts.FS)
+	runDir := filepath.Join(opts.Trash, opts.Run)
+	j, err := ReadJournal(fsys, runDir)
+	if err != nil {
+		return Result{}, fmt.Errorf("could not read the journal of run %s: %w", opts.Run, err)
+	}
+	m, err := openRun(fsys, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	m.reconcile(&j)
+	m.close()
+	j.InProgress = false
+	if err := WriteJournal(fsys, runDir, j); err != nil {
+		return Result{}, fmt.Errorf("could not update journal: %w", err)
+	}
+	result, err := Restore(opts)
+	return result, errors.Join(err, finishRun(fsys, opts, nil, Result{}))
+}
+
+// finishRun records the interrupted run in the trash index, so that it is
+// purged as a whole, and adds the moves of result to its manifest. A run
+// without a run directory has no manifest; its renames, all of them made by
+// now, are recorded in the index one by one instead.
+func finishRun(fsys vfs.FS, opts RestoreOptions, renames []Rename, result Result) error {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if opts.Run == "" {
+		if err := recordMoves(fsys, opts.Trash, "", renames, now); err != nil {
+			return fmt.Errorf("could not update trash index: %w", err)
+		}
+		return nil
+	}
+	index, err := ReadTrashIndex(fsys, opts.Trash)
+	if err == nil {
+		index[opts.Run] = now
+		err = WriteTrashIndex(fsys, opts.Trash, index)
+	}
+	if err != nil {
+		return fmt.Errorf("could not update trash index: %w", err)
+	}
+	if len(result.Moves) == 0 {
+		return nil
+	}
+	runDir := filepath.Join(opts.Trash, opts.Run)
+	manifest, err := ReadManifest(fsys, runDir)
+	if err != nil {
+		return fmt.Errorf("could not update manifest: %w", err)
+	}
+	manifest.Moves = append(manifest.Moves, NewManifest("", now, "", Decisions{}, result).Moves...)
+	if err := WriteManifest(fsys, runDir, manifest); err != nil {
+		return fmt.Errorf("could not update manifest: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package retention
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+const testRun = "2024-06-17_09-54-21"
+
+func TestApply_WritesJournal(t *testing.T) {
+	m := newMemBackup(t, "a-2024-06-17_09-15", "b-2024-06-17_09-15")
+	if err := m.CreateFile("/backup/a-2024-06-17_09-15.sha256", 10, 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.Fault = func(op, name string) error {
+		if op == "rename" && filepath.Base(name) == "b-2024-06-17_09-15" {
+			return &os.LinkError{Op: "rename", Old: name, New: name, Err: syscall.EACCES}
+		}
+		return nil
+	}
+
+	_, err := Apply(Decisions{Prune: []string{"a-2024-06-17_09-15", "b-2024-06-17_09-15"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun, Sidecars: []string{".sha256"}})
+	if err == nil {
+		t.Fatalf("expected an error for the failed move")
+	}
+	j, err := ReadJournal(m, "/backup/to_delete/"+testRun)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []Rename{
+		{From: "a-2024-06-17_09-15", To: "a-2024-06-17_09-15"},
+		{From: "a-2024-06-17_09-15.sha256", To: "a-2024-06-17_09-15.sha256"},
+	}
+	if !reflect.DeepEqual(j.Renames, want) || len(j.Planned) != 3 || j.InProgress {
+		t.Errorf("journal = %+v, want renames %+v", j, want)
+	}
+}
+
+func TestApply_JournalBeforeRename(t *testing.T) {
+	m := newMemBackup(t, "a", "b")
+	var seen []Journal
+	m.Fault = func(op, name string) error {
+		if op == "rename" {
+			j, err := ReadJournal(m, "/backup/to_delete/"+testRun)
+			if err != nil {
+				t.Errorf("expected the journal to be written before renaming %s: %v", name, err)
+			}
+			seen = append(seen, j)
+		}
+		return nil
+	}
+
+	if _, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun}); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || !seen[0].InProgress || len(seen[0].Planned) != 2 || len(seen[0].Renames) != 0 || len(seen[1].Renames) != 1 {
+		t.Errorf("unexpected journals during the run: %+v", seen)
+	}
+	if runs, err := InterruptedRuns(m, "/backup/to_delete"); err != nil || len(runs) != 0 {
+		t.Errorf("expected no interrupted run, got %+v, %v", runs, err)
+	}
+}
+
+func TestApply_JournalError(t *testing.T) {
+	m := newMemBackup(t, "a")
+	m.Fault = func(op, name string) error {
+		if op == "write" && filepath.Base(name) == JournalName {
+			return &os.PathError{Op: "open", Path: name, Err: syscall.ENOSPC}
+		}
+		return nil
+	}
+	result, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun})
+	if !errors.Is(err, syscall.ENOSPC) || len(result.Moves) != 0 {
+		t.Errorf("expected nothing to be moved without a journal, got %+v, %v", result, err)
+	}
+	if _, err := m.Stat("/backup/a"); err != nil {
+		t.Errorf("expected a to stay: %v", err)
+	}
+}
+
+// newInterruptedRun simulates a run killed while moving a, b and c: a was
+// moved and recorded, b was moved but not recorded and c was not moved.
+func newInterruptedRun(t *testing.T) *vfs.MemFS {
+	t.Helper()
+	m := newMemBackup(t, "c", "to_delete/"+testRun+"/a", "to_delete/"+testRun+"/b")
+	runDir := "/backup/to_delete/" + testRun
+	rename := func(name string) Rename { return Rename{From: name, To: name} }
+	if err := WriteManifest(m, runDir, Manifest{Run: testRun, Dir: "/backup", Prune: []string{"a", "b", "c"}, Moves: []ManifestMove{}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteJournal(m, runDir, Journal{Run: testRun, InProgress: true, Planned: []Rename{rename("a"), rename("b"), rename("c")}, Renames: []Rename{rename("a")}}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestInterruptedRuns(t *testing.T) {
+	m := newInterruptedRun(t)
+	if err := m.MkdirAll("/backup/to_delete/old_snapshot", 0755); err != nil {
+		t.Fatal(err)
+	}
+	runs, err := InterruptedRuns(m, "/backup/to_delete")
+	if err != nil || len(runs) != 1 || runs[0].Run != testRun {
+		t.Errorf("InterruptedRuns() = %+v, %v", runs, err)
+	}
+
+	// a journal in a pruned snapshot is not a run, unlike a second run of
+	// the same second or a run with a custom name recorded in the index
+	for _, run := range []string{"old_snapshot", testRun + "_2", "custom"} {
+		if err := m.MkdirAll(filepath.Join("/backup/to_delete", run), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteJournal(m, filepath.Join("/backup/to_delete", run), Journal{Run: run, InProgress: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteTrashIndex(m, "/backup/to_delete", TrashIndex{"custom": time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	runs, err = InterruptedRuns(m, "/backup/to_delete")
+	var names []string
+	for _, r := range runs {
+		names = append(names, r.Run)
+	}
+	if want := []string{testRun, testRun + "_2", "custom"}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("InterruptedRuns() = %v, %v, want %v", names, err, want)
+	}
+}
+
+func TestResume(t *testing.T) {
+	m := newInterruptedRun(t)
+	now := time.Date(2024, 6, 17, 10, 0, 0, 0, time.UTC)
+
+	result, err := Resume(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun, Now: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result.Moves) != 1 || result.Moves[0].Name != "c" {
+		t.Errorf("expected only c to be moved, got %+v", result)
+	}
+	if _, err := m.Stat("/backup/to_delete/" + testRun + "/c"); err != nil {
+		t.Errorf("expected c in the run directory: %v", err)
+	}
+	j, _ := ReadJournal(m, "/backup/to_delete/"+testRun)
+	if j.InProgress || len(j.Renames) != 3 {
+		t.Errorf("expected a finished journal with all renames, got %+v", j)
+	}
+	if index, _ := ReadTrashIndex(m, "/backup/to_delete"); !reflect.DeepEqual(index, TrashIndex{testRun: now}) {
+		t.Errorf("index = %v", index)
+	}
+	if manifest, _ := ReadManifest(m, "/backup/to_delete/"+testRun); len(manifest.Moves) != 1 {
+		t.Errorf("expected the resumed move in the manifest, got %+v", manifest)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	m := newInterruptedRun(t)
+
+	result, err := Rollback(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Moved() != 2 {
+		t.Errorf("expected a and b to be moved back, got %+v", result)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := m.Stat("/backup/" + name); err != nil {
+			t.Errorf("expected %s in the backup directory: %v", name, err)
+		}
+	}
+	if runs, _ := InterruptedRuns(m, "/backup/to_delete"); len(runs) != 0 {
+		t.Errorf("expected the run to be finished, got %+v", runs)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	m := newMemBackup(t, "a", "b")
+	if _, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun}); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []string
+	result, err := Restore(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun, Progress: func(m Move) { progress = append(progress, m.To) }})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(progress, []string{"/backup/b", "/backup/a"}) || result.Moved() != 2 {
+		t.Errorf("restored %v, result %+v", progress, result)
+	}
+	for _, name := range []string{"/backup/a", "/backup/b"} {
+		if _, err := m.Stat(name); err != nil {
+			t.Errorf("expected %s to be restored: %v", name, err)
+		}
+	}
+	if j, err := ReadJournal(m, "/backup/to_delete/"+testRun); err != nil || len(j.Renames) != 0 {
+		t.Errorf("expected an empty journal, got %+v, %v", j, err)
+	}
+}
+
+func TestRestore_Occupied(t *testing.T) {
+	m := newMemBackup(t, "a", "b")
+	if _, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MkdirAll("/backup/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Restore(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun})
+	if err == nil || err.Error() != "1 of 2 entries of run "+testRun+" could not be restored" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if result.Moved() != 1 || !errors.Is(result.Moves[1].Err, fs.ErrExist) {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if _, err := m.Stat("/backup/to_delete/" + testRun + "/a"); err != nil {
+		t.Errorf("expected a to stay in the trash: %v", err)
+	}
+
+	// the journal only lists a now, so restoring again after freeing its name completes the restore
+	if err := m.RemoveAll("/backup/a"); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Restore(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun})
+	if err != nil || len(result.Moves) != 1 || result.Moves[0].To != "/backup/a" {
+		t.Errorf("Restore() = %+v, %v", result, err)
+	}
+}
+
+func TestRestore_RecordsEachUndo(t *testing.T) {
+	m := newMemBackup(t, "a", "b")
+	if _, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restore killed after undoing b must not list b anymore
+	var journals [][]Rename
+	_, err := Restore(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun, Progress: func(Move) {
+		j, _ := ReadJournal(m, "/backup/to_delete/"+testRun)
+		journals = append(journals, j.Renames)
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := [][]Rename{{{From: "a", To: "a"}}, {}}
+	if !reflect.DeepEqual(journals, want) {
+		t.Errorf("journals after each move = %v, want %v", journals, want)
+	}
+}
+
+func TestRestore_EditedJournal(t *testing.T) {
+	for _, r := range []Rename{
+		{From: "/etc", To: "a"},
+		{From: "a", To: "../../etc/passwd"},
+		{From: "..", To: "a"},
+		{From: `a\..`, To: "a"},
+		{From: "a", To: ""},
+	} {
+		m := newMemBackup(t, "to_delete/"+testRun+"/a")
+		if err := WriteJournal(m, "/backup/to_delete/"+testRun, Journal{Run: testRun, Renames: []Rename{r}}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := Restore(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun})
+		if err == nil || !strings.Contains(err.Error(), "invalid journal") || len(result.Moves) != 0 {
+			t.Errorf("%+v: expected the journal to be refused, got %+v, %v", r, result, err)
+		}
+		if _, err := m.Stat("/backup/to_delete/" + testRun + "/a"); err != nil {
+			t.Errorf("%+v: expected a to stay in the trash: %v", r, err)
+		}
+	}
+}
+
+func TestRestore_UnknownRun(t *testing.T) {
+	m := newMemBackup(t, "to_delete")
+	if _, err := Restore(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: "nope"}); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a missing journal to be reported, got %v", err)
+	}
+}
+
+func TestApply_JournalWithoutRun(t *testing.T) {
+	m := newMemBackup(t, "a")
+	if _, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Now: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	j, err := ReadJournal(m, "/backup/to_delete")
+	want := Journal{Planned: []Rename{{From: "a", To: "a"}}, Renames: []Rename{{From: "a", To: "a"}}}
+	if err != nil || !reflect.DeepEqual(j, want) {
+		t.Errorf("expected a finished journal in the flat layout, got %+v, %v", j, err)
+	}
+	if _, err := m.Stat("/backup/to_delete/" + ManifestName); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no manifest in the flat layout, got %v", err)
+	}
+}
+
+// newInterruptedFlatRun is like newInterruptedRun for a run that moved into
+// the trash directory itself.
+func newInterruptedFlatRun(t *testing.T) *vfs.MemFS {
+	t.Helper()
+	m := newMemBackup(t, "c", "to_delete/a", "to_delete/b", "to_delete/old")
+	rename := func(name string) Rename { return Rename{From: name, To: name} }
+	if err := WriteJournal(m, "/backup/to_delete", Journal{InProgress: true, Planned: []Rename{rename("a"), rename("b"), rename("c")}, Renames: []Rename{rename("a")}}); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestResume_WithoutRun(t *testing.T) {
+	m := newInterruptedFlatRun(t)
+	now := time.Date(2024, 6, 17, 10, 0, 0, 0, time.UTC)
+	runs, err := InterruptedRuns(m, "/backup/to_delete")
+	if err != nil || len(runs) != 1 || runs[0].Run != "" {
+		t.Fatalf("InterruptedRuns() = %+v, %v", runs, err)
+	}
+
+	result, err := Resume(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Now: now})
+	if err != nil || len(result.Moves) != 1 || result.Moves[0].To != "/backup/to_delete/c" {
+		t.Fatalf("Resume() = %+v, %v", result, err)
+	}
+	if index, _ := ReadTrashIndex(m, "/backup/to_delete"); !reflect.DeepEqual(index, TrashIndex{"a": now, "b": now, "c": now}) {
+		t.Errorf("expected all moved entries in the index, got %v", index)
+	}
+	if runs, _ := InterruptedRuns(m, "/backup/to_delete"); len(runs) != 0 {
+		t.Errorf("expected the run to be finished, got %+v", runs)
+	}
+}
+
+func TestRollback_WithoutRun(t *testing.T) {
+	m := newInterruptedFlatRun(t)
+
+	result, err := Rollback(RestoreOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete"})
+	if err != nil || result.Moved() != 2 {
+		t.Fatalf("Rollback() = %+v, %v", result, err)
+	}
+	for _, name := range []string{"a", "b", "c", "to_delete/old"} {
+		if _, err := m.Stat("/backup/" + name); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package retention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+// LockName is the name of the lock file in the directory of the snapshots,
+// see Lock.
+const LockName = ".prune_backups.lock"
+
+// LockHolder describes the process holding the lock, as recorded in the lock
+// file.
+type LockHolder struct {
+	PID   int       `json:"pid"`
+	Host  string    `json:"host"`
+	Start time.Time `json:"start"` // when the lock was taken
+}
+
+// LockedError is returned by Lock if another process holds the lock.
+type LockedError struct {
+	Path   string
+	Holder *LockHolder // nil if the lock file could not be read
+	// Running reports whether the holder is known to run on this host. If
+	// not, it runs on another host sharing the directory, or in another PID
+	// namespace, e.g. a container.
+	Running bool
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s is locked by another run of prune_backups", e.Path)
+	}
+	msg := fmt.Sprintf("%s is locked by another run of prune_backups: process %d on %s, started at %s", e.Path, e.Holder.PID, e.Holder.Host, e.Holder.Start.Format(time.DateTime))
+	if !e.Running {
+		msg += fmt.Sprintf(" (process %d is not visible here, it may run on another host or in a container)", e.Holder.PID)
+	}
+	return msg
+}
+
+func (e *LockedError) Unwrap() error {
+	return vfs.ErrLocked
+}
+
+// Lock takes an exclusive lock on the file LockName in dir, so that runs on
+// dir cannot overlap. If another process holds the lock, Lock waits for it if
+// wait is set, or returns a LockedError describing the holder. Filesystems
+// that do not support locking, see vfs.Locker, are not locked. The returned
+// function releases the lock.
+func Lock(fsys vfs.FS, dir string, wait bool) (unlock func() error, err error) {
+	fsys = vfs.OrOS(fsys)
+	noLock := func() error { return nil }
+	locker, ok := fsys.(vfs.Locker)
+	if !ok {
+		return noLock, nil
+	}
+	name := filepath.Join(dir, LockName)
+	host, _ := os.Hostname()
+	info, err := json.Marshal(LockHolder{PID: os.Getpid(), Host: host, Start: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	unlock, err = locker.Lock(name, wait, append(info, '\n'))
+	if errors.Is(err, errors.ErrUnsupported) {
+		return noLock, nil
+	}
+	if errors.Is(err, vfs.ErrLocked) {
+		locked := &LockedError{Path: dir}
+		var holder LockHolder
+		if data, err := fsys.ReadFile(name); err == nil && json.Unmarshal(data, &holder) == nil && holder.PID > 0 {
+			locked.Holder = &holder
+			locked.Running = holder.Host == host && processRunning(holder.PID)
+		}
+		return nil, locked
+	}
+	return unlock, err
+}
+
+// processRunning reports whether a process with the given PID exists. On
+// Windows, finding the process suffices.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM) || errors.Is(err, errors.ErrUnsupported)
+}
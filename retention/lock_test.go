@@ -0,0 +1,88 @@
+package retention
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+func TestLock(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		t.Skip("locking is not supported on this OS")
+	}
+	dir := t.TempDir()
+	unlock, err := Lock(vfs.OS{}, dir, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err = Lock(vfs.OS{}, dir, false)
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, vfs.ErrLocked) {
+		t.Fatalf("expected a LockedError, got %v", err)
+	}
+	if runtime.GOOS != "windows" {
+		if locked.Holder == nil || locked.Holder.PID != os.Getpid() || !locked.Running {
+			t.Errorf("expected this process as the holder, got %+v", locked)
+		}
+		if !strings.Contains(err.Error(), "process "+strconv.Itoa(os.Getpid())) {
+			t.Errorf("expected the holder in the message, got %q", err)
+		}
+	}
+
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(released)
+		_ = unlock()
+	}()
+	unlock, err = Lock(vfs.OS{}, dir, true)
+	select {
+	case <-released:
+	default:
+		t.Errorf("expected Lock to wait for the lock to be released")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLock_Unsupported(t *testing.T) {
+	m := newMemBackup(t, "a")
+	unlock, err := Lock(m, "/backup", false)
+	if err != nil || unlock() != nil {
+		t.Errorf("expected filesystems without locking not to be locked, got %v", err)
+	}
+}
+
+func TestLockedError(t *testing.T) {
+	start := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	err := &LockedError{Path: "/backup", Holder: &LockHolder{PID: 42, Host: "nas", Start: start}}
+	want := "/backup is locked by another run of prune_backups: process 42 on nas, started at 2024-06-17 09:54:21 (process 42 is not visible here, it may run on another host or in a container)"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestListFilesFS_LockFile(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{LockName, "db-2024-06-17_09-49.sql"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ListFiles(dir, "", nil)
+	if err != nil || len(files) != 1 || files[0] != "db-2024-06-17_09-49.sql" {
+		t.Errorf("ListFiles() = %v, %v", files, err)
+	}
+}
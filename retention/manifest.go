@@ -0,0 +1,108 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+// ManifestName is the name of the file in a run directory describing the run.
+const ManifestName = ".prune_backups_manifest.json"
+
+// RunNameFormat is the time layout of the names returned by NewRunName.
+const RunNameFormat = "2006-01-02_15-04-05"
+
+// Manifest describes the decisions and moves of a single run.
+type Manifest struct {
+	Run     string         `json:"run"`
+	Time    time.Time      `json:"time"`
+	Dir     string         `json:"dir"`
+	Keep    []string       `json:"keep"`
+	Prune   []string       `json:"prune"`
+	Skipped []string       `json:"skipped"`
+	Moves   []ManifestMove `json:"moves"`
+
+	Implausible []string `json:"implausible,omitempty"` // see Decisions.Implausible
+	Protected   []string `json:"protected,omitempty"`   // see Decisions.Protected
+}
+
+// ManifestMove is a Move as recorded in a Manifest.
+type ManifestMove struct {
+	Name      string    `json:"name"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Sidecars  []string  `json:"sidecars,omitempty"`
+	Collision Collision `json:"collision,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// NewManifest describes a run that applied d with result.
+func NewManifest(run string, now time.Time, dir string, d Decisions, result Result) Manifest {
+	m := Manifest{Run: run, Time: now, Dir: dir, Keep: d.Keep, Prune: d.Prune, Skipped: d.Skipped, Moves: []ManifestMove{}, Implausible: d.Implausible, Protected: d.Protected}
+	for _, move := range result.Moves {
+		mm := ManifestMove{Name: move.Name, From: move.From, To: move.To, Sidecars: move.Sidecars, Collision: move.Collision}
+		if move.Err != nil {
+			mm.Error = move.Err.Error()
+		}
+		m.Moves = append(m.Moves, mm)
+	}
+	return m
+}
+
+// WriteManifest writes m to the run directory runDir.
+func WriteManifest(fsys vfs.FS, runDir string, m Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return vfs.OrOS(fsys).WriteFile(filepath.Join(runDir, ManifestName), append(data, '\n'), 0644)
+}
+
+// ReadManifest reads the manifest of the run directory runDir.
+func ReadManifest(fsys vfs.FS, runDir string) (Manifest, error) {
+	var m Manifest
+	data, err := vfs.OrOS(fsys).ReadFile(filepath.Join(runDir, ManifestName))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid manifest %s: %w", filepath.Join(runDir, ManifestName), err)
+	}
+	return m, nil
+}
+
+// NewRunName returns a name for the run directory of a run at now that does
+// not exist in trash yet, e.g. 2024-06-17_09-54-21, or 2024-06-17_09-54-21_2
+// if a run in the same second preceded it.
+func NewRunName(fsys vfs.FS, trash string, now time.Time) string {
+	fsys = vfs.OrOS(fsys)
+	base := now.Format(RunNameFormat)
+	name := base
+	for i := 2; ; i++ {
+		if _, err := fsys.Stat(filepath.Join(trash, name)); err != nil {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+// isRunName reports whether name has the form of the names returned by
+// NewRunName.
+func isRunName(name string) bool {
+	if len(name) < len(RunNameFormat) {
+		return false
+	}
+	if _, err := time.Parse(RunNameFormat, name[:len(RunNameFormat)]); err != nil {
+		return false
+	}
+	suffix := name[len(RunNameFormat):]
+	if suffix == "" {
+		return true
+	}
+	n, ok := strings.CutPrefix(suffix, "_")
+	return ok && n != "" && n[0] != '0' && strings.Trim(n, "0123456789") == ""
+}
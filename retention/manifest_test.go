@@ -0,0 +1,100 @@
+package retention
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApply_RunDirectory(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	m := newMemBackup(t, "2024-06-17_09-49", "2024-06-17_09-15", "latest")
+	d := DefaultPolicy().Plan(now, []string{"2024-06-17_09-49", "2024-06-17_09-15", "latest"})
+
+	run := NewRunName(m, "/backup/to_delete", now)
+	if run != "2024-06-17_09-54-21" {
+		t.Errorf("NewRunName() = %q", run)
+	}
+	result, err := Apply(d, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: run, Now: now})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Moves[0].To != "/backup/to_delete/2024-06-17_09-54-21/2024-06-17_09-15" {
+		t.Errorf("unexpected destination %q", result.Moves[0].To)
+	}
+	if _, err := m.Stat(result.Moves[0].To); err != nil {
+		t.Errorf("expected the snapshot in the run directory: %v", err)
+	}
+
+	manifest, err := ReadManifest(m, "/backup/to_delete/"+run)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := Manifest{
+		Run: run, Time: now, Dir: "/backup",
+		Keep: []string{"2024-06-17_09-49"}, Prune: []string{"2024-06-17_09-15"}, Skipped: []string{"latest"},
+		Moves: []ManifestMove{{Name: "2024-06-17_09-15", From: "/backup/2024-06-17_09-15", To: result.Moves[0].To}},
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("manifest = %+v, want %+v", manifest, want)
+	}
+
+	index, err := ReadTrashIndex(m, "/backup/to_delete")
+	if err != nil || !reflect.DeepEqual(index, TrashIndex{run: now}) {
+		t.Errorf("index = %v, %v", index, err)
+	}
+}
+
+func TestApply_RunDirectorySameName(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	m := newMemBackup(t, "a")
+	for i := range 2 {
+		run := NewRunName(m, "/backup/to_delete", now)
+		if _, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: run, Now: now}); err != nil {
+			t.Fatalf("run %d: unexpected error: %v", i, err)
+		}
+		// a restored or recreated snapshot of the same name
+		if err := m.MkdirAll("/backup/a", 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"/backup/to_delete/2024-06-17_09-54-21/a", "/backup/to_delete/2024-06-17_09-54-21_2/a"} {
+		if _, err := m.Stat(dir); err != nil {
+			t.Errorf("expected %s: %v", dir, err)
+		}
+	}
+}
+
+func Test_isRunName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"2024-06-17_09-54-21":    true,
+		"2024-06-17_09-54-21_2":  true,
+		"2024-06-17_09-54-21_12": true,
+		"2024-06-17_09-54-21_":   false,
+		"2024-06-17_09-54-21_02": false,
+		"2024-06-17_09-54-21_x":  false,
+		"2024-06-17_09-54":       false,
+		"2024-13-17_09-54-21":    false,
+		"old_snapshot":           false,
+	} {
+		if got := isRunName(name); got != want {
+			t.Errorf("isRunName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestApply_RunDirectoryNothingToPrune(t *testing.T) {
+	m := newMemBackup(t, "a")
+	if _, err := Apply(Decisions{}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Run: "run"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.Stat("/backup/to_delete/run"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no run directory without pruned snapshots, got %v", err)
+	}
+	if _, err := ReadManifest(m, filepath.Join("/backup/to_delete", "run")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no manifest, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package retention
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+func getAllButFirstMatchingPrefix(from []string, prefix string) []string {
+	return getAllButBestMatchingPrefix(from, prefix, nil)
+}
+
+// getAllButBestMatchingPrefix is like getAllButFirstMatchingPrefix, but
+// passes over matches that are demoted, as long as there are other matches.
+// demoted is only called for matches that would win otherwise.
+func getAllButBestMatchingPrefix(from []string, prefix string, demoted func(string) bool) []string {
+	var matches []string
+	for _, s := range from {
+		if strings.HasPrefix(s, prefix) {
+			matches = append(matches, s)
+		}
+	}
+	best := 0
+	if demoted != nil && len(matches) > 1 {
+		for best < len(matches) && demoted(matches[best]) {
+			best++
+		}
+		if best == len(matches) {
+			best = 0 // all of them are demoted, so the first one wins anyway
+		}
+	}
+	var result = []string{} // make sure it's not nil
+	for i, s := range matches {
+		if i != best {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func getAnyMatchingAnyPrefixes(searchIn []string, prefixes []string) bool {
+	for _, s := range searchIn {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(s, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func prevMonth(year *int, month *int) {
+	*month--
+	if *month <= 0 {
+		*month = 12
+		*year--
+	}
+}
+
+func toDateStr(year int, month int) string {
+	return fmt.Sprintf("%04d-%02d", year, month)
+}
+
+func toDateStr3(year int, month int, day int) string {
+	return fmt.Sprintf("%04d-%02d-%02d", year, month, day)
+}
+
+func twoDigit(i int) string {
+	return fmt.Sprintf("%02d", i)
+}
+
+func daysInMonth(year int, month time.Month) int {
+	return getUltimo(year, month).Day()
+}
+
+func getUltimo(year int, month time.Month) time.Time {
+	// Start with the first day of the next month
+	t := time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+	// Subtract a day to get the last day of the original month
+	t = t.AddDate(0, 0, -1)
+	return t
+}
+
+func get15thOfMonthBefore(current_time time.Time) time.Time {
+	t := time.Date(current_time.Year(), current_time.Month(), 15, 0, 0, 0, 0, time.UTC)
+	t = t.AddDate(0, -1, 0)
+	return t
+}
@@ -0,0 +1,157 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// PlanFileVersion is the version of the plan file format written by
+// WritePlanFile.
+const PlanFileVersion = 1
+
+// PlanFile is a reviewed plan, computed at Time from the Candidates found in
+// Location, that is executed later as it is, see Drift.
+type PlanFile struct {
+	Version    int       `json:"version"`
+	Time       time.Time `json:"time"`
+	Location   string    `json:"location"` // the directory, or an s3:// or sftp:// location
+	To         string    `json:"to"`       // the name of the trash directory in Location
+	Delete     bool      `json:"delete,omitempty"`
+	Files      bool      `json:"files,omitempty"`
+	Match      string    `json:"match,omitempty"`
+	Sidecars   []string  `json:"sidecars,omitempty"`
+	Candidates []string  `json:"candidates"`
+	Keep       []string  `json:"keep"`
+	Prune      []string  `json:"prune"`
+	Skipped    []string  `json:"skipped"`
+	Incomplete []string  `json:"incomplete,omitempty"` // left out of planning, see Policy.Incomplete
+	// snapshots that did not win their time slot as they looked like failed
+	// backups, see Policy.Implausible
+	Implausible []string `json:"implausible,omitempty"`
+	// snapshots kept as symlinks in Location pointed to them, see Symlinks
+	Protected []string `json:"protected,omitempty"`
+
+	// the criterion the incomplete snapshots were found with, see Completeness
+	CompleteMarker    string `json:"complete_marker,omitempty"`
+	UnmodifiedMinutes int    `json:"unmodified_minutes,omitempty"`
+}
+
+// Decide plans the candidates with the default policy at p.Time, leaving
+// out the snapshots that were incomplete then, demoting those that were
+// implausible and keeping those that were protected.
+func (p PlanFile) Decide(candidates []string) Decisions {
+	policy := DefaultPolicy()
+	if len(p.Incomplete) > 0 {
+		policy.Incomplete = IsListed(p.Incomplete)
+	}
+	if len(p.Implausible) > 0 {
+		policy.Implausible = IsListed(p.Implausible)
+	}
+	if len(p.Protected) > 0 {
+		policy.Protected = IsListed(p.Protected)
+	}
+	if p.Files {
+		return policy.PlanFiles(p.Time, candidates)
+	}
+	return policy.Plan(p.Time, candidates)
+}
+
+// Decisions returns the decisions recorded in p.
+func (p PlanFile) Decisions() Decisions {
+	return Decisions{Keep: p.Keep, Prune: p.Prune, Skipped: p.Skipped, Incomplete: p.Incomplete, Implausible: p.Implausible, Protected: p.Protected}
+}
+
+// Completeness returns the criterion for complete snapshots recorded in p.
+func (p PlanFile) Completeness() Completeness {
+	return Completeness{Marker: p.CompleteMarker, Unmodified: time.Duration(p.UnmodifiedMinutes) * time.Minute}
+}
+
+// WritePlanFile writes p to the local file name.
+func WritePlanFile(name string, p PlanFile) error {
+	p.Version = PlanFileVersion
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0644)
+}
+
+// ReadPlanFile reads the local plan file name.
+func ReadPlanFile(name string) (PlanFile, error) {
+	var p PlanFile
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return p, fmt.Errorf("invalid plan file %s: %w", name, err)
+	}
+	if p.Version != PlanFileVersion {
+		return p, fmt.Errorf("plan file %s has version %d, expected %d", name, p.Version, PlanFileVersion)
+	}
+	return p, nil
+}
+
+// Drift lists how the snapshots changed since a plan was computed.
+type Drift struct {
+	Vanished  []string // planned to be pruned, but gone
+	NowKept   []string // planned to be pruned, but kept if planned again
+	NowPruned []string // planned to be kept or not known yet, but pruned if planned again
+}
+
+// Empty reports whether the plan can be executed as it is.
+func (d Drift) Empty() bool {
+	return len(d.Vanished) == 0 && len(d.NowKept) == 0 && len(d.NowPruned) == 0
+}
+
+// Drift compares p with the current candidates, planned again at p.Time, so
+// that only changes of the snapshots count, not the passing of time. New
+// snapshots dated after p.Time are left out, as they are regular backups
+// made since the plan was computed.
+func (p PlanFile) Drift(candidates []string) Drift {
+	var drift Drift
+	known := make(map[string]bool, len(p.Candidates))
+	for _, name := range p.Candidates {
+		known[name] = true
+	}
+	present := make(map[string]bool, len(candidates))
+	var current []string
+	for _, name := range candidates {
+		present[name] = true
+		if known[name] || !p.datedAfterPlan(name) {
+			current = append(current, name)
+		}
+	}
+	planned := make(map[string]bool, len(p.Prune))
+	for _, name := range p.Prune {
+		planned[name] = true
+	}
+	pruned := make(map[string]bool)
+	for _, name := range p.Decide(current).Prune {
+		pruned[name] = true
+		if !planned[name] {
+			drift.NowPruned = append(drift.NowPruned, name)
+		}
+	}
+	for _, name := range p.Prune {
+		if !present[name] {
+			drift.Vanished = append(drift.Vanished, name)
+		} else if !pruned[name] {
+			drift.NowKept = append(drift.NowKept, name)
+		}
+	}
+	sort.Strings(drift.NowPruned)
+	return drift
+}
+
+// datedAfterPlan reports whether the date in name is later than p.Time.
+func (p PlanFile) datedAfterPlan(name string) bool {
+	loc := dateInName.FindStringIndex(name)
+	if loc == nil {
+		return false
+	}
+	return name[loc[0]:] > p.Time.Format("2006-01-02_15-04")
+}
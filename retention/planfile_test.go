@@ -0,0 +1,68 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newPlanFile(candidates []string) PlanFile {
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	d := DefaultPolicy().Plan(now, candidates)
+	return PlanFile{Time: now, Location: "/backup", To: "to_delete", Candidates: candidates, Keep: d.Keep, Prune: d.Prune, Skipped: d.Skipped}
+}
+
+func TestPlanFile_RoundTrip(t *testing.T) {
+	p := newPlanFile([]string{"2024-06-17_09-49", "2024-06-17_09-15"})
+	name := filepath.Join(t.TempDir(), "plan.json")
+	if err := WritePlanFile(name, p); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadPlanFile(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p.Version = PlanFileVersion
+	if !reflect.DeepEqual(read, p) {
+		t.Errorf("ReadPlanFile() = %+v, want %+v", read, p)
+	}
+}
+
+func TestPlanFile_Drift(t *testing.T) {
+	p := newPlanFile([]string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_09-10", "2024-06-16_09-15", "2024-06-16_08-15"})
+	if !reflect.DeepEqual(p.Prune, []string{"2024-06-17_09-15", "2024-06-17_09-10", "2024-06-16_08-15"}) {
+		t.Fatalf("unexpected plan %v", p.Prune)
+	}
+
+	tests := []struct {
+		name       string
+		candidates []string
+		want       Drift
+	}{
+		{"unchanged", p.Candidates, Drift{}},
+		{"later snapshot in a new slot", append([]string{"2024-06-17_10-49"}, p.Candidates...), Drift{}},
+		{"vanished", []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-16_09-15", "2024-06-16_08-15"}, Drift{Vanished: []string{"2024-06-17_09-10"}}},
+		{"kept snapshot vanished", []string{"2024-06-17_09-15", "2024-06-17_09-10", "2024-06-16_09-15", "2024-06-16_08-15"}, Drift{NowKept: []string{"2024-06-17_09-15"}}},
+		{"later snapshot in the same slot", append([]string{"2024-06-17_09-52"}, p.Candidates...), Drift{NowPruned: []string{"2024-06-17_09-49"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			drift := p.Drift(tt.candidates)
+			if !reflect.DeepEqual(drift, tt.want) || drift.Empty() != tt.want.Empty() {
+				t.Errorf("Drift() = %+v, want %+v", drift, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadPlanFile_Version(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(name, []byte(`{"version": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPlanFile(name); err == nil {
+		t.Errorf("expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,133 @@
+package retention
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"prune_backups/stats"
+	"prune_backups/vfs"
+)
+
+// Plausibility tells failed backups, e.g. empty or nearly empty directories
+// left by an aborted rsync, from good ones, so that they do not push the last
+// good backup out of its time slot, see Policy.Implausible.
+type Plausibility struct {
+	MinFiles int     // the minimum number of files; not checked if 0
+	MinBytes uint64  // the minimum total size of the files; not checked if 0
+	MinRatio float64 // the minimum size relative to the next older snapshot, e.g. 0.5; not checked if 0
+}
+
+// Enabled reports whether any criterion is set.
+func (p Plausibility) Enabled() bool {
+	return p.MinFiles > 0 || p.MinBytes > 0 || p.MinRatio > 0
+}
+
+// Checker returns a PlausibilityChecker for the snapshots names in dir.
+func (p Plausibility) Checker(fsys vfs.FS, dir string, names []string) *PlausibilityChecker {
+	sorted := make([]string, 0, len(names))
+	for _, name := range names {
+		if dateInName.MatchString(name) {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return dateKey(sorted[i]) > dateKey(sorted[j]) })
+	return &PlausibilityChecker{
+		p:       p,
+		fsys:    vfs.OrOS(fsys),
+		dir:     dir,
+		sorted:  sorted,
+		usage:   make(map[string]usage),
+		Reasons: make(map[string]string),
+	}
+}
+
+// dateKey orders names by the part starting at their date, see
+// Policy.PlanFiles.
+func dateKey(name string) string {
+	if loc := dateInName.FindStringIndex(name); loc != nil {
+		return name[loc[0]:]
+	}
+	return name
+}
+
+// PlausibilityChecker scans snapshots with stats.DiskUsageFS on demand and
+// caches the results. Its Implausible method is meant for
+// Policy.Implausible.
+type PlausibilityChecker struct {
+	p      Plausibility
+	fsys   vfs.FS
+	dir    string
+	sorted []string // the snapshots, newest first
+
+	mutex sync.Mutex
+	usage map[string]usage
+	// Reasons explains for each implausible snapshot why it is implausible.
+	Reasons map[string]string
+	// Errors lists the snapshots that could not be scanned; they count as
+	// plausible.
+	Errors []error
+}
+
+type usage struct {
+	files int
+	bytes uint64
+	err   error
+}
+
+func (c *PlausibilityChecker) scan(name string) usage {
+	if u, ok := c.usage[name]; ok {
+		return u
+	}
+	var u usage
+	var info stats.Infoblock
+	info, u.err = stats.DiskUsageFS(c.fsys, filepath.Join(c.dir, name))
+	if u.err == nil {
+		u.files = info.NumberOfUnlinkedFiles + info.NumberOfLinkedFiles
+		u.bytes = info.SizeOfUnlinkedFiles + info.SizeOfLinkedFiles
+	} else {
+		c.Errors = append(c.Errors, fmt.Errorf("could not scan %s: %w", name, u.err))
+	}
+	c.usage[name] = u
+	return u
+}
+
+// Implausible reports whether the snapshot name fails any of the criteria.
+// The next older snapshot is scanned as well for Plausibility.MinRatio.
+func (c *PlausibilityChecker) Implausible(name string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	u := c.scan(name)
+	if u.err != nil {
+		return false
+	}
+	reason := ""
+	switch {
+	case c.p.MinFiles > 0 && u.files < c.p.MinFiles:
+		reason = fmt.Sprintf("it contains only %d files", u.files)
+	case c.p.MinBytes > 0 && u.bytes < c.p.MinBytes:
+		reason = fmt.Sprintf("its files have only %d bytes", u.bytes)
+	case c.p.MinRatio > 0:
+		if older := c.older(name); older != "" {
+			if o := c.scan(older); o.err == nil && float64(u.bytes) < c.p.MinRatio*float64(o.bytes) {
+				reason = fmt.Sprintf("its files have only %d bytes, compared with %d bytes in %s", u.bytes, o.bytes, older)
+			}
+		}
+	}
+	if reason == "" {
+		return false
+	}
+	c.Reasons[name] = reason
+	return true
+}
+
+// older returns the next older snapshot than name, or "" if there is none.
+func (c *PlausibilityChecker) older(name string) string {
+	for i, s := range c.sorted {
+		if s == name && i+1 < len(c.sorted) {
+			return c.sorted[i+1]
+		}
+	}
+	return ""
+}
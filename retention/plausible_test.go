@@ -0,0 +1,94 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_getAllButBestMatchingPrefix(t *testing.T) {
+	from := []string{"2024-06-17_09-49", "2024-06-17_09-30", "2024-06-17_09-15", "2024-06-17_08-49"}
+	demoted := func(names ...string) func(string) bool {
+		return func(s string) bool {
+			for _, name := range names {
+				if s == name {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	tests := []struct {
+		name    string
+		demoted func(string) bool
+		want    []string
+	}{
+		{"None", nil, []string{"2024-06-17_09-30", "2024-06-17_09-15"}},
+		{"Newest", demoted("2024-06-17_09-49"), []string{"2024-06-17_09-49", "2024-06-17_09-15"}},
+		{"TwoNewest", demoted("2024-06-17_09-49", "2024-06-17_09-30"), []string{"2024-06-17_09-49", "2024-06-17_09-30"}},
+		{"All", demoted("2024-06-17_09-49", "2024-06-17_09-30", "2024-06-17_09-15"), []string{"2024-06-17_09-30", "2024-06-17_09-15"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getAllButBestMatchingPrefix(from, "2024-06-17_09", tt.demoted); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getAllButBestMatchingPrefix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_PlanImplausible(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	var asked []string
+	p := DefaultPolicy()
+	p.Implausible = func(name string) bool {
+		asked = append(asked, name)
+		return name == "2024-06-17_09-49" || name == "2024-06-17_08-49"
+	}
+
+	d := p.Plan(now, []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-17_08-49"})
+	if !reflect.DeepEqual(d.Keep, []string{"2024-06-17_09-15", "2024-06-17_08-49"}) || !reflect.DeepEqual(d.Prune, []string{"2024-06-17_09-49"}) {
+		t.Errorf("unexpected decisions %+v", d)
+	}
+	// 08-49 is alone in its slot, so it is neither checked nor demoted
+	if !reflect.DeepEqual(asked, []string{"2024-06-17_09-49", "2024-06-17_09-15"}) || !reflect.DeepEqual(d.Implausible, []string{"2024-06-17_09-49"}) {
+		t.Errorf("checked %v, implausible %v", asked, d.Implausible)
+	}
+}
+
+func TestPlausibilityChecker(t *testing.T) {
+	m := newMemBackup(t, "2024-06-17_09-49", "2024-06-17_09-30", "2024-06-17_09-15", "latest")
+	files := map[string]int{"2024-06-17_09-30": 2, "2024-06-17_09-15": 4, "latest": 4}
+	for snapshot, n := range files {
+		for i := range n {
+			if err := m.CreateFile("/backup/"+snapshot+"/file"+string(rune('a'+i)), 100, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	names := []string{"2024-06-17_09-49", "2024-06-17_09-30", "2024-06-17_09-15", "latest"}
+
+	tests := []struct {
+		name   string
+		p      Plausibility
+		want   []bool
+		reason string
+	}{
+		{"MinFiles", Plausibility{MinFiles: 1}, []bool{true, false, false}, "it contains only 0 files"},
+		{"MinBytes", Plausibility{MinBytes: 300}, []bool{true, true, false}, "its files have only 0 bytes"},
+		{"MinRatio", Plausibility{MinRatio: 0.6}, []bool{true, true, false}, "its files have only 0 bytes, compared with 200 bytes in 2024-06-17_09-30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tt.p.Checker(m, "/backup", names)
+			for i, want := range tt.want {
+				if got := c.Implausible(names[i]); got != want {
+					t.Errorf("Implausible(%s) = %v, want %v", names[i], got, want)
+				}
+			}
+			if c.Reasons[names[0]] != tt.reason || len(c.Errors) != 0 {
+				t.Errorf("reasons %v, errors %v", c.Reasons, c.Errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Package retention decides which backup snapshots to keep and which to prune,
+// based on snapshot names following the pattern YYYY-MM-DD_HH-mm. It keeps one
+// snapshot per hour for a day, one per day for a month, and one per month
+// thereafter. Within each time slot, the latest snapshot is retained.
+package retention
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Policy describes how many snapshots are retained. Use DefaultPolicy to get
+// the behaviour of the prune_backups command line tool.
+type Policy struct {
+	// Monthlies is the number of monthly slots kept beyond the daily window.
+	Monthlies int
+	// Incomplete, if set, reports snapshots that are still being written,
+	// see Completeness. They are left out of planning, so that they neither
+	// displace a complete snapshot in their time slot nor are pruned
+	// themselves, and are listed in Decisions.Incomplete.
+	Incomplete func(name string) bool
+	// Implausible, if set, reports snapshots that look like failed backups,
+	// e.g. empty directories, see Plausibility. Such a snapshot does not win
+	// its time slot if there are others in it, and is listed in
+	// Decisions.Implausible. It is only called for snapshots that would win a
+	// time slot with others in it.
+	Implausible func(name string) bool
+	// Protected, if set, reports snapshots that must not be pruned, e.g. the
+	// targets of symlinks, see Symlinks. They are kept even if another
+	// snapshot wins their time slot, and are listed in Decisions.Protected
+	// if they would have been pruned otherwise.
+	Protected func(name string) bool
+}
+
+// Decisions is the result of Policy.Plan.
+type Decisions struct {
+	Keep        []string // snapshots to retain, sorted in descending order
+	Prune       []string // snapshots to move away, in the order they were decided
+	Skipped     []string // names not in date format; these are left untouched
+	Incomplete  []string // snapshots still being written, see Policy.Incomplete; these are left untouched
+	Implausible []string // snapshots found implausible by Policy.Implausible; these are kept only if nothing better is in their slot
+	Protected   []string // snapshots kept only because of Policy.Protected, sorted in descending order
+	Filters     []string // the time slot prefixes (YYYY-MM-DD_HH, YYYY-MM-DD or YYYY-MM) that were applied
+}
+
+// DefaultPolicy keeps 24 hourlies, 30 dailies and 119 monthlies.
+func DefaultPolicy() Policy {
+	return Policy{Monthlies: 119}
+}
+
+// Plan decides which of the given snapshot names to keep and which to prune,
+// relative to now. It does not perform any I/O and does not modify names.
+func (p Policy) Plan(now time.Time, names []string) Decisions {
+	var result Decisions
+	dirs := make([]string, 0, len(names))
+	for _, name := range names {
+		if p.Incomplete != nil && isDateFormat(name) && p.Incomplete(name) {
+			result.Incomplete = append(result.Incomplete, name)
+		} else {
+			dirs = append(dirs, name)
+		}
+	}
+
+	// Sort in descending order - caution: this is important for the algorithm!
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	sort.Sort(sort.Reverse(sort.StringSlice(result.Incomplete)))
+
+	result.Filters = getAllFilters(now, dirs, p.Monthlies)
+
+	result.Prune = []string{} // in this array we will collect all directories that we will move to the to_delete-directory
+	var demoted func(string) bool
+	if p.Implausible != nil {
+		demoted = func(name string) bool {
+			implausible := p.Implausible(name)
+			if implausible {
+				result.Implausible = append(result.Implausible, name)
+			}
+			return implausible
+		}
+	}
+	for _, filter := range result.Filters {
+		addToDelete := getAllButBestMatchingPrefix(dirs, filter, demoted)
+		result.Prune = append(result.Prune, addToDelete...)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(result.Implausible)))
+
+	cleanupOthers := getDateDirectoriesNotMatchingAnyPrefix(dirs, result.Filters)
+	result.Prune = append(result.Prune, cleanupOthers...)
+	if p.Protected != nil {
+		result.Prune = slices.DeleteFunc(result.Prune, func(name string) bool {
+			protected := p.Protected(name)
+			if protected {
+				result.Protected = append(result.Protected, name)
+			}
+			return protected
+		})
+		sort.Sort(sort.Reverse(sort.StringSlice(result.Protected)))
+	}
+
+	pruned := make(map[string]bool, len(result.Prune))
+	for _, dir := range result.Prune {
+		pruned[dir] = true
+	}
+	result.Keep = []string{}
+	result.Skipped = []string{}
+	for _, dir := range dirs {
+		if !isDateFormat(dir) {
+			result.Skipped = append(result.Skipped, dir)
+		} else if !pruned[dir] {
+			result.Keep = append(result.Keep, dir)
+		}
+	}
+	return result
+}
+
+var dateInName = regexp.MustCompile(`[\d]{4}\-[\d]{2}\-[\d]{2}`)
+
+// PlanFiles is like Plan for names that contain a date but may start with
+// something else, e.g. db-2024-06-17_09-49.sql.zst. Each name is classified
+// by the part starting at its first date; names without a date are skipped.
+// Use distinct patterns to plan archives of different kinds separately, as
+// db-2024-06-17_09-49.sql.zst and www-2024-06-17_09-15.tar.gz fall into the
+// same time slot.
+func (p Policy) PlanFiles(now time.Time, names []string) Decisions {
+	// The key starts with the date and ends with the original name after a
+	// "/", which cannot be part of a file name.
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		if loc := dateInName.FindStringIndex(name); loc != nil {
+			keys = append(keys, name[loc[0]:]+"/"+name)
+		} else {
+			keys = append(keys, name)
+		}
+	}
+	name := func(key string) string {
+		return key[strings.LastIndex(key, "/")+1:]
+	}
+	byKey := p
+	if p.Incomplete != nil {
+		byKey.Incomplete = func(key string) bool { return p.Incomplete(name(key)) }
+	}
+	if p.Implausible != nil {
+		byKey.Implausible = func(key string) bool { return p.Implausible(name(key)) }
+	}
+	if p.Protected != nil {
+		byKey.Protected = func(key string) bool { return p.Protected(name(key)) }
+	}
+	d := byKey.Plan(now, keys)
+	original := func(keys []string) []string {
+		result := make([]string, 0, len(keys))
+		for _, key := range keys {
+			result = append(result, name(key))
+		}
+		return result
+	}
+	d.Keep, d.Prune, d.Skipped = original(d.Keep), original(d.Prune), original(d.Skipped)
+	if d.Incomplete != nil {
+		d.Incomplete = original(d.Incomplete)
+	}
+	if d.Implausible != nil {
+		d.Implausible = original(d.Implausible)
+	}
+	if d.Protected != nil {
+		d.Protected = original(d.Protected)
+	}
+	return d
+}
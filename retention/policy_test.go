@@ -0,0 +1,103 @@
+package retention
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicy_Plan(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	given := []string{
+		// some hourly backups for the 17th
+		"2024-06-17_09-49", "2024-06-17_06-49", "2024-06-17_00-49",
+		// no hourly backups within the lase 24h on the 16th
+		"2024-06-16_03-49", "2024-06-16_02-49", "2024-06-16_01-49",
+		// and some normal daily backups to prune
+		"2024-06-15_23-49", "2024-06-15_13-49",
+		"2024-06-14_23-49",
+		"2024-06-13_23-49", "2024-06-13_22-49", "2024-06-13_21-49",
+		// not in date format
+		"latest", "to_delete",
+	}
+	original := append([]string{}, given...)
+
+	got := DefaultPolicy().Plan(now, given)
+
+	wantKeep := []string{
+		"2024-06-17_09-49", "2024-06-17_06-49", "2024-06-17_00-49",
+		"2024-06-16_03-49",
+		"2024-06-15_23-49",
+		"2024-06-14_23-49",
+		"2024-06-13_23-49",
+	}
+	wantPrune := []string{
+		"2024-06-16_02-49", "2024-06-16_01-49",
+		"2024-06-15_13-49",
+		"2024-06-13_22-49", "2024-06-13_21-49",
+	}
+	wantSkipped := []string{"to_delete", "latest"}
+
+	if !reflect.DeepEqual(got.Keep, wantKeep) {
+		compareArrays(got.Keep, wantKeep, t)
+		t.Errorf("Plan().Keep not as expected")
+	}
+	if !reflect.DeepEqual(got.Prune, wantPrune) {
+		compareArrays(got.Prune, wantPrune, t)
+		t.Errorf("Plan().Prune not as expected")
+	}
+	if !reflect.DeepEqual(got.Skipped, wantSkipped) {
+		t.Errorf("Plan().Skipped = %v, want %v", got.Skipped, wantSkipped)
+	}
+	if len(got.Filters) == 0 || got.Filters[0] != "2024-06-17_09" {
+		t.Errorf("Plan().Filters should start with the current hour, got %v", got.Filters)
+	}
+	if !reflect.DeepEqual(given, original) {
+		t.Errorf("Plan() modified its input: %v", given)
+	}
+}
+
+func TestPolicy_PlanEmpty(t *testing.T) {
+	got := DefaultPolicy().Plan(time.Now(), nil)
+	if len(got.Keep) != 0 || len(got.Prune) != 0 || len(got.Skipped) != 0 {
+		t.Errorf("expected empty decisions, got %+v", got)
+	}
+}
+
+func TestPolicy_PlanMonthlies(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	given := []string{"2024-03-31_23-49", "2024-02-29_23-49", "2024-01-31_23-49"}
+
+	got := Policy{Monthlies: 2}.Plan(now, given)
+
+	// the daily window ends in May, so only April and March are monthly slots
+	wantKeep := []string{"2024-03-31_23-49"}
+	wantPrune := []string{"2024-02-29_23-49", "2024-01-31_23-49"}
+	if !reflect.DeepEqual(got.Keep, wantKeep) || !reflect.DeepEqual(got.Prune, wantPrune) {
+		t.Errorf("Plan() = keep %v, prune %v; want keep %v, prune %v", got.Keep, got.Prune, wantKeep, wantPrune)
+	}
+}
+
+func TestPolicy_PlanFiles(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	given := []string{
+		"db-2024-06-17_09-49.sql.zst", "db-2024-06-17_09-15.sql.zst",
+		"db-2024-06-14_23-49.sql.zst", "db-2024-06-14_22-49.sql.zst",
+		"2024-06-15_23-49.tar.gz", "2024-06-15_22-49.tar.gz",
+		"db-latest.sql.zst",
+	}
+
+	got := DefaultPolicy().PlanFiles(now, given)
+
+	wantKeep := []string{"db-2024-06-17_09-49.sql.zst", "2024-06-15_23-49.tar.gz", "db-2024-06-14_23-49.sql.zst"}
+	wantPrune := []string{"db-2024-06-17_09-15.sql.zst", "2024-06-15_22-49.tar.gz", "db-2024-06-14_22-49.sql.zst"}
+	if !reflect.DeepEqual(got.Keep, wantKeep) {
+		t.Errorf("PlanFiles().Keep = %v, want %v", got.Keep, wantKeep)
+	}
+	if !reflect.DeepEqual(got.Prune, wantPrune) {
+		t.Errorf("PlanFiles().Prune = %v, want %v", got.Prune, wantPrune)
+	}
+	if !reflect.DeepEqual(got.Skipped, []string{"db-latest.sql.zst"}) {
+		t.Errorf("PlanFiles().Skipped = %v", got.Skipped)
+	}
+}
@@ -0,0 +1,156 @@
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"prune_backups/vfs"
+)
+
+// Reasons why a planned move cannot be done, reported in a Problem.
+var (
+	ErrNotWritable = errors.New("not writable")
+	ErrMountPoint  = errors.New("is a mount point")
+	ErrImmutable   = errors.New("has the immutable flag set")
+	ErrAppendOnly  = errors.New("has the append-only flag set")
+)
+
+// Problem is a reason why the planned moves cannot be done.
+type Problem struct {
+	Path string
+	Err  error
+}
+
+// PreflightError is returned by Apply if any of the checks of Preflight
+// failed. No snapshot has been moved in this case.
+type PreflightError struct {
+	Problems []Problem
+}
+
+func (e *PreflightError) Error() string {
+	lines := []string{fmt.Sprintf("found %d problems before moving anything, nothing was pruned:", len(e.Problems))}
+	for _, p := range e.Problems {
+		lines = append(lines, fmt.Sprintf(" - %s: %s", p.Path, p.Err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (e *PreflightError) Unwrap() []error {
+	errs := make([]error, len(e.Problems))
+	for i, p := range e.Problems {
+		errs[i] = p.Err
+	}
+	return errs
+}
+
+// Preflight checks whether all snapshots in d.Prune and their sidecar files
+// can be moved or removed as Apply would do it with opts, without changing
+// anything. It returns a PreflightError listing all problems found: the trash
+// directory on another device, see CrossDeviceError, and, if the filesystem
+// is a vfs.Inspector, parent directories that are not writable, snapshots that
+// are mount points and files with the immutable or append-only flag.
+func Preflight(d Decisions, opts ApplyOptions) error {
+	fsys := vfs.OrOS(opts.FS)
+	if !opts.Delete {
+		if cross, err := CrossDevice(fsys, opts.Dir, opts.Trash); err == nil && cross {
+			opts.crossDevice = true
+		}
+	}
+	return preflight(fsys, planMoves(fsys, d, opts), opts)
+}
+
+func preflight(fsys vfs.FS, plan []plannedMove, opts ApplyOptions) error {
+	var problems []Problem
+	if opts.crossDevice && !opts.CopyAcrossDevices {
+		problems = append(problems, Problem{Path: opts.Trash, Err: &CrossDeviceError{Dir: opts.Dir, Trash: opts.Trash}})
+	}
+	inspector, ok := fsys.(vfs.Inspector)
+	if !ok || len(plan) == 0 {
+		return preflightError(problems)
+	}
+	checked := make(map[string]bool)
+	check := func(name string, reasons func(vfs.Inspection) []error) bool {
+		if checked[name] {
+			return true
+		}
+		checked[name] = true
+		in, err := inspector.Inspect(name)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return false
+		}
+		errs := []error{err}
+		if err == nil {
+			errs = reasons(in)
+		}
+		for _, err := range errs {
+			if err != nil {
+				problems = append(problems, Problem{Path: name, Err: err})
+			}
+		}
+		return true
+	}
+
+	// entries are removed from the directory of the snapshots
+	supported := check(opts.Dir, func(in vfs.Inspection) []error {
+		return []error{writable(in), flag(in.Immutable, ErrImmutable), flag(in.AppendOnly, ErrAppendOnly)}
+	})
+	if !supported {
+		return preflightError(problems)
+	}
+	if !opts.Delete {
+		// entries are added to the trash directory, or to its nearest accessible
+		// parent if it is created by Apply
+		trash := opts.destination()
+		for {
+			if _, err := fsys.Stat(trash); err == nil || filepath.Dir(trash) == trash {
+				break
+			}
+			trash = filepath.Dir(trash)
+		}
+		check(trash, func(in vfs.Inspection) []error {
+			return []error{writable(in), flag(in.Immutable, ErrImmutable)}
+		})
+	}
+	for _, pm := range plan {
+		for _, t := range append([]target{pm.target}, pm.sidecars...) {
+			if t.collision == CollisionSkip {
+				continue
+			}
+			name := filepath.Join(opts.Dir, t.name)
+			info, err := fsys.Stat(name)
+			if err != nil {
+				continue // e.g. vanished in the meantime, which moving reports
+			}
+			check(name, func(in vfs.Inspection) []error {
+				errs := []error{flag(in.MountPoint, ErrMountPoint), flag(in.Immutable, ErrImmutable), flag(in.AppendOnly, ErrAppendOnly)}
+				if info.IsDir() {
+					// moving a directory to another parent updates its entry "..", and
+					// removing it removes its entries
+					errs = append(errs, writable(in))
+				}
+				return errs
+			})
+		}
+	}
+	return preflightError(problems)
+}
+
+func writable(in vfs.Inspection) error {
+	return flag(!in.Writable, ErrNotWritable)
+}
+
+func flag(set bool, err error) error {
+	if set {
+		return err
+	}
+	return nil
+}
+
+func preflightError(problems []Problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return &PreflightError{Problems: problems}
+}
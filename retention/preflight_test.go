@@ -0,0 +1,109 @@
+package retention
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"prune_backups/vfs"
+)
+
+// inspected reports the given properties for some files and a writable
+// directory without flags for all others.
+type inspected struct {
+	*vfs.MemFS
+	props map[string]vfs.Inspection
+}
+
+func (f inspected) Inspect(name string) (vfs.Inspection, error) {
+	if _, err := f.Stat(name); err != nil {
+		return vfs.Inspection{}, err
+	}
+	if in, ok := f.props[name]; ok {
+		return in, nil
+	}
+	return vfs.Inspection{Writable: true}, nil
+}
+
+func TestApply_Preflight(t *testing.T) {
+	m := newMemBackup(t, "a-2024-06-17_09-15", "b", "c", "d")
+	if err := m.CreateFile("/backup/a-2024-06-17_09-15.sha256", 10, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fsys := inspected{m, map[string]vfs.Inspection{
+		"/backup/a-2024-06-17_09-15.sha256": {Writable: true, AppendOnly: true},
+		"/backup/b":                         {Writable: true, MountPoint: true},
+		"/backup/c":                         {Writable: false},
+		"/backup/d":                         {Writable: true, Immutable: true},
+	}}
+
+	result, err := Apply(Decisions{Prune: []string{"a-2024-06-17_09-15", "b", "c", "d"}}, ApplyOptions{FS: fsys, Dir: "/backup", Trash: "/backup/to_delete", Run: testRun, Sidecars: []string{".sha256"}})
+	var preflightErr *PreflightError
+	if !errors.As(err, &preflightErr) || len(result.Moves) != 0 {
+		t.Fatalf("expected a PreflightError before moving, got %+v, %v", result, err)
+	}
+	want := []Problem{
+		{Path: "/backup/a-2024-06-17_09-15.sha256", Err: ErrAppendOnly},
+		{Path: "/backup/b", Err: ErrMountPoint},
+		{Path: "/backup/c", Err: ErrNotWritable},
+		{Path: "/backup/d", Err: ErrImmutable},
+	}
+	if len(preflightErr.Problems) != len(want) {
+		t.Fatalf("problems = %+v, want %+v", preflightErr.Problems, want)
+	}
+	for i, p := range preflightErr.Problems {
+		if p != want[i] {
+			t.Errorf("problem %d = %+v, want %+v", i, p, want[i])
+		}
+	}
+	if !errors.Is(err, ErrMountPoint) || !strings.HasPrefix(err.Error(), "found 4 problems before moving anything") {
+		t.Errorf("unexpected error %q", err)
+	}
+	for _, name := range []string{"/backup/a-2024-06-17_09-15", "/backup/b", "/backup/c", "/backup/d"} {
+		if _, err := m.Stat(name); err != nil {
+			t.Errorf("expected %s to stay: %v", name, err)
+		}
+	}
+	if _, err := m.Stat("/backup/to_delete"); err == nil {
+		t.Errorf("expected the trash directory not to be created")
+	}
+}
+
+func TestPreflight_Directories(t *testing.T) {
+	m := newMemBackup(t, "a", "trash")
+	fsys := inspected{m, map[string]vfs.Inspection{
+		"/backup":       {Writable: true, AppendOnly: true},
+		"/backup/trash": {Writable: false},
+	}}
+
+	err := Preflight(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: fsys, Dir: "/backup", Trash: "/backup/trash/to_delete", Run: testRun})
+	var preflightErr *PreflightError
+	if !errors.As(err, &preflightErr) {
+		t.Fatalf("expected a PreflightError, got %v", err)
+	}
+	want := []Problem{{Path: "/backup", Err: ErrAppendOnly}, {Path: "/backup/trash", Err: ErrNotWritable}}
+	if len(preflightErr.Problems) != 2 || preflightErr.Problems[0] != want[0] || preflightErr.Problems[1] != want[1] {
+		t.Errorf("problems = %+v, want %+v", preflightErr.Problems, want)
+	}
+
+	// the trash directory is not checked if the snapshots are deleted
+	err = Preflight(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: fsys, Dir: "/backup", Trash: "/backup/trash/to_delete", Delete: true})
+	if !errors.As(err, &preflightErr) || len(preflightErr.Problems) != 1 {
+		t.Errorf("expected only the directory of the snapshots to be reported, got %v", err)
+	}
+}
+
+func TestPreflight_CrossDevice(t *testing.T) {
+	m := newMemBackup(t, "a")
+	if err := m.MkdirAll("/trash", 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := Preflight(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: twoDevices{m}, Dir: "/backup", Trash: "/trash/to_delete"})
+	var crossErr *CrossDeviceError
+	if !errors.As(err, &crossErr) {
+		t.Errorf("expected a CrossDeviceError, got %v", err)
+	}
+	if err := Preflight(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: twoDevices{m}, Dir: "/backup", Trash: "/trash/to_delete", CopyAcrossDevices: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
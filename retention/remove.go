@@ -0,0 +1,180 @@
+package retention
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"prune_backups/stats"
+	"prune_backups/vfs"
+)
+
+// DefaultRemoveWorkers is the number of directories RemoveTree works on
+// concurrently if no other limit is given.
+const DefaultRemoveWorkers = 64
+
+// Removal summarizes what RemoveTree removed.
+type Removal struct {
+	Files      int    // files, symlinks and other non-directories removed
+	Dirs       int    // directories removed
+	Bytes      uint64 // total size of the removed files
+	FreedBytes uint64 // size of the removed files that had no other hard links
+	Errors     []error
+}
+
+func (r *Removal) add(other Removal) {
+	r.Files += other.Files
+	r.Dirs += other.Dirs
+	r.Bytes += other.Bytes
+	r.FreedBytes += other.FreedBytes
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+type removal_internal struct {
+	r     Removal
+	mutex sync.Mutex
+}
+
+func (r *removal_internal) addAll(local Removal) {
+	r.mutex.Lock()
+	r.r.add(local)
+	r.mutex.Unlock()
+}
+
+// RemoveTree removes the file or directory tree at name entry by entry,
+// descending into up to workers directories concurrently (DefaultRemoveWorkers
+// if workers < 1). It continues past errors; a directory is only removed if
+// everything inside it was. All errors are collected in the result and
+// returned joined.
+//
+// Symlinks are removed themselves, never what they point to. If fsys can
+// open directories, see vfs.DirOpener, the tree is removed relative to the
+// opened directories, so that a directory swapped for a symlink while
+// removing it is not followed either.
+//
+// fsys must implement vfs.Remover. Otherwise the tree is removed with
+// fsys.RemoveAll and nothing but the error is reported.
+func RemoveTree(fsys vfs.FS, name string, workers int) (Removal, error) {
+	remover, ok := fsys.(vfs.Remover)
+	if !ok {
+		err := fsys.RemoveAll(name)
+		if err != nil {
+			return Removal{Errors: []error{err}}, err
+		}
+		return Removal{}, nil
+	}
+	parent, err := openPathDir(fsys, remover, filepath.Dir(name))
+	if err != nil {
+		return Removal{Errors: []error{err}}, err
+	}
+	defer func() {
+		_ = parent.Close()
+	}()
+	return removeTreeAt(parent, filepath.Base(name), workers)
+}
+
+// removeIn removes the tree at name, an entry of the opened directory dir,
+// relative to it, see RemoveTree. If dir is nil, as fsys cannot open
+// directories, it is removed by path.
+func removeIn(fsys vfs.FS, dir vfs.Dir, name string, workers int) (Removal, error) {
+	if dir == nil {
+		return RemoveTree(fsys, name, workers)
+	}
+	return removeTreeAt(dir, filepath.Base(name), workers)
+}
+
+// removeTreeAt removes the tree at the entry name of parent, see RemoveTree.
+func removeTreeAt(parent vfs.Dir, name string, workers int) (Removal, error) {
+	if workers < 1 {
+		workers = DefaultRemoveWorkers
+	}
+	info, err := parent.Lstat(name)
+	if err != nil {
+		return Removal{Errors: []error{err}}, err
+	}
+	result := removal_internal{}
+	if info.IsDir() {
+		removeDirectory(parent, name, &result, stats.NewSemaphore(workers))
+	} else {
+		var local Removal
+		removeFile(parent, name, info.Mode().IsRegular(), &local)
+		result.addAll(local)
+	}
+	return result.r, errors.Join(result.r.Errors...)
+}
+
+func removeFile(dir vfs.Dir, name string, regular bool, local *Removal) {
+	var size, links uint64
+	if regular {
+		var err error
+		if size, links, err = dir.SizeAndLinkCount(name); err != nil {
+			local.Errors = append(local.Errors, err)
+			return
+		}
+	}
+	if err := dir.Remove(name); err != nil {
+		local.Errors = append(local.Errors, err)
+		return
+	}
+	local.Files++
+	local.Bytes += size
+	if regular && links <= 1 {
+		local.FreedBytes += size
+	}
+}
+
+// removeDirectory removes the tree at the entry name of parent and reports
+// whether it succeeded completely. Subdirectories are handled in new
+// goroutines as long as the semaphore has free slots, and in the current
+// goroutine otherwise, so that deep trees cannot exhaust the slots and
+// dead-lock.
+func removeDirectory(parent vfs.Dir, name string, global *removal_internal, semaphore stats.Semaphore) bool {
+	var local Removal
+	defer func() { global.addAll(local) }() // this is synchronized
+
+	dir, err := parent.OpenDir(name)
+	if err != nil {
+		local.Errors = append(local.Errors, err)
+		return false
+	}
+	entries, err := dir.ReadDir()
+	if err != nil {
+		_ = dir.Close()
+		local.Errors = append(local.Errors, err)
+		return false
+	}
+
+	var wg sync.WaitGroup
+	var incomplete atomic.Bool
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			removeFile(dir, entry.Name(), entry.Type().IsRegular(), &local)
+			continue
+		}
+		if semaphore.TryAcquire() {
+			wg.Add(1)
+			go func(subdir string) {
+				defer semaphore.Release()
+				defer wg.Done()
+				if !removeDirectory(dir, subdir, global, semaphore) {
+					incomplete.Store(true)
+				}
+			}(entry.Name())
+		} else if !removeDirectory(dir, entry.Name(), global, semaphore) {
+			incomplete.Store(true)
+		}
+	}
+	wg.Wait() // wait for all child directories to complete
+	_ = dir.Close()
+
+	if len(local.Errors) > 0 || incomplete.Load() {
+		return false
+	}
+	if err := parent.Remove(name); err != nil {
+		local.Errors = append(local.Errors, err)
+		return false
+	}
+	local.Dirs++
+	return true
+}
@@ -0,0 +1,130 @@
+package retention
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"prune_backups/internal/s3test"
+	"prune_backups/vfs"
+	"prune_backups/vfs/s3fs"
+)
+
+func newMemTree(t *testing.T) *vfs.MemFS {
+	m := newMemBackup(t, "snap/a/b/c", "snap/d", "other")
+	for _, file := range []string{"snap/f1", "snap/a/f2", "snap/a/b/c/f3", "snap/d/f4"} {
+		if err := m.CreateFile(filepath.Join("/backup", file), 100, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// f4 is hard-linked from outside the tree, removing it frees no space
+	if err := m.Link("/backup/snap/d/f4", "/backup/other/f4"); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRemoveTree(t *testing.T) {
+	for _, workers := range []int{0, 1, 2} {
+		m := newMemTree(t)
+
+		removal, err := RemoveTree(m, "/backup/snap", workers)
+		if err != nil {
+			t.Fatalf("workers=%d: unexpected error: %v", workers, err)
+		}
+		want := Removal{Files: 4, Dirs: 5, Bytes: 400, FreedBytes: 300}
+		if removal.Files != want.Files || removal.Dirs != want.Dirs || removal.Bytes != want.Bytes || removal.FreedBytes != want.FreedBytes || len(removal.Errors) != 0 {
+			t.Errorf("workers=%d: RemoveTree() = %+v, want %+v", workers, removal, want)
+		}
+		if _, err := m.Stat("/backup/snap"); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("workers=%d: expected the tree to be removed, got %v", workers, err)
+		}
+		if _, links, _ := m.SizeAndLinkCount("/backup/other/f4"); links != 1 {
+			t.Errorf("workers=%d: expected the link outside the tree to remain", workers)
+		}
+	}
+}
+
+func TestRemoveTree_ContinuesPastErrors(t *testing.T) {
+	m := newMemTree(t)
+	m.Fault = func(op, name string) error {
+		if op == "remove" && (filepath.Base(name) == "f3" || filepath.Base(name) == "f4") {
+			return &os.PathError{Op: "unlinkat", Path: name, Err: syscall.EACCES}
+		}
+		return nil
+	}
+
+	removal, err := RemoveTree(m, "/backup/snap", 2)
+	if err == nil || len(removal.Errors) != 2 {
+		t.Fatalf("expected two errors, got %v, %+v", err, removal)
+	}
+	if !errors.Is(err, syscall.EACCES) || !strings.Contains(err.Error(), "f3") || !strings.Contains(err.Error(), "f4") {
+		t.Errorf("expected the error to list both files, got %v", err)
+	}
+	if removal.Files != 2 || removal.Bytes != 200 {
+		t.Errorf("expected the other files to be removed, got %+v", removal)
+	}
+	// the directories containing f3 and f4 and their parents must stay
+	for _, dir := range []string{"/backup/snap", "/backup/snap/a/b/c", "/backup/snap/d"} {
+		if _, err := m.Stat(dir); err != nil {
+			t.Errorf("expected %s to stay: %v", dir, err)
+		}
+	}
+	if _, err := m.Stat("/backup/snap/a/f2"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected f2 to be removed, got %v", err)
+	}
+}
+
+func TestRemoveTree_OS(t *testing.T) {
+	dir := t.TempDir()
+	makeDirs(t, dir, "snap/a", "keep")
+	if err := os.WriteFile(filepath.Join(dir, "snap", "a", "file"), []byte("12345"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "keep", "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "keep"), filepath.Join(dir, "snap", "link")); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+
+	removal, err := RemoveTree(vfs.OS{}, filepath.Join(dir, "snap"), 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removal.Files != 2 || removal.Dirs != 2 || removal.Bytes != 5 {
+		t.Errorf("unexpected removal %+v", removal)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep", "file")); err != nil {
+		t.Errorf("expected the symlink target to stay: %v", err)
+	}
+}
+
+func TestRemoveTree_WithoutRemover(t *testing.T) {
+	client := s3test.NewMemClient()
+	client.Put("b", "snap/file", 10)
+
+	removal, err := RemoveTree(s3fs.New(client, "b"), "snap", 0)
+	if err != nil || removal.Files != 0 {
+		t.Errorf("RemoveTree() = %+v, %v", removal, err)
+	}
+	if len(client.Keys("b")) != 0 {
+		t.Errorf("expected all objects to be removed, got %v", client.Keys("b"))
+	}
+}
+
+func TestApply_DeleteReportsRemoval(t *testing.T) {
+	m := newMemTree(t)
+	result, err := Apply(Decisions{Prune: []string{"snap", "other"}}, ApplyOptions{FS: m, Dir: "/backup", Delete: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	total := result.Removed()
+	if total.Files != 5 || total.Dirs != 6 || total.Bytes != 500 || total.FreedBytes != 400 {
+		t.Errorf("Removed() = %+v", total)
+	}
+}
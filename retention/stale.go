@@ -0,0 +1,59 @@
+package retention
+
+import (
+	"fmt"
+	"time"
+)
+
+// StaleError is returned by CheckFresh if the newest snapshot is older than
+// expected, e.g. because backups stopped being made.
+type StaleError struct {
+	Newest string        // the newest snapshot; empty if there is none
+	Age    time.Duration // how long ago Newest was made
+	MaxAge time.Duration
+}
+
+func (e *StaleError) Error() string {
+	if e.Newest == "" {
+		return fmt.Sprintf("there is no snapshot, expected one made within the last %s", e.MaxAge)
+	}
+	return fmt.Sprintf("the newest snapshot %s was made %s ago, expected one made within the last %s", e.Newest, e.Age.Round(time.Minute), e.MaxAge)
+}
+
+// Newest returns the snapshot among names with the latest date and that
+// date in loc. Names without a date are left out; ok is false if there is
+// none with a date.
+func Newest(names []string, loc *time.Location) (name string, date time.Time, ok bool) {
+	var newest string
+	for _, n := range names {
+		i := dateInName.FindStringIndex(n)
+		if i == nil {
+			continue
+		}
+		if stamp := n[i[0]:]; !ok || stamp > newest {
+			name, newest, ok = n, stamp, true
+		}
+	}
+	if !ok {
+		return "", time.Time{}, false
+	}
+	date, err := time.ParseInLocation("2006-01-02_15-04", newest[:min(len(newest), len("2006-01-02_15-04"))], loc)
+	if err != nil {
+		// only the day is known
+		date, _ = time.ParseInLocation("2006-01-02", newest[:len("2006-01-02")], loc)
+	}
+	return name, date, true
+}
+
+// CheckFresh returns a *StaleError if the newest snapshot among names was
+// made more than maxAge before now.
+func CheckFresh(names []string, now time.Time, maxAge time.Duration) error {
+	name, date, ok := Newest(names, now.Location())
+	if !ok {
+		return &StaleError{MaxAge: maxAge}
+	}
+	if age := now.Sub(date); age > maxAge {
+		return &StaleError{Newest: name, Age: age, MaxAge: maxAge}
+	}
+	return nil
+}
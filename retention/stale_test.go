@@ -0,0 +1,40 @@
+package retention
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewest(t *testing.T) {
+	name, date, ok := Newest([]string{"db-2024-06-16_23-49.sql.zst", "notes.txt", "db-2024-06-17_09-15.sql.zst", "db-2024-06-17.sql.zst"}, time.UTC)
+	if !ok || name != "db-2024-06-17_09-15.sql.zst" || !date.Equal(time.Date(2024, 6, 17, 9, 15, 0, 0, time.UTC)) {
+		t.Errorf("Newest() = %s, %v, %v", name, date, ok)
+	}
+	name, date, ok = Newest([]string{"2024-06-16", "2024-06-15_23-49"}, time.UTC)
+	if !ok || name != "2024-06-16" || !date.Equal(time.Date(2024, 6, 16, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Newest() = %s, %v, %v", name, date, ok)
+	}
+	if _, _, ok := Newest([]string{"notes.txt"}, time.UTC); ok {
+		t.Errorf("expected no snapshot without a date")
+	}
+}
+
+func TestCheckFresh(t *testing.T) {
+	now := time.Date(2024, 6, 17, 12, 0, 0, 0, time.UTC)
+	names := []string{"2024-06-17_09-15", "2024-06-17_10-30"}
+	if err := CheckFresh(names, now, 2*time.Hour); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err := CheckFresh(names, now, time.Hour)
+	var stale *StaleError
+	if !errors.As(err, &stale) || stale.Newest != "2024-06-17_10-30" || stale.Age != 90*time.Minute {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err.Error() != "the newest snapshot 2024-06-17_10-30 was made 1h30m0s ago, expected one made within the last 1h0m0s" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+	if err := CheckFresh(nil, now, time.Hour); !errors.As(err, &stale) || stale.Newest != "" {
+		t.Errorf("expected an empty directory to be stale, got %v", err)
+	}
+}
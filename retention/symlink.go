@@ -0,0 +1,96 @@
+package retention
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"prune_backups/vfs"
+)
+
+// Symlinks finds the symlinks in dir that point to a snapshot in dir or into
+// it, e.g. latest -> 2024-06-17_09-49 as used for rsync --link-dest, and maps
+// the names of the snapshots to the names of the symlinks. Relative and
+// absolute targets are resolved, as are symlinks pointing to other symlinks
+// and symlinks within the targets and dir itself, see filepath.EvalSymlinks.
+// Dangling symlinks, loops and symlinks pointing elsewhere are ignored.
+// Filesystems without symlinks, see vfs.LinkReader, have none.
+func Symlinks(fsys vfs.FS, dir string) (map[string][]string, error) {
+	fsys = vfs.OrOS(fsys)
+	referenced := make(map[string][]string)
+	reader, ok := fsys.(vfs.LinkReader)
+	if !ok {
+		return referenced, nil
+	}
+	root, err := resolve(dir)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	symlinks := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeSymlink != 0 {
+			symlinks[entry.Name()] = true
+		}
+	}
+	for link := range symlinks {
+		target, err := filepath.EvalSymlinks(filepath.Join(root, link))
+		var pathErr *fs.PathError
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// e.g. a subdirectory of a snapshot that is not there (yet)
+			if target, err = reader.Readlink(filepath.Join(root, link)); err != nil {
+				return nil, err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(root, target)
+			}
+		case errors.As(err, &pathErr):
+			return nil, err
+		case err != nil:
+			continue // a loop
+		}
+		name := topLevel(root, filepath.Clean(target))
+		if name == "" || symlinks[name] {
+			continue // outside of dir, or dangling
+		}
+		if _, err := fsys.Stat(filepath.Join(root, name)); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		referenced[name] = append(referenced[name], link)
+	}
+	for _, links := range referenced {
+		sort.Strings(links)
+	}
+	return referenced, nil
+}
+
+// resolve returns the absolute path of name with all symlinks followed, or
+// just the absolute path if name does not exist.
+func resolve(name string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return filepath.Abs(name)
+	}
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// topLevel returns the entry of root that path is or is in, or "" if path is
+// not in root.
+func topLevel(root, path string) string {
+	rel, inside := within(root, path)
+	if !inside {
+		return ""
+	}
+	return strings.Split(rel, string(filepath.Separator))[0]
+}
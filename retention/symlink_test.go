@@ -0,0 +1,109 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+func TestPolicy_PlanProtected(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+	given := []string{"2024-06-16_03-49", "2024-06-16_02-49", "2024-06-16_01-49", "latest"}
+
+	got := Policy{Monthlies: 119, Protected: IsListed([]string{"2024-06-16_01-49", "2024-06-16_03-49"})}.Plan(now, given)
+
+	// the winner of the slot is kept anyway, so only 01-49 counts as protected
+	if !reflect.DeepEqual(got.Keep, []string{"2024-06-16_03-49", "2024-06-16_01-49"}) || !reflect.DeepEqual(got.Prune, []string{"2024-06-16_02-49"}) {
+		t.Errorf("Plan() = keep %v, prune %v", got.Keep, got.Prune)
+	}
+	if !reflect.DeepEqual(got.Protected, []string{"2024-06-16_01-49"}) {
+		t.Errorf("Plan().Protected = %v", got.Protected)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	elsewhere := t.TempDir()
+	for _, name := range []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-16_23-49"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"latest":   "2024-06-17_09-15",                                   // relative
+		"current":  "latest",                                             // a chain
+		"absolute": filepath.Join(dir, "2024-06-16_23-49"),               // absolute
+		"nested":   "./2024-06-17_09-15/../2024-06-17_09-15/some/subdir", // into a snapshot
+		"dangling": "2024-06-01_00-00",
+		"outside":  elsewhere,
+		"loop":     "loop",
+		"root":     ".",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	got, err := Symlinks(vfs.OS{}, dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string][]string{
+		"2024-06-17_09-15": {"current", "latest", "nested"},
+		"2024-06-16_23-49": {"absolute"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Symlinks() = %v, want %v", got, want)
+	}
+}
+
+// TestSymlinks_Chained follows symlinks outside of dir and a symlinked dir,
+// which a lexical resolution would take for targets outside of dir.
+func TestSymlinks_Chained(t *testing.T) {
+	dir := t.TempDir()
+	elsewhere := t.TempDir()
+	for _, name := range []string{"2024-06-17_09-49", "2024-06-17_09-15"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	alias := filepath.Join(elsewhere, "alias")
+	links := map[string]string{
+		alias:                           dir,
+		filepath.Join(elsewhere, "hop"): filepath.Join(dir, "2024-06-17_09-49"),
+		filepath.Join(dir, "latest"):    filepath.Join(elsewhere, "hop"),              // a chain leaving dir
+		filepath.Join(dir, "previous"):  filepath.Join(alias, "2024-06-17_09-15"),     // through a symlink to dir
+		filepath.Join(dir, "escape"):    filepath.Join(alias, "..", "hop", "..", "x"), // .. after a symlink
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	want := map[string][]string{
+		"2024-06-17_09-49": {"latest"},
+		"2024-06-17_09-15": {"previous"},
+	}
+	for _, d := range []string{dir, alias} {
+		got, err := Symlinks(vfs.OS{}, d)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Symlinks(%s) = %v, want %v", d, got, want)
+		}
+	}
+}
+
+func TestSymlinks_NoLinkReader(t *testing.T) {
+	m := newMemBackup(t, "2024-06-17_09-49")
+	if got, err := Symlinks(m, "/backup"); err != nil || len(got) != 0 {
+		t.Errorf("Symlinks() = %v, %v", got, err)
+	}
+}
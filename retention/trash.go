@@ -0,0 +1,191 @@
+package retention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+// TrashIndexName is the name of the file in a trash directory that records
+// when each entry was moved there.
+const TrashIndexName = ".prune_backups_trash.json"
+
+// TrashIndex maps the names of the entries of a trash directory to the time
+// they were moved there.
+type TrashIndex map[string]time.Time
+
+// ReadTrashIndex reads the index of the trash directory. A missing index is
+// returned as an empty index.
+func ReadTrashIndex(fsys vfs.FS, trash string) (TrashIndex, error) {
+	index := TrashIndex{}
+	data, err := vfs.OrOS(fsys).ReadFile(filepath.Join(trash, TrashIndexName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("invalid trash index %s: %w", filepath.Join(trash, TrashIndexName), err)
+	}
+	return index, nil
+}
+
+// WriteTrashIndex replaces the index of the trash directory.
+func WriteTrashIndex(fsys vfs.FS, trash string, index TrashIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return vfs.OrOS(fsys).WriteFile(filepath.Join(trash, TrashIndexName), append(data, '\n'), 0644)
+}
+
+// recordMoves adds the entries moved to the trash directory to its index. If
+// they were moved to the subdirectory run, only run is recorded, so that the
+// run is purged as a whole.
+func recordMoves(fsys vfs.FS, trash, run string, moved []Rename, now time.Time) error {
+	if len(moved) == 0 {
+		return nil
+	}
+	index, err := ReadTrashIndex(fsys, trash)
+	if err != nil {
+		return err
+	}
+	if run != "" {
+		index[run] = now
+		return WriteTrashIndex(fsys, trash, index)
+	}
+	for _, r := range moved {
+		index[filepath.Base(r.To)] = now
+	}
+	return WriteTrashIndex(fsys, trash, index)
+}
+
+// PurgeOptions controls which entries Purge removes from a trash directory.
+type PurgeOptions struct {
+	FS       vfs.FS     // the filesystem to work on; nil means the operating system's filesystem
+	Trash    string     // the trash directory
+	Before   time.Time  // entries moved to the trash before this time are removed
+	Now      time.Time  // recorded for entries missing in the index; time.Now() if zero
+	Workers  int        // the number of directories removed concurrently, see RemoveTree
+	Progress func(Move) // optional, called after each attempted removal
+}
+
+// PurgeResult lists the removals attempted by Purge.
+type PurgeResult struct {
+	Result
+	Quarantined []string // entries kept, as they were moved to the trash after opts.Before
+	Recorded    []string // entries missing in the index, recorded with opts.Now and kept
+}
+
+// Purge removes all entries of the trash directory that were moved there
+// before opts.Before according to its index. Entries missing in the index,
+// e.g. moved by older versions or by hand, are recorded with opts.Now, so
+// that their quarantine starts now. A missing trash directory is not an
+// error, but a symlink in its place is refused with an error wrapping
+// vfs.ErrSymlink. The entries are read and removed relative to the opened
+// trash directory if fsys supports it, see vfs.DirOpener. All entries are
+// attempted, even if some of them fail.
+func Purge(opts PurgeOptions) (PurgeResult, error) {
+	fsys := vfs.OrOS(opts.FS)
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var result PurgeResult
+
+	if lstater, ok := fsys.(vfs.Lstater); ok {
+		info, err := lstater.Lstat(opts.Trash)
+		if errors.Is(err, fs.ErrNotExist) {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return result, &fs.PathError{Op: "purge", Path: opts.Trash, Err: vfs.ErrSymlink}
+		}
+	}
+	var trash vfs.Dir // nil if fsys cannot remove relative to a directory
+	var err error
+	if remover, ok := fsys.(vfs.Remover); ok {
+		trash, err = openPathDir(fsys, remover, opts.Trash)
+		if errors.Is(err, fs.ErrNotExist) {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		defer func() {
+			_ = trash.Close()
+		}()
+	}
+	var entries []fs.DirEntry
+	if trash != nil {
+		entries, err = trash.ReadDir()
+	} else {
+		entries, err = fsys.ReadDir(opts.Trash)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	index, err := ReadTrashIndex(fsys, opts.Trash)
+	if err != nil {
+		return result, err
+	}
+
+	present := make(map[string]bool, len(entries))
+	var expired []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == TrashIndexName || name == JournalName {
+			continue
+		}
+		present[name] = true
+		moved, ok := index[name]
+		switch {
+		case !ok:
+			index[name] = now
+			result.Recorded = append(result.Recorded, name)
+		case moved.Before(opts.Before):
+			expired = append(expired, name)
+		default:
+			result.Quarantined = append(result.Quarantined, name)
+		}
+	}
+	for name := range index {
+		if !present[name] {
+			delete(index, name) // removed by someone else
+		}
+	}
+	sort.Strings(expired)
+
+	for _, name := range expired {
+		move := Move{Name: name, From: filepath.Join(opts.Trash, name)}
+		move.Removed, move.Err = removeIn(fsys, trash, move.From, opts.Workers)
+		if move.Err == nil {
+			delete(index, name)
+		}
+		result.Moves = append(result.Moves, move)
+		if opts.Progress != nil {
+			opts.Progress(move)
+		}
+	}
+
+	if err := WriteTrashIndex(fsys, opts.Trash, index); err != nil {
+		return result, fmt.Errorf("could not update trash index: %w", err)
+	}
+	if failed := result.Failed(); failed > 0 {
+		return result, &PartialError{Failed: failed, Total: len(expired), What: "trash entries could not be purged"}
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package retention
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+func TestApply_RecordsMoves(t *testing.T) {
+	m := newMemBackup(t, "a", "b", "to_delete/old")
+	moved := time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+	if err := WriteTrashIndex(m, "/backup/to_delete", TrashIndex{"old": moved.Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Apply(Decisions{Prune: []string{"a", "b"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete", Now: moved}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	index, err := ReadTrashIndex(m, "/backup/to_delete")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := TrashIndex{"old": moved.Add(-time.Hour), "a": moved, "b": moved}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("index = %v, want %v", index, want)
+	}
+}
+
+func TestApply_TrashIndexError(t *testing.T) {
+	m := newMemBackup(t, "a")
+	m.Fault = func(op, name string) error {
+		if op == "write" && filepath.Base(name) == TrashIndexName {
+			return &os.PathError{Op: "open", Path: name, Err: syscall.ENOSPC}
+		}
+		return nil
+	}
+
+	result, err := Apply(Decisions{Prune: []string{"a"}}, ApplyOptions{FS: m, Dir: "/backup", Trash: "/backup/to_delete"})
+	if !errors.Is(err, syscall.ENOSPC) || result.Moved() != 1 {
+		t.Errorf("expected the index error to be reported after moving, got %v, %+v", err, result)
+	}
+}
+
+func TestReadTrashIndex_Invalid(t *testing.T) {
+	m := newMemBackup(t, "to_delete")
+	if err := m.WriteFile(filepath.Join("/backup/to_delete", TrashIndexName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadTrashIndex(m, "/backup/to_delete"); err == nil {
+		t.Errorf("expected an error for an invalid index")
+	}
+	if _, err := Purge(PurgeOptions{FS: m, Trash: "/backup/to_delete"}); err == nil {
+		t.Errorf("expected Purge to refuse an invalid index")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	now := time.Date(2024, 7, 17, 9, 54, 0, 0, time.UTC)
+	m := newMemBackup(t, "to_delete/expired/sub", "to_delete/quarantined", "to_delete/unknown")
+	if err := m.CreateFile("/backup/to_delete/expired.sha256", 10, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteTrashIndex(m, "/backup/to_delete", TrashIndex{
+		"expired":        now.AddDate(0, 0, -31),
+		"expired.sha256": now.AddDate(0, 0, -31),
+		"quarantined":    now.AddDate(0, 0, -29),
+		"gone":           now.AddDate(0, 0, -40),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var progress []string
+	result, err := Purge(PurgeOptions{
+		FS:       m,
+		Trash:    "/backup/to_delete",
+		Before:   now.AddDate(0, 0, -30),
+		Now:      now,
+		Progress: func(m Move) { progress = append(progress, m.Name) },
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(progress, []string{"expired", "expired.sha256"}) || result.Moved() != 2 {
+		t.Errorf("purged %v, result %+v", progress, result)
+	}
+	if removed := result.Removed(); removed.Dirs != 2 || removed.Files != 1 || removed.Bytes != 10 {
+		t.Errorf("Removed() = %+v", removed)
+	}
+	if !reflect.DeepEqual(result.Quarantined, []string{"quarantined"}) || !reflect.DeepEqual(result.Recorded, []string{"unknown"}) {
+		t.Errorf("Quarantined = %v, Recorded = %v", result.Quarantined, result.Recorded)
+	}
+	if _, err := m.Stat("/backup/to_delete/expired"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected expired entry to be removed, got %v", err)
+	}
+
+	index, err := ReadTrashIndex(m, "/backup/to_delete")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := TrashIndex{"quarantined": now.AddDate(0, 0, -29), "unknown": now}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("index = %v, want %v", index, want)
+	}
+}
+
+func TestPurge_Failures(t *testing.T) {
+	now := time.Date(2024, 7, 17, 9, 54, 0, 0, time.UTC)
+	m := newMemBackup(t, "to_delete/a", "to_delete/b")
+	if err := WriteTrashIndex(m, "/backup/to_delete", TrashIndex{"a": now.AddDate(-1, 0, 0), "b": now.AddDate(-1, 0, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	m.Fault = func(op, name string) error {
+		if op == "remove" && filepath.Base(name) == "a" {
+			return &os.PathError{Op: "unlinkat", Path: name, Err: syscall.EACCES}
+		}
+		return nil
+	}
+
+	result, err := Purge(PurgeOptions{FS: m, Trash: "/backup/to_delete", Before: now})
+	if err == nil || err.Error() != "1 of 2 trash entries could not be purged" || result.Moved() != 1 {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+	index, _ := ReadTrashIndex(m, "/backup/to_delete")
+	if _, ok := index["a"]; !ok || len(index) != 1 {
+		t.Errorf("expected a to stay in the index, got %v", index)
+	}
+}
+
+func TestPurge_NoTrash(t *testing.T) {
+	result, err := Purge(PurgeOptions{FS: vfs.NewMemFS(), Trash: "/backup/to_delete", Before: time.Now()})
+	if err != nil || len(result.Moves) != 0 {
+		t.Errorf("Purge() = %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,32 @@
+// Package sandbox restricts the filesystem access of the running process to
+// a few directory trees, using Landlock on Linux, so that a bug or a
+// malicious snapshot name cannot make prune_backups touch anything else.
+package sandbox
+
+// Access is what the process may do below a Path.
+type Access int
+
+const (
+	// ReadOnly allows listing directories and reading files.
+	ReadOnly Access = iota
+	// ReadWrite allows everything but executing files, including creating,
+	// renaming and removing files and directories.
+	ReadWrite
+)
+
+// Path grants Access to the directory tree Name.
+type Path struct {
+	Name   string
+	Access Access
+}
+
+// Restrict restricts the process, including all its threads and children,
+// to the given paths for the rest of its life. Everything else in the
+// filesystem cannot be read or written anymore, so anything needed later,
+// e.g. the local time zone, must be loaded before. All paths must exist;
+// create a directory before granting it. Restrict returns
+// an error wrapping errors.ErrUnsupported if the operating system or the
+// kernel does not support it; the process is not restricted then.
+func Restrict(paths ...Path) error {
+	return restrict(paths)
+}
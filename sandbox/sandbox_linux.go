@@ -0,0 +1,130 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// landlockTSYNC is LANDLOCK_RESTRICT_SELF_TSYNC, which applies the
+// restriction to all threads of the process (Landlock ABI 8).
+const landlockTSYNC = 0x8
+
+// accessFS returns the filesystem rights known to Landlock ABI abi, see
+// landlock(7).
+func accessFS(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR | unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK | unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		access |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	return access
+}
+
+func restrict(paths []Path) error {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno == unix.ENOSYS || errno == unix.EOPNOTSUPP {
+		return fmt.Errorf("Landlock is not enabled in this kernel: %w", errors.ErrUnsupported)
+	}
+	if errno != 0 {
+		return fmt.Errorf("could not query Landlock: %w", errno)
+	}
+	handled := accessFS(int(abi))
+	for _, p := range paths {
+		if p.Access == ReadWrite && abi < 2 {
+			// without LANDLOCK_ACCESS_FS_REFER, nothing can be moved to another directory
+			return fmt.Errorf("Landlock of this kernel cannot allow moves between directories (ABI %d, 2 needed): %w", abi, errors.ErrUnsupported)
+		}
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr.Access_fs), 0)
+	if errno != 0 {
+		return fmt.Errorf("could not create a Landlock ruleset: %w", errno)
+	}
+	ruleset := int(fd)
+	defer func() {
+		_ = unix.Close(ruleset)
+	}()
+	for _, p := range paths {
+		if err := addRule(ruleset, p, handled); err != nil {
+			return err
+		}
+	}
+
+	if abi >= 8 {
+		// all threads at once, from the thread that sets no_new_privs
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("could not set no_new_privs: %w", err)
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, landlockTSYNC, 0); errno != 0 {
+			return fmt.Errorf("could not restrict the process: %w", errno)
+		}
+		return nil
+	}
+	// Landlock restricts the calling thread only, so every thread of the Go
+	// runtime must call it, which needs a build without cgo
+	if _, _, errno := syscall.AllThreadsSyscall6(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0, 0); errno != 0 {
+		if errno == unix.ENOTSUP {
+			return fmt.Errorf("Landlock of this kernel cannot restrict all threads of a program built with cgo; build it with CGO_ENABLED=0: %w", errors.ErrUnsupported)
+		}
+		return fmt.Errorf("could not set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("could not restrict the process: %w", errno)
+	}
+	return nil
+}
+
+// addRule allows access to p, which must exist. Granting an existing
+// ancestor instead would allow far more than p, e.g. all of / for a missing
+// /nonexistent/trash.
+func addRule(ruleset int, p Path, handled uint64) error {
+	name, err := filepath.Abs(p.Name)
+	if err != nil {
+		return err
+	}
+	fd, err := unix.Open(name, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	access := uint64(unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR)
+	if p.Access == ReadWrite {
+		access = handled &^ unix.LANDLOCK_ACCESS_FS_EXECUTE
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		// only rights on files are allowed for files
+		access &= unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	rule := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&rule)), 0, 0, 0); errno != 0 {
+		return &fs.PathError{Op: "landlock_add_rule", Path: name, Err: errno}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package sandbox
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRestrict restricts a child process running this test again, as the
+// restriction cannot be undone.
+func TestRestrict(t *testing.T) {
+	if dir := os.Getenv("SANDBOX_TEST_DIR"); dir != "" {
+		restricted(t, dir)
+		return
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "rw", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"rw", "ro", "outside"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, "file"), []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRestrict$", "-test.v")
+	cmd.Env = append(os.Environ(), "SANDBOX_TEST_DIR="+dir)
+	output, err := cmd.CombinedOutput()
+	if strings.Contains(string(output), "--- SKIP") {
+		t.Skip(string(output))
+	}
+	if err != nil {
+		t.Errorf("restricted process failed: %v\n%s", err, output)
+	}
+}
+
+func restricted(t *testing.T, dir string) {
+	rw, ro := filepath.Join(dir, "rw"), filepath.Join(dir, "ro")
+	err := Restrict(Path{Name: rw, Access: ReadWrite}, Path{Name: ro, Access: ReadOnly})
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.Rename(filepath.Join(rw, "file"), filepath.Join(rw, "sub", "moved")); err != nil {
+		t.Errorf("expected a move within %s to be allowed: %v", rw, err)
+	}
+	if _, err := os.ReadFile(filepath.Join(ro, "file")); err != nil {
+		t.Errorf("expected reading %s to be allowed: %v", ro, err)
+	}
+	if err := os.WriteFile(filepath.Join(ro, "new"), nil, 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected writing to %s to be denied, got %v", ro, err)
+	}
+	if _, err := os.ReadFile(filepath.Join(dir, "outside", "file")); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected reading outside to be denied, got %v", err)
+	}
+	if err := os.Rename(filepath.Join(rw, "sub"), filepath.Join(dir, "outside", "sub")); err == nil {
+		t.Errorf("expected a move out of %s to be denied", rw)
+	}
+}
+
+// TestRestrict_Missing checks that a missing path is refused instead of
+// being granted through an existing ancestor like /. The process is not
+// restricted if Restrict fails.
+func TestRestrict_Missing(t *testing.T) {
+	err := Restrict(Path{Name: t.TempDir(), Access: ReadWrite}, Path{Name: "/nonexistent/a/b", Access: ReadWrite})
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip(err)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected %v, got %v", fs.ErrNotExist, err)
+	}
+	if _, err := os.ReadDir("/"); err != nil {
+		t.Errorf("expected / to be readable after the failed restriction: %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func restrict([]Path) error {
+	return fmt.Errorf("Landlock is not available on this operating system (%s): %w", runtime.GOOS, errors.ErrUnsupported)
+}
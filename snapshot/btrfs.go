@@ -0,0 +1,79 @@
+package snapshot
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Btrfs manages snapshots kept as btrfs subvolumes in a directory, e.g.
+// /mnt/snapshots/2024-06-17_09-49.
+type Btrfs struct {
+	Runner Runner // nil means ExecRunner
+	Dir    string // the directory containing the subvolumes
+}
+
+func (b Btrfs) runner() Runner {
+	if b.Runner == nil {
+		return ExecRunner{}
+	}
+	return b.Runner
+}
+
+// List returns the names of the subvolumes directly inside Dir. Subvolumes
+// nested inside these, and subvolumes elsewhere in the filesystem, are
+// ignored.
+func (b Btrfs) List() ([]string, error) {
+	out, err := b.runner().Run("btrfs", "subvolume", "list", "-o", b.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// Lines look like "ID 258 gen 12 top level 5 path snapshots/2024-06-17_09-49",
+	// the path being relative to the top level subvolume of the filesystem
+	// rather than to Dir. As the output also has subvolumes outside Dir, e.g.
+	// those in sibling directories, each one is kept only if Dir/<base name>
+	// is the root of that very subvolume.
+	names := []string{}
+	for _, line := range splitLines(out) {
+		head, p, found := strings.Cut(line, " path ")
+		fields := strings.Fields(head)
+		if !found || len(fields) < 2 || fields[0] != "ID" {
+			return nil, fmt.Errorf("unexpected output of btrfs subvolume list: %q", line)
+		}
+		id, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected output of btrfs subvolume list: %q", line)
+		}
+		name := path.Base(p)
+		// rootid fails if Dir/name does not exist, and reports the
+		// subvolume containing it if it is not a subvolume itself
+		if root, err := b.rootID(b.Path(name)); err == nil && root == id {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// rootID returns the ID of the subvolume containing the file name.
+func (b Btrfs) rootID(name string) (uint64, error) {
+	out, err := b.runner().Run("btrfs", "inspect-internal", "rootid", name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// Destroy deletes the subvolume Dir/name.
+func (b Btrfs) Destroy(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	_, err := b.runner().Run("btrfs", "subvolume", "delete", b.Path(name))
+	return err
+}
+
+func (b Btrfs) Path(name string) string {
+	return filepath.Join(b.Dir, name)
+}
@@ -0,0 +1,84 @@
+// Package snapshot prunes filesystem snapshots, e.g. ZFS snapshots or btrfs
+// subvolumes, with the retention policy of package retention. Snapshots are
+// managed by a Driver, which in turn invokes the filesystem's command line
+// tools through a Runner so that the commands can be faked in tests.
+package snapshot
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"prune_backups/retention"
+)
+
+// Runner runs an external command and returns its standard output.
+type Runner interface {
+	Run(name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs commands using os/exec.
+type ExecRunner struct{}
+
+// Run runs the command. If it fails, its standard error output is included
+// in the error.
+func (ExecRunner) Run(name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return out, fmt.Errorf("%s %s: %w", name, strings.Join(args, " "), err)
+		}
+		return out, fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, msg)
+	}
+	return out, nil
+}
+
+// Driver lists and destroys the snapshots of a single dataset or directory.
+// Names are the short snapshot names, e.g. 2024-06-17_09-49, which are
+// subject to the retention policy.
+type Driver interface {
+	List() ([]string, error)
+	Destroy(name string) error
+	// Path returns the full name of the snapshot name, as understood by the
+	// filesystem's tools.
+	Path(name string) string
+}
+
+// Prune destroys all snapshots in d.Prune. All snapshots are attempted, even
+// if some of them fail; an error summarizing the failures is returned in that
+// case. progress is optional and called after each attempt. The moves in the
+// result have an empty To, as snapshots cannot be moved to a trash.
+func Prune(driver Driver, d retention.Decisions, progress func(retention.Move)) (retention.Result, error) {
+	var result retention.Result
+	for _, name := range d.Prune {
+		move := retention.Move{
+			Name: name,
+			From: driver.Path(name),
+			Err:  driver.Destroy(name),
+		}
+		result.Moves = append(result.Moves, move)
+		if progress != nil {
+			progress(move)
+		}
+	}
+	if failed := result.Failed(); failed > 0 {
+		return result, &retention.PartialError{Failed: failed, Total: len(d.Prune), What: "snapshots could not be destroyed"}
+	}
+	return result, nil
+}
+
+// splitLines returns the non-empty lines of out.
+func splitLines(out []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
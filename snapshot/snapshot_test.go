@@ -0,0 +1,174 @@
+package snapshot
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"prune_backups/retention"
+)
+
+// fakeRunner records all commands and answers them from outputs, keyed by
+// the command line.
+type fakeRunner struct {
+	outputs  map[string]string
+	failures map[string]error
+	commands []string
+}
+
+func (r *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := strings.Join(append([]string{name}, args...), " ")
+	r.commands = append(r.commands, cmd)
+	if err := r.failures[cmd]; err != nil {
+		return nil, err
+	}
+	return []byte(r.outputs[cmd]), nil
+}
+
+var testTime = time.Date(2024, 6, 17, 9, 54, 0, 0, time.UTC)
+
+func TestZFS(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"zfs list -H -t snapshot -o name -d 1 pool/data": "pool/data@2024-05-31_23-49\n" +
+			"pool/data@2024-05-31_22-49\n" +
+			"pool/data@manual-before-upgrade\n" +
+			"pool/data@2024-06-17_09-49\n" +
+			"pool/data@2024-06-17_09-15\n",
+	}}
+	driver := ZFS{Runner: runner, Dataset: "pool/data"}
+
+	names, err := driver.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"2024-05-31_23-49", "2024-05-31_22-49", "manual-before-upgrade", "2024-06-17_09-49", "2024-06-17_09-15"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %v, want %v", names, want)
+	}
+
+	decisions := retention.DefaultPolicy().Plan(testTime, names)
+	result, err := Prune(driver, decisions, nil)
+	if err != nil || result.Moved() != 2 {
+		t.Fatalf("Prune() = %+v, %v", result, err)
+	}
+	wantCommands := []string{
+		"zfs list -H -t snapshot -o name -d 1 pool/data",
+		"zfs destroy pool/data@2024-06-17_09-15",
+		"zfs destroy pool/data@2024-05-31_22-49",
+	}
+	if !reflect.DeepEqual(runner.commands, wantCommands) {
+		t.Errorf("commands = %q, want %q", runner.commands, wantCommands)
+	}
+}
+
+func TestZFS_InvalidOutput(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"zfs list -H -t snapshot -o name -d 1 pool/data": "pool/data\n",
+	}}
+	if _, err := (ZFS{Runner: runner, Dataset: "pool/data"}).List(); err == nil {
+		t.Errorf("expected an error for output without snapshot name")
+	}
+	if err := (ZFS{Runner: runner, Dataset: "pool/data"}).Destroy("x@y"); err == nil {
+		t.Errorf("expected an error for an invalid snapshot name")
+	}
+}
+
+func TestBtrfs(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"btrfs subvolume list -o /mnt/snapshots": "ID 258 gen 12 top level 5 path snapshots/2024-06-17_09-49\n" +
+			"ID 259 gen 13 top level 5 path snapshots/2024-06-17_09-15\n" +
+			"ID 260 gen 14 top level 259 path snapshots/2024-06-17_09-15/var/lib/machines\n" +
+			"ID 261 gen 15 top level 5 path snapshots/2024-06-16_23-49\n",
+		"btrfs inspect-internal rootid /mnt/snapshots/2024-06-17_09-49": "258\n",
+		"btrfs inspect-internal rootid /mnt/snapshots/2024-06-17_09-15": "259\n",
+		"btrfs inspect-internal rootid /mnt/snapshots/2024-06-16_23-49": "261\n",
+	}, failures: map[string]error{
+		"btrfs inspect-internal rootid /mnt/snapshots/machines": errors.New("No such file or directory"),
+	}}
+	driver := Btrfs{Runner: runner, Dir: "/mnt/snapshots"}
+
+	names, err := driver.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"2024-06-17_09-49", "2024-06-17_09-15", "2024-06-16_23-49"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %v, want %v", names, want)
+	}
+
+	decisions := retention.DefaultPolicy().Plan(testTime, names)
+	if _, err := Prune(driver, decisions, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := runner.commands[len(runner.commands)-1]; got != "btrfs subvolume delete /mnt/snapshots/2024-06-17_09-15" {
+		t.Errorf("unexpected command %q", got)
+	}
+}
+
+func TestBtrfs_Siblings(t *testing.T) {
+	// Dir is a plain directory nested in the subvolume @, which also has the
+	// snapshots of another host in a sibling directory, and a subvolume
+	// closer to its root
+	runner := &fakeRunner{outputs: map[string]string{
+		"btrfs subvolume list -o /mnt/@/backups/host1": "ID 256 gen 10 top level 5 path @\n" +
+			"ID 300 gen 20 top level 256 path @/var\n" +
+			"ID 301 gen 21 top level 256 path @/backups/host2/2024-06-17_09-49\n" +
+			"ID 302 gen 22 top level 256 path @/backups/host2/2024-06-17_08-49\n" +
+			"ID 303 gen 23 top level 256 path @/backups/host1/2024-06-17_09-49\n" +
+			"ID 304 gen 24 top level 256 path @/backups/host1/2024-06-16_23-49\n",
+		"btrfs inspect-internal rootid /mnt/@/backups/host1/2024-06-17_09-49": "303\n",
+		"btrfs inspect-internal rootid /mnt/@/backups/host1/2024-06-16_23-49": "304\n",
+		// a plain directory in a snapshot, so it's in the subvolume @
+		"btrfs inspect-internal rootid /mnt/@/backups/host1/var": "256\n",
+	}, failures: map[string]error{
+		"btrfs inspect-internal rootid /mnt/@/backups/host1/@":                errors.New("No such file or directory"),
+		"btrfs inspect-internal rootid /mnt/@/backups/host1/2024-06-17_08-49": errors.New("No such file or directory"),
+	}}
+	driver := Btrfs{Runner: runner, Dir: "/mnt/@/backups/host1"}
+
+	names, err := driver.List()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"2024-06-17_09-49", "2024-06-16_23-49"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %v, want %v", names, want)
+	}
+}
+
+func TestBtrfs_InvalidOutput(t *testing.T) {
+	runner := &fakeRunner{outputs: map[string]string{
+		"btrfs subvolume list -o /mnt/snapshots": "snapshots/2024-06-17_09-49\n",
+	}}
+	if _, err := (Btrfs{Runner: runner, Dir: "/mnt/snapshots"}).List(); err == nil {
+		t.Errorf("expected an error for output without subvolume ID")
+	}
+}
+
+func TestPrune_Failures(t *testing.T) {
+	runner := &fakeRunner{failures: map[string]error{
+		"zfs destroy pool/data@2024-06-17_09-15": errors.New("dataset is busy"),
+	}}
+	driver := ZFS{Runner: runner, Dataset: "pool/data"}
+	decisions := retention.Decisions{Prune: []string{"2024-06-17_09-15", "2024-06-17_08-15"}}
+
+	var progress []retention.Move
+	result, err := Prune(driver, decisions, func(m retention.Move) { progress = append(progress, m) })
+	if err == nil || err.Error() != "1 of 2 snapshots could not be destroyed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result.Moved() != 1 || result.Failed() != 1 || len(progress) != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if progress[0].From != "pool/data@2024-06-17_09-15" || progress[0].Err == nil {
+		t.Errorf("unexpected progress %+v", progress[0])
+	}
+}
+
+func TestExecRunner(t *testing.T) {
+	if _, err := (ExecRunner{}).Run("prune_backups-command-that-does-not-exist"); err == nil {
+		t.Errorf("expected an error for a missing command")
+	}
+}
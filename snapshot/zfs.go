@@ -0,0 +1,53 @@
+package snapshot
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ZFS manages the snapshots of a ZFS dataset, e.g. pool/data@2024-06-17_09-49.
+// Snapshots of child datasets are not considered.
+type ZFS struct {
+	Runner  Runner // nil means ExecRunner
+	Dataset string // e.g. pool/data
+}
+
+func (z ZFS) runner() Runner {
+	if z.Runner == nil {
+		return ExecRunner{}
+	}
+	return z.Runner
+}
+
+// List returns the names of all snapshots of the dataset, without the
+// "dataset@" part.
+func (z ZFS) List() ([]string, error) {
+	out, err := z.runner().Run("zfs", "list", "-H", "-t", "snapshot", "-o", "name", "-d", "1", z.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, line := range splitLines(out) {
+		dataset, name, found := strings.Cut(line, "@")
+		if !found {
+			return nil, fmt.Errorf("unexpected output of zfs list: %q", line)
+		}
+		if dataset == z.Dataset {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Destroy destroys the snapshot dataset@name.
+func (z ZFS) Destroy(name string) error {
+	if name == "" || strings.ContainsAny(name, "@/") {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	_, err := z.runner().Run("zfs", "destroy", z.Path(name))
+	return err
+}
+
+func (z ZFS) Path(name string) string {
+	return z.Dataset + "@" + name
+}
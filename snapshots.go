@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"prune_backups/retention"
+	"prune_backups/snapshot"
+)
+
+type SnapshotsCmd struct {
+	Driver    string `help:"REQUIRED. The kind of snapshots: zfs (snapshots of the dataset <target>) or btrfs (subvolumes in the directory <target>)." enum:"zfs,btrfs" required:"true"`
+	Destroy   bool   `help:"OPTIONAL. Actually destroy the pruned snapshots. Without this flag, the snapshots that would be destroyed are only listed." default:"false"`
+	Verbosity int    `help:"OPTIONAL. Set verbosity. 0 - mute, 1 - some, 2 - a lot." default:"1" short:"v"`
+	Target    string `arg:"" help:"REQUIRED. The ZFS dataset (e.g. pool/data) or the directory containing the btrfs subvolumes." required:"true"`
+}
+
+func (s *SnapshotsCmd) Run(cli *CLI) error {
+	return pruneSnapshots(newSnapshotDriver(s.Driver, s.Target, nil), time.Now(), s.Verbosity, s.Destroy)
+}
+
+// newSnapshotDriver returns the driver for kind ("zfs" or "btrfs"). A nil
+// runner executes the real commands.
+func newSnapshotDriver(kind, target string, runner snapshot.Runner) snapshot.Driver {
+	if kind == "btrfs" {
+		return snapshot.Btrfs{Runner: runner, Dir: target}
+	}
+	return snapshot.ZFS{Runner: runner, Dataset: target}
+}
+
+func pruneSnapshots(driver snapshot.Driver, now time.Time, verbosity int, destroy bool) error {
+	logger := newLogger(verbosity)
+	names, err := driver.List()
+	if err != nil {
+		return fmt.Errorf("Could not list snapshots: %w", err)
+	}
+	logger.Info(fmt.Sprint("I found ", len(names), " snapshots"), "event", "found", "count", len(names))
+
+	decisions := retention.DefaultPolicy().Plan(now, names)
+	for _, name := range decisions.Skipped {
+		logger.Debug("Skipping "+driver.Path(name)+" as it is not in date format.", "event", "not_dated", "src", driver.Path(name))
+	}
+
+	if !destroy {
+		if verbosity > 0 {
+			fmt.Println("I would destroy the following", len(decisions.Prune), "snapshots (use --destroy to do so):")
+			for _, name := range decisions.Prune {
+				fmt.Printf(" - %s\n", driver.Path(name))
+			}
+		}
+		return nil
+	}
+
+	result, err := snapshot.Prune(driver, decisions, func(m retention.Move) {
+		if m.Err != nil {
+			logger.Error("Error destroying "+m.From+".", "event", "destroy", "src", m.From, "err", m.Err)
+		} else {
+			logger.Debug("Destroyed "+m.From+".", "event", "destroy", "src", m.From)
+		}
+	})
+	logger.Info(fmt.Sprint("I destroyed ", result.Moved(), " snapshots"), "event", "destroyed", "count", result.Moved())
+	return err
+}
@@ -0,0 +1,261 @@
+// Package stats collects disk usage statistics of directory trees, telling
+// apart hard-linked and unlinked files as found in incremental backups.
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"prune_backups/vfs"
+)
+
+// Infoblock holds the statistics collected by DiskUsage.
+type Infoblock struct {
+	SizeOfUnlinkedFiles           uint64
+	SizeOfLinkedFiles             uint64
+	NumberOfUnlinkedFiles         int
+	NumberOfLinkedFiles           int
+	NumberOfSubdirs               int
+	NumberOfPermissionErrorsFiles int
+	NumberOfPermissionErrorsDirs  int
+	NumberOfOtherErrorsFiles      int
+	NumberOfOtherErrorsDirs       int
+	NrApnd                        int
+	NrExcl                        int
+	NrTmp                         int
+	NrSym                         int
+	NrDev                         int
+	NrPipe                        int
+	NrSock                        int
+}
+
+type infoblock_internal struct {
+	ib    Infoblock
+	mutex sync.Mutex
+}
+
+// SupportedOS is true if DiskUsage is implemented for the current operating system.
+var (
+	SupportedOS = runtime.GOOS == "linux" || runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+)
+
+// ErrUnsupported is returned by DiskUsage and DiskUsageFS if SupportedOS is
+// false. It matches errors.ErrUnsupported.
+var ErrUnsupported = fmt.Errorf("disk usage statistics not supported for your OS: %w", errors.ErrUnsupported)
+
+// DiskUsage scans the file or directory tree at path in parallel. Errors
+// accessing individual entries are counted in the result, not returned.
+func DiskUsage(path string) (Infoblock, error) {
+	if !SupportedOS {
+		return Infoblock{}, ErrUnsupported
+	}
+	nevermind, err := os.Open(path)
+	defer func() {
+		if nevermind != nil {
+			_ = nevermind.Close()
+		}
+	}()
+	if err != nil {
+		return Infoblock{}, err
+	}
+	return DiskUsageFS(vfs.OS{}, path)
+}
+
+// DiskUsageFS is like DiskUsage but scans the tree at path in fsys.
+func DiskUsageFS(fsys vfs.FS, path string) (Infoblock, error) {
+	if !SupportedOS {
+		return Infoblock{}, ErrUnsupported
+	}
+	result := infoblock_internal{}
+	const limit = 4000
+	semaphore := NewSemaphore(limit) // Limit the number of concurrent goroutines
+	debug.SetMaxThreads(2 * limit)   // Ensure the thread limit is high enough
+
+	if ok, err := isDirectory(fsys, path); ok {
+		duInternalDirectory(fsys, path, &result, semaphore)
+	} else {
+		if err != nil {
+			errorMessage := fmt.Sprintf("Error identifying %v: %v\n", path, err)
+			return result.ib, errors.New(errorMessage)
+		}
+		duInternalFile(fsys, path, &result)
+	}
+	return result.ib, nil
+}
+
+func isDirectory(fsys vfs.FS, path string) (bool, error) {
+	fileInfo, err := fsys.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	return fileInfo.IsDir(), nil
+}
+
+func duInternalFile(fsys vfs.FS, fileName string, info *infoblock_internal) {
+	fSize, fLinks, err := fsys.SizeAndLinkCount(fileName)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			(*info).ib.NumberOfPermissionErrorsFiles += 1
+		} else {
+			(*info).ib.NumberOfOtherErrorsFiles += 1
+		}
+		return
+	}
+	if fLinks == 1 {
+		(*info).ib.NumberOfUnlinkedFiles += 1
+		(*info).ib.SizeOfUnlinkedFiles += fSize
+	} else {
+		(*info).ib.NumberOfLinkedFiles += 1
+		(*info).ib.SizeOfLinkedFiles += fSize
+	}
+}
+
+func duInternalDirectory(fsys vfs.FS, directoryName string, globalInfo *infoblock_internal, semaphore Semaphore) {
+	localInfo := infoblock_internal{}
+	defer addAll(globalInfo, &localInfo) // this is synchronized
+
+	files, err := readDirWithRetry(fsys, directoryName, 1000000, 2)
+	if err != nil {
+		if errors.Is(err, fs.ErrPermission) {
+			localInfo.ib.NumberOfPermissionErrorsDirs += 1
+		} else {
+			localInfo.ib.NumberOfOtherErrorsDirs += 1
+		}
+		return
+	}
+
+	subdirs := []string{}
+	for _, file := range files {
+		fullPath := filepath.Join(directoryName, file.Name())
+		if file.Type().IsRegular() {
+			duInternalFile(fsys, fullPath, &localInfo)
+		} else if file.Type().IsDir() {
+			subdirs = append(subdirs, fullPath)
+		} else {
+			countAccordingType(file.Type(), &localInfo)
+		}
+	}
+
+	localInfo.ib.NumberOfSubdirs += len(subdirs)
+
+	// now descend into the directories
+	var wg sync.WaitGroup
+
+	for _, subdir := range subdirs {
+		semaphore.Acquire()
+		wg.Add(1)
+		go func(subdir string) {
+			defer func() { semaphore.Release() }() // Release the token when done
+			defer wg.Done()
+			duInternalDirectory(fsys, subdir, globalInfo, semaphore)
+		}(subdir)
+	}
+
+	wg.Wait() // Wait for all child directories to complete
+
+}
+
+func addAll(globalInfo, localInfo *infoblock_internal) {
+	globalInfo.mutex.Lock()
+	globalInfo.ib.SizeOfUnlinkedFiles += localInfo.ib.SizeOfUnlinkedFiles
+	globalInfo.ib.SizeOfLinkedFiles += localInfo.ib.SizeOfLinkedFiles
+	globalInfo.ib.NumberOfUnlinkedFiles += localInfo.ib.NumberOfUnlinkedFiles
+	globalInfo.ib.NumberOfLinkedFiles += localInfo.ib.NumberOfLinkedFiles
+	globalInfo.ib.NumberOfSubdirs += localInfo.ib.NumberOfSubdirs
+	globalInfo.ib.NumberOfPermissionErrorsFiles += localInfo.ib.NumberOfPermissionErrorsFiles
+	globalInfo.ib.NumberOfPermissionErrorsDirs += localInfo.ib.NumberOfPermissionErrorsDirs
+	globalInfo.ib.NumberOfOtherErrorsFiles += localInfo.ib.NumberOfOtherErrorsFiles
+	globalInfo.ib.NumberOfOtherErrorsDirs += localInfo.ib.NumberOfOtherErrorsDirs
+	globalInfo.ib.NrApnd += localInfo.ib.NrApnd
+	globalInfo.ib.NrExcl += localInfo.ib.NrExcl
+	globalInfo.ib.NrTmp += localInfo.ib.NrTmp
+	globalInfo.ib.NrSym += localInfo.ib.NrSym
+	globalInfo.ib.NrDev += localInfo.ib.NrDev
+	globalInfo.ib.NrPipe += localInfo.ib.NrPipe
+	globalInfo.ib.NrSock += localInfo.ib.NrSock
+	globalInfo.mutex.Unlock()
+}
+
+func countAccordingType(mode fs.FileMode, info *infoblock_internal) {
+	switch mode {
+	case fs.ModeDir:
+		(*info).ib.NumberOfSubdirs++
+		return
+	case fs.ModeAppend:
+		(*info).ib.NrApnd++
+		return
+	case fs.ModeExclusive:
+		(*info).ib.NrExcl++
+		return
+	case fs.ModeTemporary:
+		(*info).ib.NrTmp++
+		return
+	case fs.ModeSymlink:
+		(*info).ib.NrSym++
+		return
+	case fs.ModeDevice:
+		(*info).ib.NrDev++
+		return
+	case fs.ModeNamedPipe:
+		(*info).ib.NrPipe++
+		return
+	case fs.ModeSocket:
+		(*info).ib.NrSock++
+		return
+	}
+}
+
+func readDirWithRetry(fsys vfs.FS, directoryName string, retries, maxWaitSeconds int) ([]fs.DirEntry, error) {
+	for range retries {
+		direntries, err := fsys.ReadDir(directoryName)
+		if err != nil {
+			pathErr, ok := err.(*os.PathError)
+			if !ok {
+				return nil, fmt.Errorf("error reading directory (error type %s): %v", reflect.TypeOf(err), err)
+			}
+			if pathErr.Err.Error() == "too many open files" {
+				// Wait a random time before retrying
+				rnd := rand.IntN(maxWaitSeconds * 1000)
+				time.Sleep(time.Duration(200+rnd) * time.Millisecond) // wait at least 200ms
+				continue
+			}
+			if pathErr.Err.Error() == "permission denied" {
+				return nil, pathErr.Err
+			}
+			return nil, err
+		}
+		return direntries, nil
+	}
+	return nil, fmt.Errorf("failed to read directory after %v retries: %s", retries, directoryName)
+}
+
+// Semaphore limits concurrent goroutines via a buffered channel.
+// Goroutines park on Acquire when the limit is reached and are woken
+// immediately when a slot is released — no busy-waiting, no polling delay.
+type Semaphore chan struct{}
+
+func NewSemaphore(limit int) Semaphore {
+	return make(Semaphore, limit)
+}
+
+func (s Semaphore) Acquire() { s <- struct{}{} }
+func (s Semaphore) Release() { <-s }
+
+// TryAcquire acquires a slot if one is free and reports whether it did.
+func (s Semaphore) TryAcquire() bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package stats
 
 import (
 	"io/fs"
@@ -7,6 +7,8 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+
+	"prune_backups/vfs"
 )
 
 func TestIsDirectory(t *testing.T) {
@@ -14,13 +16,13 @@ func TestIsDirectory(t *testing.T) {
 		path     string
 		expected bool
 	}{
-		{"./testdata", true},     // Assuming testdata is a directory
-		{"./go.mod", false},      // Assuming go.mod is a file
+		{"../testdata", true},    // Assuming testdata is a directory
+		{"../go.mod", false},     // Assuming go.mod is a file
 		{"./nonexistent", false}, // Non-existent path
 	}
 
 	for _, test := range tests {
-		result, err := isDirectory(test.path)
+		result, err := isDirectory(vfs.OS{}, test.path)
 		if err != nil && test.expected {
 			t.Errorf("isDirectory(%s) returned error: %v", test.path, err)
 		}
@@ -30,38 +32,6 @@ func TestIsDirectory(t *testing.T) {
 	}
 }
 
-func TestOpenFileWithRetry(t *testing.T) {
-	tests := []struct {
-		filename        string
-		retries         int
-		maxwait_seconds int
-		expectError     bool
-	}{
-		{"go.mod", 3, 1, false},             // Assuming go.mod exists
-		{"nonexistentfile.txt", 3, 1, true}, // Non-existent file
-		{"irrelevant", 0, 1, true},          // try 0 times -> shall return cannot open file error
-	}
-
-	for _, test := range tests {
-		file, err := openFileWithRetry(test.filename, test.retries, test.maxwait_seconds)
-		if test.expectError {
-			if err == nil {
-				t.Errorf("expected error for file %s, but got none", test.filename)
-			}
-		} else {
-			if err != nil {
-				t.Errorf("unexpected error for file %s: %v", test.filename, err)
-			}
-			if file != nil {
-				err2 := file.Close()
-				if err2 != nil {
-					t.Errorf("unexpected error for file %s: %v", test.filename, err2)
-				}
-			}
-		}
-	}
-}
-
 func TestReadDirWithRetry(t *testing.T) {
 	tests := []struct {
 		directoryname   string
@@ -69,13 +39,13 @@ func TestReadDirWithRetry(t *testing.T) {
 		maxwait_seconds int
 		expectError     bool
 	}{
-		{"testdata", 3, 1, false},      // Assuming testdata exists
+		{"../testdata", 3, 1, false},   // Assuming testdata exists
 		{"nonexistentdir", 3, 1, true}, // Non-existent directory
 		{"irrelevant", 0, 1, true},     // try 0 times -> shall return cannot open file error
 	}
 
 	for _, test := range tests {
-		direntries, err := readDirWithRetry(test.directoryname, test.retries, test.maxwait_seconds)
+		direntries, err := readDirWithRetry(vfs.OS{}, test.directoryname, test.retries, test.maxwait_seconds)
 		if test.expectError {
 			if err == nil {
 				t.Errorf("expected error for directory %s, but got none", test.directoryname)
@@ -90,31 +60,6 @@ func TestReadDirWithRetry(t *testing.T) {
 	}
 }
 
-func TestGetSizeAndLinkCount(t *testing.T) {
-	tests := []struct {
-		filename    string
-		expectError bool
-	}{
-		{"go.mod", false},             // Assuming go.mod exists
-		{"nonexistentfile.txt", true}, // Non-existent file
-	}
-
-	for _, test := range tests {
-		size, linkCount, err := getSizeAndLinkCount(test.filename)
-		if test.expectError {
-			if err == nil {
-				t.Errorf("expected error for file %s, but got none", test.filename)
-			}
-		} else {
-			if err != nil {
-				t.Errorf("unexpected error for file %s: %v", test.filename, err)
-			} else {
-				t.Logf("File: %s, Size: %d, Link Count: %d", test.filename, size, linkCount)
-			}
-		}
-	}
-}
-
 func Test_du0(t *testing.T) {
 	expectedOutput := "open nonexistingfileordirectoryname5648623485762456: no such file or directory"
 	if runtime.GOOS == "windows" {
@@ -135,16 +80,16 @@ func Test_du0(t *testing.T) {
 func Test_DiskUsage_OnFile(t *testing.T) {
 	// DiskUsage is normally called with a directory. This test exercises the
 	// duInternalFile branch that is taken when a plain file path is passed.
-	info, err := DiskUsage("go.mod")
+	info, err := DiskUsage("../go.mod")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	totalFiles := info.number_of_unlinked_files + info.number_of_linked_files
+	totalFiles := info.NumberOfUnlinkedFiles + info.NumberOfLinkedFiles
 	if totalFiles != 1 {
 		t.Errorf("expected exactly 1 file, got %d (unlinked: %d, linked: %d)",
-			totalFiles, info.number_of_unlinked_files, info.number_of_linked_files)
+			totalFiles, info.NumberOfUnlinkedFiles, info.NumberOfLinkedFiles)
 	}
-	totalSize := info.size_of_unlinked_files + info.size_of_linked_files
+	totalSize := info.SizeOfUnlinkedFiles + info.SizeOfLinkedFiles
 	if totalSize == 0 {
 		t.Errorf("expected non-zero file size for go.mod")
 	}
@@ -162,7 +107,7 @@ func Test_du1(t *testing.T) {
 	}{
 		{
 			name:                     "Test 1",
-			dir:                      "./testdata/du_test1",
+			dir:                      "../testdata/du_test1",
 			number_of_unlinked_files: 4,
 			size_of_unlinked_files:   20,
 			number_of_linked_files:   0,
@@ -178,13 +123,13 @@ func Test_du1(t *testing.T) {
 				t.Fatalf("unexpected error: %v", err)
 			}
 			//got_number_of_unlinked_files, got_size_of_unlinked_files, got_number_of_linked_files, got_size_of_linked_files, got_number_of_subdirs := du(tt.dir)
-			if got.number_of_unlinked_files != tt.number_of_unlinked_files || got.size_of_unlinked_files != tt.size_of_unlinked_files || got.number_of_linked_files != tt.number_of_linked_files || got.size_of_linked_files != tt.size_of_linked_files || got.number_of_subdirs != tt.number_of_subdirs {
+			if got.NumberOfUnlinkedFiles != tt.number_of_unlinked_files || got.SizeOfUnlinkedFiles != tt.size_of_unlinked_files || got.NumberOfLinkedFiles != tt.number_of_linked_files || got.SizeOfLinkedFiles != tt.size_of_linked_files || got.NumberOfSubdirs != tt.number_of_subdirs {
 				t.Errorf("du got: #uf:%v/%v, size uf:%v/%v, #lf:%v/%v, size lf:%v/%v, dirs:%v/%v",
-					got.number_of_unlinked_files, tt.number_of_unlinked_files,
-					got.size_of_unlinked_files, tt.size_of_unlinked_files,
-					got.number_of_linked_files, tt.number_of_linked_files,
-					got.size_of_linked_files, tt.size_of_linked_files,
-					got.number_of_subdirs, tt.number_of_subdirs)
+					got.NumberOfUnlinkedFiles, tt.number_of_unlinked_files,
+					got.SizeOfUnlinkedFiles, tt.size_of_unlinked_files,
+					got.NumberOfLinkedFiles, tt.number_of_linked_files,
+					got.SizeOfLinkedFiles, tt.size_of_linked_files,
+					got.NumberOfSubdirs, tt.number_of_subdirs)
 			}
 		})
 	}
@@ -223,13 +168,13 @@ func Test_du2(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	//got_number_of_unlinked_files, got_size_of_unlinked_files, got_number_of_linked_files, got_size_of_linked_files, got_number_of_subdirs := du(dir)
-	if got.number_of_unlinked_files != number_of_unlinked_files || got.size_of_unlinked_files != size_of_unlinked_files || got.number_of_linked_files != number_of_linked_files || got.size_of_linked_files != size_of_linked_files || got.number_of_subdirs != number_of_subdirs {
+	if got.NumberOfUnlinkedFiles != number_of_unlinked_files || got.SizeOfUnlinkedFiles != size_of_unlinked_files || got.NumberOfLinkedFiles != number_of_linked_files || got.SizeOfLinkedFiles != size_of_linked_files || got.NumberOfSubdirs != number_of_subdirs {
 		t.Errorf("du got: #uf:%v/%v, size uf:%v/%v, #lf:%v/%v, size lf:%v/%v, dirs:%v/%v",
-			got.number_of_unlinked_files, number_of_unlinked_files,
-			got.size_of_unlinked_files, size_of_unlinked_files,
-			got.number_of_linked_files, number_of_linked_files,
-			got.size_of_linked_files, size_of_linked_files,
-			got.number_of_subdirs, number_of_subdirs)
+			got.NumberOfUnlinkedFiles, number_of_unlinked_files,
+			got.SizeOfUnlinkedFiles, size_of_unlinked_files,
+			got.NumberOfLinkedFiles, number_of_linked_files,
+			got.SizeOfLinkedFiles, size_of_linked_files,
+			got.NumberOfSubdirs, number_of_subdirs)
 	}
 }
 
@@ -272,13 +217,13 @@ func Test_du3(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	//got_number_of_unlinked_files, got_size_of_unlinked_files, got_number_of_linked_files, got_size_of_linked_files, got_number_of_subdirs := du(dir)
-	if got.number_of_unlinked_files != number_of_unlinked_files || got.size_of_unlinked_files != size_of_unlinked_files || got.number_of_linked_files != number_of_linked_files || got.size_of_linked_files != size_of_linked_files || got.number_of_subdirs != number_of_subdirs {
+	if got.NumberOfUnlinkedFiles != number_of_unlinked_files || got.SizeOfUnlinkedFiles != size_of_unlinked_files || got.NumberOfLinkedFiles != number_of_linked_files || got.SizeOfLinkedFiles != size_of_linked_files || got.NumberOfSubdirs != number_of_subdirs {
 		t.Errorf("du got: #uf:%v/%v, size uf:%v/%v, #lf:%v/%v, size lf:%v/%v, dirs:%v/%v",
-			got.number_of_unlinked_files, number_of_unlinked_files,
-			got.size_of_unlinked_files, size_of_unlinked_files,
-			got.number_of_linked_files, number_of_linked_files,
-			got.size_of_linked_files, size_of_linked_files,
-			got.number_of_subdirs, number_of_subdirs)
+			got.NumberOfUnlinkedFiles, number_of_unlinked_files,
+			got.SizeOfUnlinkedFiles, size_of_unlinked_files,
+			got.NumberOfLinkedFiles, number_of_linked_files,
+			got.SizeOfLinkedFiles, size_of_linked_files,
+			got.NumberOfSubdirs, number_of_subdirs)
 	}
 }
 
@@ -339,13 +284,13 @@ func Test_du4(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	//got_number_of_unlinked_files, got_size_of_unlinked_files, got_number_of_linked_files, got_size_of_linked_files, got_number_of_subdirs := du(dir)
-	if got.number_of_unlinked_files != number_of_unlinked_files || got.size_of_unlinked_files != size_of_unlinked_files || got.number_of_linked_files != number_of_linked_files || got.size_of_linked_files != size_of_linked_files || got.number_of_subdirs != number_of_subdirs {
+	if got.NumberOfUnlinkedFiles != number_of_unlinked_files || got.SizeOfUnlinkedFiles != size_of_unlinked_files || got.NumberOfLinkedFiles != number_of_linked_files || got.SizeOfLinkedFiles != size_of_linked_files || got.NumberOfSubdirs != number_of_subdirs {
 		t.Errorf("du got: #uf:%v/%v, size uf:%v/%v, #lf:%v/%v, size lf:%v/%v, dirs:%v/%v",
-			got.number_of_unlinked_files, number_of_unlinked_files,
-			got.size_of_unlinked_files, size_of_unlinked_files,
-			got.number_of_linked_files, number_of_linked_files,
-			got.size_of_linked_files, size_of_linked_files,
-			got.number_of_subdirs, number_of_subdirs)
+			got.NumberOfUnlinkedFiles, number_of_unlinked_files,
+			got.SizeOfUnlinkedFiles, size_of_unlinked_files,
+			got.NumberOfLinkedFiles, number_of_linked_files,
+			got.SizeOfLinkedFiles, size_of_linked_files,
+			got.NumberOfSubdirs, number_of_subdirs)
 	}
 }
 
@@ -407,13 +352,13 @@ func Test_du5(t *testing.T) {
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if got.number_of_unlinked_files != number_of_unlinked_files || got.size_of_unlinked_files != size_of_unlinked_files || got.number_of_linked_files != number_of_linked_files || got.size_of_linked_files != size_of_linked_files || got.number_of_subdirs != number_of_subdirs {
+		if got.NumberOfUnlinkedFiles != number_of_unlinked_files || got.SizeOfUnlinkedFiles != size_of_unlinked_files || got.NumberOfLinkedFiles != number_of_linked_files || got.SizeOfLinkedFiles != size_of_linked_files || got.NumberOfSubdirs != number_of_subdirs {
 			t.Errorf("du got: #uf:%v/%v, size uf:%v/%v, #lf:%v/%v, size lf:%v/%v, dirs:%v/%v",
-				got.number_of_unlinked_files, number_of_unlinked_files,
-				got.size_of_unlinked_files, size_of_unlinked_files,
-				got.number_of_linked_files, number_of_linked_files,
-				got.size_of_linked_files, size_of_linked_files,
-				got.number_of_subdirs, number_of_subdirs)
+				got.NumberOfUnlinkedFiles, number_of_unlinked_files,
+				got.SizeOfUnlinkedFiles, size_of_unlinked_files,
+				got.NumberOfLinkedFiles, number_of_linked_files,
+				got.SizeOfLinkedFiles, size_of_linked_files,
+				got.NumberOfSubdirs, number_of_subdirs)
 		}
 
 		number_of_permission_errors_files := 1
@@ -421,12 +366,12 @@ func Test_du5(t *testing.T) {
 		number_of_other_errors_files := 0
 		number_of_other_errors_dirs := 0
 
-		if got.number_of_permission_errors_files != number_of_permission_errors_files || got.number_of_permission_errors_dirs != number_of_permission_errors_dirs || got.number_of_other_errors_files != number_of_other_errors_files || got.number_of_other_errors_dirs != number_of_other_errors_dirs {
+		if got.NumberOfPermissionErrorsFiles != number_of_permission_errors_files || got.NumberOfPermissionErrorsDirs != number_of_permission_errors_dirs || got.NumberOfOtherErrorsFiles != number_of_other_errors_files || got.NumberOfOtherErrorsDirs != number_of_other_errors_dirs {
 			t.Errorf("du got: pef:%v/%v, ped:%v/%v, oef:%v/%v, oed:%v/%v",
-				got.number_of_permission_errors_files, number_of_permission_errors_files,
-				got.number_of_permission_errors_dirs, number_of_permission_errors_dirs,
-				got.number_of_other_errors_files, number_of_other_errors_files,
-				got.number_of_other_errors_dirs, number_of_other_errors_dirs)
+				got.NumberOfPermissionErrorsFiles, number_of_permission_errors_files,
+				got.NumberOfPermissionErrorsDirs, number_of_permission_errors_dirs,
+				got.NumberOfOtherErrorsFiles, number_of_other_errors_files,
+				got.NumberOfOtherErrorsDirs, number_of_other_errors_dirs)
 		}
 
 		err = os.Chmod(noreadDirSub1, 0755)
@@ -442,6 +387,8 @@ func Test_du5(t *testing.T) {
 	})
 }
 
+const USE_DEFAULT_DIRECTORY_FOR_TEMP_FILES = "" // see https://pkg.go.dev/os#MkdirTemp
+
 func createTestfile(name string, size int) (err error) {
 	file, err := os.Create(name)
 	if err != nil {
@@ -471,14 +418,14 @@ func TestCountAccordingType(t *testing.T) {
 		mode     fs.FileMode
 		expected func(info *infoblock_internal) int
 	}{
-		{fs.ModeDir, func(info *infoblock_internal) int { return info.ib.number_of_subdirs }},
-		{fs.ModeAppend, func(info *infoblock_internal) int { return info.ib.nr_apnd }},
-		{fs.ModeExclusive, func(info *infoblock_internal) int { return info.ib.nr_excl }},
-		{fs.ModeTemporary, func(info *infoblock_internal) int { return info.ib.nr_tmp }},
-		{fs.ModeSymlink, func(info *infoblock_internal) int { return info.ib.nr_sym }},
-		{fs.ModeDevice, func(info *infoblock_internal) int { return info.ib.nr_dev }},
-		{fs.ModeNamedPipe, func(info *infoblock_internal) int { return info.ib.nr_pipe }},
-		{fs.ModeSocket, func(info *infoblock_internal) int { return info.ib.nr_sock }},
+		{fs.ModeDir, func(info *infoblock_internal) int { return info.ib.NumberOfSubdirs }},
+		{fs.ModeAppend, func(info *infoblock_internal) int { return info.ib.NrApnd }},
+		{fs.ModeExclusive, func(info *infoblock_internal) int { return info.ib.NrExcl }},
+		{fs.ModeTemporary, func(info *infoblock_internal) int { return info.ib.NrTmp }},
+		{fs.ModeSymlink, func(info *infoblock_internal) int { return info.ib.NrSym }},
+		{fs.ModeDevice, func(info *infoblock_internal) int { return info.ib.NrDev }},
+		{fs.ModeNamedPipe, func(info *infoblock_internal) int { return info.ib.NrPipe }},
+		{fs.ModeSocket, func(info *infoblock_internal) int { return info.ib.NrSock }},
 	}
 
 	for _, test := range tests {
@@ -489,3 +436,39 @@ func TestCountAccordingType(t *testing.T) {
 		}
 	}
 }
+
+func TestDiskUsageFS(t *testing.T) {
+	m := vfs.NewMemFS()
+	for _, dir := range []string{"/snap/a/b", "/snap/c"} {
+		if err := m.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.CreateFile("/snap/a/b/file1", 37, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateFile("/snap/c/file2", 41, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Link("/snap/c/file2", "/snap/a/link2"); err != nil {
+		t.Fatal(err)
+	}
+	m.Fault = func(op, name string) error {
+		if op == "size" && name == filepath.Join("/snap", "a", "b", "file1") {
+			return fs.ErrPermission
+		}
+		return nil
+	}
+
+	got, err := DiskUsageFS(m, "/snap")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.NumberOfUnlinkedFiles != 0 || got.NumberOfLinkedFiles != 2 || got.SizeOfLinkedFiles != 82 || got.NumberOfSubdirs != 3 || got.NumberOfPermissionErrorsFiles != 1 {
+		t.Errorf("unexpected result: %+v", got)
+	}
+
+	if _, err := DiskUsageFS(m, "/nonexisting"); err == nil {
+		t.Errorf("expected an error for a nonexisting path")
+	}
+}
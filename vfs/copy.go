@@ -0,0 +1,282 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// Devicer is implemented by filesystems that can tell the device a file
+// resides on, so that moves across devices can be detected before renaming.
+type Devicer interface {
+	Device(name string) (uint64, error)
+}
+
+// Copier is implemented by filesystems that can copy a tree to another
+// device, for moves that cannot be done by renaming.
+type Copier interface {
+	CopyTree(src, dst string) error
+}
+
+// Device returns the device the file name resides on.
+func (OS) Device(name string) (uint64, error) {
+	return getDevice(name)
+}
+
+// CopyTree copies the file or directory tree src to dst, which must not
+// exist, and verifies the copy. Hard links within src, permissions,
+// ownership (if running as root), times and extended attributes are
+// preserved where the platform supports it. Files other than regular files,
+// directories and symlinks cannot be copied. On failure, the partial copy is
+// removed; src is never modified.
+func (OS) CopyTree(src, dst string) error {
+	if _, err := os.Lstat(dst); err == nil {
+		return &fs.PathError{Op: "copy", Path: dst, Err: fs.ErrExist}
+	}
+	c := treeCopy{links: map[fileKey]string{}, sums: map[string][]byte{}}
+	err := c.copy(src, dst)
+	if err == nil {
+		err = c.verify(src, dst)
+	}
+	if err != nil {
+		_ = os.RemoveAll(dst)
+		return err
+	}
+	return nil
+}
+
+// fileKey identifies a file on a device, for detecting hard links.
+type fileKey struct {
+	dev, ino uint64
+}
+
+// fileMeta holds the metadata CopyTree preserves beyond fs.FileInfo. ok is
+// false if the platform does not provide it.
+type fileMeta struct {
+	ok       bool
+	key      fileKey
+	nlink    uint64
+	uid, gid int
+	atime    time.Time
+}
+
+type treeCopy struct {
+	links map[fileKey]string // the first copy of each hard-linked file
+	sums  map[string][]byte  // the SHA-256 of each regular file copied, by source path
+}
+
+func (c *treeCopy) copy(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	meta, err := statMeta(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		if err := os.Mkdir(dst, 0700); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := c.copy(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+	case info.Mode().IsRegular():
+		if meta.ok && meta.nlink > 1 {
+			if first, ok := c.links[meta.key]; ok {
+				return os.Link(first, dst)
+			}
+			c.links[meta.key] = dst
+		}
+		if c.sums[src], err = copyFile(src, dst); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot copy %s: unsupported file type %s", src, info.Mode().Type())
+	}
+	return setMeta(src, dst, info, meta)
+}
+
+// setMeta applies the metadata of src to its copy dst. The times are set
+// last, as the other changes may update them.
+func setMeta(src, dst string, info fs.FileInfo, meta fileMeta) error {
+	symlink := info.Mode()&fs.ModeSymlink != 0
+	if meta.ok && os.Geteuid() == 0 {
+		if err := os.Lchown(dst, meta.uid, meta.gid); err != nil {
+			return err
+		}
+	}
+	if !symlink {
+		if err := os.Chmod(dst, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+	}
+	attrs, err := getXattrs(src)
+	if err != nil {
+		return err
+	}
+	if err := setXattrs(dst, attrs); err != nil {
+		return err
+	}
+	atime := meta.atime
+	if !meta.ok {
+		atime = info.ModTime()
+	}
+	return lchtimes(dst, atime, info.ModTime(), symlink)
+}
+
+func copyFile(src, dst string) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, hash), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return hash.Sum(nil), err
+}
+
+func hashFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// verify compares the tree src with its copy dst: types, permissions, sizes,
+// modification times, symlink targets, extended attributes, hard links and
+// the content of regular files.
+func (c *treeCopy) verify(src, dst string) error {
+	mismatch := func(what string) error {
+		return fmt.Errorf("verifying the copy %s of %s failed: %s differs", dst, src, what)
+	}
+	si, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	di, err := os.Lstat(dst)
+	if err != nil {
+		return err
+	}
+	if si.Mode().Type() != di.Mode().Type() {
+		return mismatch("type")
+	}
+	symlink := si.Mode()&fs.ModeSymlink != 0
+	if !symlink && si.Mode() != di.Mode() {
+		return mismatch("mode")
+	}
+	if !si.IsDir() && si.Size() != di.Size() {
+		return mismatch("size")
+	}
+	if !si.ModTime().Truncate(time.Second).Equal(di.ModTime().Truncate(time.Second)) {
+		return mismatch("modification time")
+	}
+	sattrs, err := getXattrs(src)
+	if err != nil {
+		return err
+	}
+	dattrs, err := getXattrs(dst)
+	if err != nil {
+		return err
+	}
+	if len(sattrs) > 0 && !reflect.DeepEqual(sattrs, dattrs) {
+		return mismatch("extended attributes")
+	}
+
+	switch {
+	case si.IsDir():
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		copied, err := os.ReadDir(dst)
+		if err != nil {
+			return err
+		}
+		if len(entries) != len(copied) {
+			return mismatch("number of entries")
+		}
+		for _, entry := range entries {
+			if err := c.verify(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+				return err
+			}
+		}
+	case symlink:
+		st, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		dt, err := os.Readlink(dst)
+		if err != nil {
+			return err
+		}
+		if st != dt {
+			return mismatch("symlink target")
+		}
+	default:
+		sum, ok := c.sums[src]
+		if !ok { // a hard link to a file copied before
+			meta, err := statMeta(src)
+			if err != nil {
+				return err
+			}
+			first, err := os.Lstat(c.links[meta.key])
+			if err != nil {
+				return err
+			}
+			if !os.SameFile(first, di) {
+				return mismatch("hard link")
+			}
+			return nil
+		}
+		copiedSum, err := hashFile(dst)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(sum, copiedSum) {
+			return mismatch("content")
+		}
+	}
+	return nil
+}
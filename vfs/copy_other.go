@@ -0,0 +1,31 @@
+//go:build !(linux || darwin)
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+func statMeta(name string) (fileMeta, error) {
+	return fileMeta{}, nil // no hard links or ownership are preserved
+}
+
+func lchtimes(name string, atime, mtime time.Time, symlink bool) error {
+	if symlink {
+		return nil
+	}
+	return os.Chtimes(name, atime, mtime)
+}
+
+func getXattrs(name string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+func setXattrs(name string, attrs map[string][]byte) error {
+	if len(attrs) > 0 {
+		return errors.New("extended attributes are not supported")
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestOS_CopyTree(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "snap")
+	mtime := time.Date(2024, 6, 17, 9, 49, 0, 0, time.UTC)
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "sub", "file"), []byte("content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "sub", "file"), filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/file", filepath.Join(src, "symlink")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sub/file", "sub", "."} {
+		if err := os.Chtimes(filepath.Join(src, name), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(filepath.Join(src, "sub"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	xattrs := setXattrs(filepath.Join(src, "sub", "file"), map[string][]byte{"user.prune_backups": []byte("test")}) == nil
+
+	dst := filepath.Join(dir, "trash", "snap")
+	if err := os.Mkdir(filepath.Join(dir, "trash"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := (OS{}).CopyTree(src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "link"))
+	if err != nil || string(data) != "content" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	file, _ := os.Stat(filepath.Join(dst, "sub", "file"))
+	link, _ := os.Stat(filepath.Join(dst, "link"))
+	if runtime.GOOS != "windows" && !os.SameFile(file, link) {
+		t.Errorf("expected the hard link to be preserved")
+	}
+	if file.Mode().Perm() != 0640 || !file.ModTime().Equal(mtime) {
+		t.Errorf("unexpected mode %v or time %v", file.Mode(), file.ModTime())
+	}
+	if sub, _ := os.Stat(filepath.Join(dst, "sub")); sub.Mode().Perm() != 0750 || !sub.ModTime().Equal(mtime) {
+		t.Errorf("unexpected directory mode %v or time %v", sub.Mode(), sub.ModTime())
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "symlink")); err != nil || target != "sub/file" {
+		t.Errorf("Readlink() = %q, %v", target, err)
+	}
+	if attrs, err := getXattrs(filepath.Join(dst, "sub", "file")); xattrs && (err != nil || string(attrs["user.prune_backups"]) != "test") {
+		t.Errorf("expected the extended attributes to be copied, got %v, %v", attrs, err)
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Errorf("expected the source to stay: %v", err)
+	}
+
+	if err := (OS{}).CopyTree(src, dst); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected an existing destination to be refused, got %v", err)
+	}
+}
+
+func TestOS_Device(t *testing.T) {
+	dir := t.TempDir()
+	a, err := (OS{}).Device(dir)
+	if err != nil {
+		t.Skipf("devices not supported: %v", err)
+	}
+	b, err := (OS{}).Device(filepath.Join(dir, "."))
+	if err != nil || a != b {
+		t.Errorf("expected the same device, got %d, %d, %v", a, b, err)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build linux || darwin
+
+package vfs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func getDevice(name string) (uint64, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(name, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Dev), nil
+}
+
+func statMeta(name string) (fileMeta, error) {
+	var stat unix.Stat_t
+	if err := unix.Lstat(name, &stat); err != nil {
+		return fileMeta{}, err
+	}
+	return fileMeta{
+		ok:    true,
+		key:   fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)},
+		nlink: uint64(stat.Nlink),
+		uid:   int(stat.Uid),
+		gid:   int(stat.Gid),
+		atime: time.Unix(stat.Atim.Unix()),
+	}, nil
+}
+
+func lchtimes(name string, atime, mtime time.Time, symlink bool) error {
+	ts := []unix.Timespec{unix.NsecToTimespec(atime.UnixNano()), unix.NsecToTimespec(mtime.UnixNano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, name, ts, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// getXattrs returns the extended attributes of name, without following
+// symlinks. Filesystems without extended attributes have none.
+func getXattrs(name string) (map[string][]byte, error) {
+	size, err := unix.Llistxattr(name, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	list := make([]byte, size)
+	if size, err = unix.Llistxattr(name, list); err != nil {
+		return nil, err
+	}
+	attrs := make(map[string][]byte)
+	for _, attr := range bytes.Split(list[:size], []byte{0}) {
+		if len(attr) == 0 {
+			continue
+		}
+		size, err := unix.Lgetxattr(name, string(attr), nil)
+		if err != nil {
+			return nil, err
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(name, string(attr), value); err != nil {
+			return nil, err
+		}
+		attrs[string(attr)] = value[:size]
+	}
+	return attrs, nil
+}
+
+func setXattrs(name string, attrs map[string][]byte) error {
+	for attr, value := range attrs {
+		if err := unix.Lsetxattr(name, attr, value, 0); err != nil {
+			return fmt.Errorf("could not set extended attribute %s of %s: %w", attr, name, err)
+		}
+	}
+	return nil
+}
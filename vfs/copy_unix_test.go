@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOS_CopyTree_Unsupported(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "snap")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(src, "fifo"), 0644); err != nil {
+		t.Skipf("cannot create a named pipe: %v", err)
+	}
+	dst := filepath.Join(dir, "copy")
+	if err := (OS{}).CopyTree(src, dst); err == nil {
+		t.Errorf("expected an error for the named pipe")
+	}
+	if _, err := os.Lstat(dst); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the partial copy to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// ErrSymlink is returned by Dir.OpenDir if a path component is a symlink, and
+// by OS.Lock if the lock file is one.
+var ErrSymlink = errors.New("symlinks are not followed")
+
+// DirOpener is implemented by filesystems that can open a directory once
+// and work relative to it, so that someone who can write to the directory
+// cannot redirect moves by swapping in symlinks, see Dir.
+type DirOpener interface {
+	OpenDir(name string) (Dir, error)
+}
+
+// Dir is an opened directory. The names passed to its methods are resolved
+// relative to it, one path component at a time, and symlinks are never
+// followed: a symlink found in place of a directory is an error, and a
+// symlink found in place of a renamed or removed entry is renamed or
+// removed itself. Except for OpenDir, names must be single path components.
+type Dir interface {
+	// Name returns the path the directory was opened with, for messages.
+	Name() string
+	Stat() (fs.FileInfo, error)
+	// ReadDir returns the entries of the directory, sorted by name.
+	ReadDir() ([]fs.DirEntry, error)
+	// OpenDir opens the directory name relative to this one. It fails with
+	// an error wrapping ErrSymlink if any component of name is a symlink.
+	OpenDir(name string) (Dir, error)
+	// Lstat returns information about the entry name, describing a symlink
+	// itself rather than its target.
+	Lstat(name string) (fs.FileInfo, error)
+	// SizeAndLinkCount returns the size and the number of hard links of the
+	// regular file name, like FS.SizeAndLinkCount.
+	SizeAndLinkCount(name string) (size, linkCount uint64, err error)
+	// Remove removes the entry name, a file, a symlink or an empty directory.
+	Remove(name string) error
+	// Rename moves the entry name to newName in newDir. Both names must be
+	// single path components. It fails with an error wrapping fs.ErrExist
+	// if newName is taken, instead of replacing it.
+	Rename(name string, newDir Dir, newName string) error
+	Close() error
+}
+
+// OpenDir opens the directory name for use with Dir. Symlinks in name
+// itself are followed, as it is chosen by the caller, but not in the names
+// resolved relative to it later. It returns an error wrapping
+// errors.ErrUnsupported if the operating system is not supported.
+func (OS) OpenDir(name string) (Dir, error) {
+	return openDir(name)
+}
@@ -0,0 +1,180 @@
+//go:build linux
+
+package vfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// osDir is a Dir backed by a directory file descriptor, using openat(2),
+// fstatat(2), unlinkat(2) and renameat2(2).
+type osDir struct {
+	f *os.File
+}
+
+func openDir(name string) (Dir, error) {
+	fd, err := openat(unix.AT_FDCWD, name, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &osDir{f: os.NewFile(uintptr(fd), name)}, nil
+}
+
+// openat opens the directory name relative to dirfd, retrying on EINTR.
+func openat(dirfd int, name string, flags int) (int, error) {
+	for {
+		fd, err := unix.Openat(dirfd, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC|flags, 0)
+		if err != unix.EINTR {
+			return fd, err
+		}
+	}
+}
+
+func (d *osDir) Name() string {
+	return d.f.Name()
+}
+
+func (d *osDir) Stat() (fs.FileInfo, error) {
+	return d.f.Stat()
+}
+
+func (d *osDir) ReadDir() ([]fs.DirEntry, error) {
+	// a duplicate rewound first, as reading moves the offset of d.f; opening
+	// "." instead would require search permission
+	fd, err := unix.FcntlInt(d.f.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+	if err == nil {
+		if _, err = unix.Seek(fd, 0, io.SeekStart); err != nil {
+			_ = unix.Close(fd)
+		}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdirent", Path: d.Name(), Err: err}
+	}
+	f := os.NewFile(uintptr(fd), d.Name())
+	defer func() {
+		_ = f.Close()
+	}()
+	entries, err := f.ReadDir(-1)
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int { return strings.Compare(a.Name(), b.Name()) })
+	return entries, err
+}
+
+func (d *osDir) OpenDir(name string) (Dir, error) {
+	path := filepath.Join(d.Name(), name)
+	fd := int(d.f.Fd())
+	for i, component := range strings.Split(filepath.Clean(name), string(filepath.Separator)) {
+		if component == ".." || filepath.IsAbs(name) {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: fs.ErrInvalid}
+		}
+		next, err := openat(fd, component, unix.O_NOFOLLOW)
+		if errors.Is(err, unix.ENOTDIR) || errors.Is(err, unix.ELOOP) {
+			var st unix.Stat_t
+			if unix.Fstatat(fd, component, &st, unix.AT_SYMLINK_NOFOLLOW) == nil && st.Mode&unix.S_IFMT == unix.S_IFLNK {
+				err = ErrSymlink
+			}
+		}
+		if i > 0 {
+			_ = unix.Close(fd)
+		}
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: path, Err: err}
+		}
+		fd = next
+	}
+	return &osDir{f: os.NewFile(uintptr(fd), path)}, nil
+}
+
+func (d *osDir) Lstat(name string) (fs.FileInfo, error) {
+	if err := d.checkName("lstat", name); err != nil {
+		return nil, err
+	}
+	// an O_PATH descriptor of the entry itself, as the os package cannot
+	// make a FileInfo from a Stat_t
+	fd, err := unix.Openat(int(d.f.Fd()), name, unix.O_PATH|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "lstat", Path: filepath.Join(d.Name(), name), Err: err}
+	}
+	f := os.NewFile(uintptr(fd), filepath.Join(d.Name(), name))
+	defer func() {
+		_ = f.Close()
+	}()
+	return f.Stat()
+}
+
+func (d *osDir) SizeAndLinkCount(name string) (size, linkCount uint64, err error) {
+	if err := d.checkName("lstat", name); err != nil {
+		return 0, 0, err
+	}
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(d.f.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return 0, 0, &fs.PathError{Op: "lstat", Path: filepath.Join(d.Name(), name), Err: err}
+	}
+	return uint64(st.Size), uint64(st.Nlink), nil
+}
+
+func (d *osDir) Remove(name string) error {
+	if err := d.checkName("remove", name); err != nil {
+		return err
+	}
+	// as os.Remove, try both and report the error that makes sense
+	fd := int(d.f.Fd())
+	err := unix.Unlinkat(fd, name, 0)
+	if err == nil {
+		return nil
+	}
+	err1 := unix.Unlinkat(fd, name, unix.AT_REMOVEDIR)
+	if err1 == nil {
+		return nil
+	}
+	if err1 != unix.ENOTDIR {
+		err = err1
+	}
+	return &fs.PathError{Op: "remove", Path: filepath.Join(d.Name(), name), Err: err}
+}
+
+// checkName returns an error if name is not a single path component.
+func (d *osDir) checkName(op, name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, filepath.Separator) {
+		return &fs.PathError{Op: op, Path: filepath.Join(d.Name(), name), Err: fs.ErrInvalid}
+	}
+	return nil
+}
+
+func (d *osDir) Rename(name string, newDir Dir, newName string) error {
+	nd, ok := newDir.(*osDir)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: filepath.Join(d.Name(), name), New: filepath.Join(newDir.Name(), newName), Err: err}
+	}
+	if !ok || strings.ContainsRune(name, filepath.Separator) || strings.ContainsRune(newName, filepath.Separator) {
+		return linkErr(fs.ErrInvalid)
+	}
+	oldfd, newfd := int(d.f.Fd()), int(nd.f.Fd())
+	err := unix.Renameat2(oldfd, name, newfd, newName, unix.RENAME_NOREPLACE)
+	if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+		// the filesystem or kernel does not support RENAME_NOREPLACE, so
+		// check first, leaving a small window
+		var st unix.Stat_t
+		switch err = unix.Fstatat(newfd, newName, &st, unix.AT_SYMLINK_NOFOLLOW); {
+		case err == nil:
+			err = unix.EEXIST
+		case errors.Is(err, unix.ENOENT):
+			err = unix.Renameat(oldfd, name, newfd, newName)
+		}
+	}
+	if err != nil {
+		return linkErr(err)
+	}
+	return nil
+}
+
+func (d *osDir) Close() error {
+	return d.f.Close()
+}
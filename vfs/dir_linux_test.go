@@ -0,0 +1,125 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOS_OpenDir(t *testing.T) {
+	dir := t.TempDir()
+	elsewhere := t.TempDir()
+	for _, name := range []string{"b", "a", "trash/run", "taken/a"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(elsewhere, filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := OS{}.OpenDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+	for range 2 { // reading again starts at the beginning
+		entries, err := root.ReadDir()
+		if err != nil || len(entries) != 5 || entries[0].Name() != "a" || entries[4].Name() != "trash" {
+			t.Errorf("ReadDir() = %v, %v", entries, err)
+		}
+	}
+	if _, err := root.OpenDir("link/sub"); !errors.Is(err, ErrSymlink) {
+		t.Errorf("expected the symlink not to be followed, got %v", err)
+	}
+	if _, err := root.OpenDir("../" + filepath.Base(dir)); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected .. to be refused, got %v", err)
+	}
+	run, err := root.OpenDir("trash/run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = run.Close()
+	}()
+	if run.Name() != filepath.Join(dir, "trash/run") {
+		t.Errorf("Name() = %s", run.Name())
+	}
+
+	if err := root.Rename("a", run, "a"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "trash/run/a")); err != nil {
+		t.Errorf("expected a to be moved: %v", err)
+	}
+	// an existing empty directory would be replaced by rename(2)
+	taken, err := root.OpenDir("taken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = taken.Close()
+	}()
+	if err := root.Rename("b", taken, "a"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected an existing name to be refused, got %v", err)
+	}
+	// a symlink is moved itself
+	if err := root.Rename("link", run, "link"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "trash/run/link")); err != nil || target != elsewhere {
+		t.Errorf("expected the symlink to be moved, got %s, %v", target, err)
+	}
+}
+
+func TestOS_DirRemove(t *testing.T) {
+	dir := t.TempDir()
+	elsewhere := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(elsewhere, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(elsewhere, "file"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(elsewhere, "file"), filepath.Join(dir, "hardlink")); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := OS{}.OpenDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+	if info, err := root.Lstat("link"); err != nil || info.Mode()&fs.ModeSymlink == 0 || info.Name() != "link" {
+		t.Errorf("expected the symlink itself, got %v, %v", info, err)
+	}
+	if size, links, err := root.SizeAndLinkCount("hardlink"); err != nil || size != 4 || links != 2 {
+		t.Errorf("SizeAndLinkCount() = %d, %d, %v", size, links, err)
+	}
+	if _, err := root.Lstat("empty/x"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected a path to be refused, got %v", err)
+	}
+	for _, name := range []string{"link", "hardlink", "empty"} {
+		if err := root.Remove(name); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, "file")); err != nil {
+		t.Errorf("expected the target of the symlink to stay: %v", err)
+	}
+	if err := root.Remove("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a missing entry to be reported, got %v", err)
+	}
+}
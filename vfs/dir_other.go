@@ -0,0 +1,13 @@
+//go:build !linux
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func openDir(name string) (Dir, error) {
+	return nil, fmt.Errorf("directories cannot be opened for relative moves on this operating system (%s): %w", runtime.GOOS, errors.ErrUnsupported)
+}
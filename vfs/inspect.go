@@ -0,0 +1,23 @@
+package vfs
+
+// Inspector is implemented by filesystems that can tell in advance why a file
+// could not be moved or removed, so that problems are found before anything
+// is changed, see retention.Preflight.
+type Inspector interface {
+	Inspect(name string) (Inspection, error)
+}
+
+// Inspection holds the properties of a file that decide whether it can be
+// moved or removed. Symlinks are not followed.
+type Inspection struct {
+	Writable   bool // the process may write to the file, or create and remove entries in the directory
+	MountPoint bool // the file is the root of a mounted filesystem
+	Immutable  bool // the file cannot be modified, renamed or removed, see chattr(1)
+	AppendOnly bool // the file can only be appended to, and entries of the directory cannot be removed
+}
+
+// Inspect returns the properties of name. It returns an error wrapping
+// errors.ErrUnsupported if the operating system is not supported.
+func (OS) Inspect(name string) (Inspection, error) {
+	return inspect(name)
+}
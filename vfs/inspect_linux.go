@@ -0,0 +1,77 @@
+//go:build linux
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// the inode flags of linux/fs.h, which x/sys does not define
+const (
+	fsImmutableFl = 0x10
+	fsAppendFl    = 0x20
+)
+
+func inspect(name string) (Inspection, error) {
+	var result Inspection
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, name, unix.AT_SYMLINK_NOFOLLOW, unix.STATX_TYPE, &stx); err != nil {
+		return result, &os.PathError{Op: "statx", Path: name, Err: err}
+	}
+	if stx.Attributes_mask&unix.STATX_ATTR_MOUNT_ROOT != 0 {
+		result.MountPoint = stx.Attributes&unix.STATX_ATTR_MOUNT_ROOT != 0
+	} else { // kernels before 5.8: compare the device with the one of the parent directory
+		var parent unix.Stat_t
+		if err := unix.Lstat(filepath.Dir(name), &parent); err != nil {
+			return result, &os.PathError{Op: "lstat", Path: filepath.Dir(name), Err: err}
+		}
+		result.MountPoint = unix.Mkdev(stx.Dev_major, stx.Dev_minor) != parent.Dev
+	}
+	typ := uint32(stx.Mode) & unix.S_IFMT
+	if typ == unix.S_IFLNK {
+		// symlinks have neither permissions nor flags of their own
+		result.Writable = true
+		return result, nil
+	}
+	mode := uint32(unix.W_OK)
+	if typ == unix.S_IFDIR {
+		mode |= unix.X_OK
+	}
+	result.Writable = unix.Access(name, mode) == nil
+	if typ != unix.S_IFDIR && typ != unix.S_IFREG {
+		return result, nil // opening devices or fifos may have side effects
+	}
+
+	flags, err := getFlags(name)
+	if err != nil {
+		return result, &os.PathError{Op: "ioctl", Path: name, Err: err}
+	}
+	result.Immutable = flags&fsImmutableFl != 0
+	result.AppendOnly = flags&fsAppendFl != 0
+	return result, nil
+}
+
+// getFlags reads the inode flags of name with the FS_IOC_GETFLAGS ioctl. A
+// file that cannot be opened for reading, or a filesystem without inode
+// flags, has no flags.
+func getFlags(name string) (uint32, error) {
+	fd, err := unix.Open(name, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_NOFOLLOW|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.EACCES) || errors.Is(err, unix.EPERM) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = unix.Close(fd)
+	}()
+	flags, err := unix.IoctlGetUint32(fd, unix.FS_IOC_GETFLAGS)
+	if errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) {
+		return 0, nil
+	}
+	return flags, err
+}
@@ -0,0 +1,31 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOS_Inspect(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{dir, file, filepath.Join(dir, "link")} {
+		in, err := OS{}.Inspect(name)
+		if err != nil || in != (Inspection{Writable: true}) {
+			t.Errorf("Inspect(%s) = %+v, %v", name, in, err)
+		}
+	}
+	if in, err := (OS{}).Inspect("/proc"); err != nil || !in.MountPoint {
+		t.Errorf("expected /proc to be a mount point, got %+v, %v", in, err)
+	}
+	if _, err := (OS{}).Inspect(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file to be reported, got %v", err)
+	}
+}
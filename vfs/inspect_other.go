@@ -0,0 +1,13 @@
+//go:build !linux
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+func inspect(name string) (Inspection, error) {
+	return Inspection{}, fmt.Errorf("files cannot be inspected on this operating system (%s): %w", runtime.GOOS, errors.ErrUnsupported)
+}
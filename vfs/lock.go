@@ -0,0 +1,56 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// ErrLocked is returned by Locker.Lock if the lock is held elsewhere.
+var ErrLocked = errors.New("locked by another process")
+
+// Locker is implemented by filesystems that support advisory locks, so that
+// concurrent runs on the same directory can be prevented.
+type Locker interface {
+	// Lock takes an exclusive lock on the file name, creating it if
+	// necessary, and replaces its content with info, which describes the
+	// holder of the lock. If the lock is held elsewhere, Lock waits for it if
+	// wait is set, or returns an error wrapping ErrLocked. The lock is held
+	// until unlock is called or the process exits.
+	Lock(name string, wait bool, info []byte) (unlock func() error, err error)
+}
+
+// Lock takes the lock with flock(2), or LockFileEx on Windows. The lock file
+// is never removed, as removing it would let two processes lock different
+// files of the same name. As anyone who can write to the directory could
+// plant a symlink to another file in its place, a symlink is refused with an
+// error wrapping ErrSymlink, and so is anything but a regular file. It
+// returns an error wrapping errors.ErrUnsupported if the operating system is
+// not supported.
+func (OS) Lock(name string, wait bool, info []byte) (unlock func() error, err error) {
+	f, err := openLockFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if stat, err := f.Stat(); err != nil || !stat.Mode().IsRegular() {
+		_ = f.Close()
+		if err == nil {
+			err = &fs.PathError{Op: "lock", Path: name, Err: fmt.Errorf("not a regular file (%s)", stat.Mode().Type())}
+		}
+		return nil, err
+	}
+	if err := lockFile(f, wait); err != nil {
+		_ = f.Close()
+		return nil, &fs.PathError{Op: "lock", Path: name, Err: err}
+	}
+	if err = f.Truncate(0); err == nil {
+		if _, err = f.WriteAt(info, 0); err == nil {
+			err = f.Sync()
+		}
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f.Close, nil // closing the file releases the lock
+}
@@ -0,0 +1,18 @@
+//go:build !(windows || linux || darwin)
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+func openLockFile(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+func lockFile(f *os.File, wait bool) error {
+	return fmt.Errorf("files cannot be locked on this operating system (%s): %w", runtime.GOOS, errors.ErrUnsupported)
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin
+
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOS_Lock_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.WriteFile(victim, []byte("precious"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(dir, "lock")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := (OS{}).Lock(filepath.Join(dir, "lock"), false, []byte("{}")); !errors.Is(err, ErrSymlink) {
+		t.Errorf("expected the symlinked lock file to be refused, got %v", err)
+	}
+	if data, err := os.ReadFile(victim); err != nil || string(data) != "precious" {
+		t.Errorf("expected the target to be left unchanged, got %q, %v", data, err)
+	}
+
+	if err := syscall.Mkfifo(filepath.Join(dir, "fifo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (OS{}).Lock(filepath.Join(dir, "fifo"), false, []byte("{}")); err == nil {
+		t.Errorf("expected a lock file that is not a regular file to be refused")
+	}
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffsetHigh is the upper half of the offset of the byte locked on
+// Windows. It lies far beyond the content, so that other processes can still
+// read who holds the lock.
+const lockOffsetHigh = 0x7fffffff
+
+// openLockFile opens or creates the lock file name. Creating symlinks
+// requires a privilege on Windows, so only an existing one is checked for.
+func openLockFile(name string) (*os.File, error) {
+	if info, err := os.Lstat(name); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrSymlink}
+	}
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+func lockFile(f *os.File, wait bool) error {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	ol := windows.Overlapped{OffsetHigh: lockOffsetHigh}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrLocked
+	}
+	return err
+}
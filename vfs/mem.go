@@ -0,0 +1,328 @@
+package vfs
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MemFS is an in-memory implementation of FS, mainly intended for tests.
+// It supports directories, regular files and hard links. Fault, if set, is
+// called before every operation and can be used to inject errors.
+type MemFS struct {
+	// Fault is called with the operation ("readdir", "stat", "mkdir",
+	// "rename", "remove", "size", "read" or "write") and the path it works on. A non-nil result is
+	// returned instead of performing the operation.
+	Fault func(op, name string) error
+
+	mutex sync.Mutex
+	nodes map[string]*memNode
+}
+
+type memNode struct {
+	mode    fs.FileMode
+	size    int64
+	data    []byte // nil for files created by CreateFile
+	modTime time.Time
+	links   uint64
+}
+
+type memFileInfo struct {
+	name string
+	node memNode
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.node.size }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.node.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.node.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.node.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
+
+// NewMemFS returns an empty MemFS containing only the root directory.
+func NewMemFS() *MemFS {
+	m := &MemFS{nodes: make(map[string]*memNode)}
+	m.nodes["/"] = &memNode{mode: fs.ModeDir | 0755, links: 1}
+	return m
+}
+
+// clean maps name to the key used in m.nodes. Relative names are treated as
+// relative to the root.
+func clean(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+func (m *MemFS) fault(op, name string) error {
+	if m.Fault == nil {
+		return nil
+	}
+	return m.Fault(op, name)
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := m.fault("readdir", name); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := clean(name)
+	node, ok := m.nodes[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: syscall.ENOTDIR}
+	}
+	var result []fs.DirEntry
+	for p, n := range m.nodes {
+		if p != "/" && path.Dir(p) == key {
+			result = append(result, fs.FileInfoToDirEntry(memFileInfo{name: path.Base(p), node: *n}))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
+
+func (m *MemFS) Stat(name string) (fs.FileInfo, error) {
+	if err := m.fault("stat", name); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := clean(name)
+	node, ok := m.nodes[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return memFileInfo{name: path.Base(key), node: *node}, nil
+}
+
+func (m *MemFS) MkdirAll(name string, perm fs.FileMode) error {
+	if err := m.fault("mkdir", name); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.mkdirAll(clean(name), name, perm)
+}
+
+func (m *MemFS) mkdirAll(key, name string, perm fs.FileMode) error {
+	if node, ok := m.nodes[key]; ok {
+		if node.mode.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	if err := m.mkdirAll(path.Dir(key), name, perm); err != nil {
+		return err
+	}
+	m.nodes[key] = &memNode{mode: fs.ModeDir | perm.Perm(), modTime: time.Now(), links: 1}
+	return nil
+}
+
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	if err := m.fault("rename", oldpath); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	oldKey, newKey := clean(oldpath), clean(newpath)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	node, ok := m.nodes[oldKey]
+	if !ok {
+		return linkErr(fs.ErrNotExist)
+	}
+	if parent, ok := m.nodes[path.Dir(newKey)]; !ok || !parent.mode.IsDir() {
+		return linkErr(fs.ErrNotExist)
+	}
+	if oldKey == newKey {
+		return nil
+	}
+	if strings.HasPrefix(newKey, oldKey+"/") {
+		return linkErr(syscall.EINVAL)
+	}
+	if target, ok := m.nodes[newKey]; ok {
+		// like rename(2): an empty directory may be replaced by a directory
+		if !node.mode.IsDir() || !target.mode.IsDir() {
+			return linkErr(fs.ErrExist)
+		}
+		if m.hasChildren(newKey) {
+			return linkErr(syscall.ENOTEMPTY)
+		}
+	}
+	moved := make(map[string]*memNode)
+	for p, n := range m.nodes {
+		if p == oldKey || strings.HasPrefix(p, oldKey+"/") {
+			moved[newKey+strings.TrimPrefix(p, oldKey)] = n
+			delete(m.nodes, p)
+		}
+	}
+	for p, n := range moved {
+		m.nodes[p] = n
+	}
+	return nil
+}
+
+func (m *MemFS) RemoveAll(name string) error {
+	if err := m.fault("remove", name); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := clean(name)
+	if key == "/" {
+		return &fs.PathError{Op: "unlinkat", Path: name, Err: syscall.EBUSY}
+	}
+	for p, n := range m.nodes {
+		if p == key || strings.HasPrefix(p, key+"/") {
+			n.links--
+			delete(m.nodes, p)
+		}
+	}
+	return nil
+}
+
+// Remove removes the file or empty directory name.
+func (m *MemFS) Remove(name string) error {
+	if err := m.fault("remove", name); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := clean(name)
+	node, ok := m.nodes[key]
+	switch {
+	case !ok:
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	case key == "/":
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	case m.hasChildren(key):
+		return &fs.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+	node.links--
+	delete(m.nodes, key)
+	return nil
+}
+
+func (m *MemFS) hasChildren(key string) bool {
+	for p := range m.nodes {
+		if strings.HasPrefix(p, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *MemFS) SizeAndLinkCount(name string) (size, linkCount uint64, err error) {
+	if err := m.fault("size", name); err != nil {
+		return 0, 0, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, ok := m.nodes[clean(name)]
+	if !ok {
+		return 0, 0, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return uint64(node.size), node.links, nil
+}
+
+// CreateFile creates or replaces a regular file of the given size without
+// any content; the parent directory must exist.
+func (m *MemFS) CreateFile(name string, size int64, perm fs.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.createFile(name, size, nil, perm)
+}
+
+// WriteFile creates or replaces the regular file name with data; the parent
+// directory must exist.
+func (m *MemFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	if err := m.fault("write", name); err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.createFile(name, int64(len(data)), append([]byte{}, data...), perm)
+}
+
+// ReadFile returns the content of the regular file name. Files created by
+// CreateFile read as zero bytes.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	if err := m.fault("read", name); err != nil {
+		return nil, err
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, ok := m.nodes[clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if node.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	if node.data == nil {
+		return make([]byte, node.size), nil
+	}
+	return append([]byte{}, node.data...), nil
+}
+
+func (m *MemFS) createFile(name string, size int64, data []byte, perm fs.FileMode) error {
+	key := clean(name)
+	if parent, ok := m.nodes[path.Dir(key)]; !ok || !parent.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if node, ok := m.nodes[key]; ok {
+		if node.mode.IsDir() {
+			return &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+		node.links--
+	}
+	m.nodes[key] = &memNode{mode: perm.Perm(), size: size, data: data, modTime: time.Now(), links: 1}
+	return nil
+}
+
+// Chtimes sets the modification time of name.
+func (m *MemFS) Chtimes(name string, mtime time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	node, ok := m.nodes[clean(name)]
+	if !ok {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: fs.ErrNotExist}
+	}
+	node.modTime = mtime
+	return nil
+}
+
+// Link creates newname as a hard link to the regular file oldname.
+func (m *MemFS) Link(oldname, newname string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	oldKey, newKey := clean(oldname), clean(newname)
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	node, ok := m.nodes[oldKey]
+	if !ok {
+		return linkErr(fs.ErrNotExist)
+	}
+	if node.mode.IsDir() {
+		return linkErr(syscall.EPERM)
+	}
+	if _, ok := m.nodes[newKey]; ok {
+		return linkErr(fs.ErrExist)
+	}
+	if parent, ok := m.nodes[path.Dir(newKey)]; !ok || !parent.mode.IsDir() {
+		return linkErr(fs.ErrNotExist)
+	}
+	node.links++
+	m.nodes[newKey] = node
+	return nil
+}
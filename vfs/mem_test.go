@@ -0,0 +1,214 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func readDirNames(t *testing.T, fsys FS, name string) []string {
+	entries, err := fsys.ReadDir(name)
+	if err != nil {
+		t.Fatalf("ReadDir(%s) failed: %v", name, err)
+	}
+	result := []string{}
+	for _, e := range entries {
+		result = append(result, e.Name())
+	}
+	return result
+}
+
+func TestMemFS_MkdirAllAndReadDir(t *testing.T) {
+	m := NewMemFS()
+	if err := m.MkdirAll("/backup/2024-06-17_09-49/etc", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.MkdirAll("/backup/2024-06-16_09-49", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateFile("/backup/latest.txt", 3, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readDirNames(t, m, "/backup")
+	want := []string{"2024-06-16_09-49", "2024-06-17_09-49", "latest.txt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDir() = %v, want %v", got, want)
+	}
+
+	entries, _ := m.ReadDir("/backup")
+	if !entries[0].IsDir() || entries[2].IsDir() {
+		t.Errorf("unexpected entry types: %v", entries)
+	}
+
+	if _, err := m.ReadDir("/nonexisting"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if err := m.MkdirAll("/backup/latest.txt/sub", 0755); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("expected ENOTDIR, got %v", err)
+	}
+}
+
+func TestMemFS_Rename(t *testing.T) {
+	m := NewMemFS()
+	for _, dir := range []string{"/b/one/sub", "/b/two", "/b/to_delete/two"} {
+		if err := m.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.CreateFile("/b/two/file", 1, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateFile("/b/to_delete/two/file", 1, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Rename("/b/one", "/b/to_delete/one"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.Stat("/b/to_delete/one/sub"); err != nil {
+		t.Errorf("expected subdirectory to move along: %v", err)
+	}
+	if _, err := m.Stat("/b/one"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected source to be gone, got %v", err)
+	}
+
+	if err := m.Rename("/b/two", "/b/to_delete/two"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("expected ENOTEMPTY renaming onto a non-empty directory, got %v", err)
+	}
+	if err := m.Rename("/b/missing", "/b/to_delete/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+	if err := m.Rename("/b/two", "/b/nonexisting/two"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist for missing target parent, got %v", err)
+	}
+	if err := m.Rename("/b/two", "/b/two/inside"); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("expected EINVAL moving a directory into itself, got %v", err)
+	}
+}
+
+func TestMemFS_Links(t *testing.T) {
+	m := NewMemFS()
+	if err := m.MkdirAll("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateFile("/d/a", 4444, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Link("/d/a", "/d/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"/d/a", "/d/b"} {
+		size, links, err := m.SizeAndLinkCount(name)
+		if err != nil || size != 4444 || links != 2 {
+			t.Errorf("SizeAndLinkCount(%s) = %d, %d, %v; want 4444, 2, nil", name, size, links, err)
+		}
+	}
+
+	if err := m.Link("/d/a", "/d/b"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected ErrExist, got %v", err)
+	}
+	if err := m.Link("/d", "/e"); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("expected EPERM linking a directory, got %v", err)
+	}
+}
+
+func TestMemFS_Fault(t *testing.T) {
+	m := NewMemFS()
+	m.Fault = func(op, name string) error {
+		if op == "mkdir" {
+			return &fs.PathError{Op: op, Path: name, Err: syscall.ENOSPC}
+		}
+		return nil
+	}
+	if err := m.MkdirAll("/x", 0755); !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("expected injected ENOSPC, got %v", err)
+	}
+	if _, err := m.Stat("/x"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected /x not to be created, got %v", err)
+	}
+}
+
+func TestMemFS_RemoveAll(t *testing.T) {
+	m := NewMemFS()
+	if err := m.MkdirAll("/d/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateFile("/d/sub/a", 10, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Link("/d/sub/a", "/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RemoveAll("/d"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := m.Stat("/d/sub/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected subtree to be removed, got %v", err)
+	}
+	if _, links, _ := m.SizeAndLinkCount("/a"); links != 1 {
+		t.Errorf("expected link count 1 after removing the other link, got %d", links)
+	}
+	if err := m.RemoveAll("/nonexisting"); err != nil {
+		t.Errorf("expected no error removing a nonexisting path, got %v", err)
+	}
+}
+
+func TestMemFS_Remove(t *testing.T) {
+	m := NewMemFS()
+	if err := m.MkdirAll("/d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CreateFile("/d/a", 10, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Link("/d/a", "/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Remove("/d"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("expected ENOTEMPTY removing a non-empty directory, got %v", err)
+	}
+	if err := m.Remove("/d/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, links, _ := m.SizeAndLinkCount("/a"); links != 1 {
+		t.Errorf("expected link count 1 after removing the other link, got %d", links)
+	}
+	if err := m.Remove("/d"); err != nil {
+		t.Errorf("unexpected error removing an empty directory: %v", err)
+	}
+	if err := m.Remove("/d"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestMemFS_ReadWriteFile(t *testing.T) {
+	m := NewMemFS()
+	if err := m.WriteFile("/missing/file", []byte("x"), 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist for a missing parent, got %v", err)
+	}
+	if err := m.WriteFile("/file", []byte("content"), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := m.ReadFile("/file")
+	if err != nil || string(data) != "content" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	if info, _ := m.Stat("/file"); info.Size() != 7 || info.Mode() != 0600 {
+		t.Errorf("unexpected file info %v", info)
+	}
+	if err := m.CreateFile("/empty", 3, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := m.ReadFile("/empty"); !reflect.DeepEqual(data, []byte{0, 0, 0}) {
+		t.Errorf("expected zero bytes, got %v", data)
+	}
+	if _, err := m.ReadFile("/"); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("expected EISDIR, got %v", err)
+	}
+}
@@ -1,8 +1,9 @@
 //go:build !(windows || linux || darwin)
 
-package main
+package vfs
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 )
@@ -11,3 +12,7 @@ func getSizeAndLinkCount(filename string) (size, linkCount uint64, err error) {
 	err = fmt.Errorf("Sorry, the necessary low level file system operations are not implemented for this this operating system (%s).", runtime.GOOS)
 	return 0, 0, err
 }
+
+func getDevice(name string) (uint64, error) {
+	return 0, fmt.Errorf("devices cannot be determined for this operating system (%s): %w", runtime.GOOS, errors.ErrUnsupported)
+}
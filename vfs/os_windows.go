@@ -1,11 +1,12 @@
 //go:build windows
 
-package main
+package vfs
 
 import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"os"
 
 	"golang.org/x/sys/windows"
 )
@@ -42,3 +43,19 @@ func getSizeAndLinkCount(filename string) (size, linkCount uint64, err error) {
 
 	return
 }
+
+// getDevice returns the serial number of the volume name resides on.
+func getDevice(name string) (uint64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var fileInfo windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(windows.Handle(file.Fd()), &fileInfo); err != nil {
+		return 0, fmt.Errorf("error calling windows.GetFileInformationByHandle (%s)", err)
+	}
+	return uint64(fileInfo.VolumeSerialNumber), nil
+}
@@ -0,0 +1,342 @@
+// Package s3fs implements vfs.FS on top of an S3-compatible object store.
+// Directories are emulated by key prefixes separated by "/": the snapshot
+// s3://bucket/host/2024-06-17_09-49/ is the set of all objects whose keys
+// start with "host/2024-06-17_09-49/".
+package s3fs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Client is the subset of the S3 API used by FS. It follows the API of
+// *minio.Client, except for GetObject returning a plain io.ReadCloser; use
+// NewClient or WrapMinio to get a Client for a *minio.Client.
+type Client interface {
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	ComposeObject(ctx context.Context, dst minio.CopyDestOptions, srcs ...minio.CopySrcOptions) (minio.UploadInfo, error)
+	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
+	GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error)
+	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+}
+
+type minioClient struct {
+	*minio.Client
+}
+
+// WrapMinio returns a Client using c.
+func WrapMinio(c *minio.Client) Client {
+	return minioClient{c}
+}
+
+// GetObject returns the object's content. Unlike minio's GetObject, errors
+// like a missing object are reported right away.
+func (c minioClient) GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	obj, err := c.Client.GetObject(ctx, bucketName, objectName, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := obj.Stat(); err != nil {
+		_ = obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+// FS provides access to the objects of a single bucket.
+type FS struct {
+	Client Client
+	Bucket string
+	Ctx    context.Context // used for all requests; context.Background() if nil
+}
+
+// New returns an FS for bucket.
+func New(client Client, bucket string) *FS {
+	return &FS{Client: client, Bucket: bucket}
+}
+
+// NewClient creates a Client for endpoint (host[:port]). Credentials
+// are taken from the environment (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN or MINIO_ROOT_USER, MINIO_ROOT_PASSWORD) or from the
+// AWS credentials file, in this order.
+func NewClient(endpoint, region string, secure bool) (Client, error) {
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+	})
+	c, err := minio.New(endpoint, &minio.Options{Creds: creds, Secure: secure, Region: region})
+	if err != nil {
+		return nil, err
+	}
+	return WrapMinio(c), nil
+}
+
+// ParseURL splits an URL of the form s3://bucket/prefix into bucket and
+// prefix. ok is false if u is not an s3 URL.
+func ParseURL(u string) (bucket, prefix string, ok bool, err error) {
+	if !strings.HasPrefix(u, "s3://") {
+		return "", "", false, nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", "", true, err
+	}
+	if parsed.Host == "" {
+		return "", "", true, fmt.Errorf("missing bucket name in %s", u)
+	}
+	return parsed.Host, strings.Trim(parsed.Path, "/"), true, nil
+}
+
+func (f *FS) ctx() context.Context {
+	if f.Ctx == nil {
+		return context.Background()
+	}
+	return f.Ctx
+}
+
+// key converts a path name into an object key prefix without leading and
+// trailing slashes. The bucket root is "".
+func key(name string) string {
+	k := path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(k, "/")
+}
+
+// dirPrefix returns the prefix of all objects "inside" the directory k.
+func dirPrefix(k string) string {
+	if k == "" {
+		return ""
+	}
+	return k + "/"
+}
+
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi objectInfo) Name() string { return fi.name }
+func (fi objectInfo) Size() int64  { return fi.size }
+func (fi objectInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi objectInfo) ModTime() time.Time { return fi.modTime }
+func (fi objectInfo) IsDir() bool        { return fi.dir }
+func (fi objectInfo) Sys() any           { return nil }
+
+// list returns the objects and common prefixes directly below prefix, or all
+// objects below prefix if recursive is set.
+func (f *FS) list(prefix string, recursive bool) ([]minio.ObjectInfo, error) {
+	var result []minio.ObjectInfo
+	for obj := range f.Client.ListObjects(f.ctx(), f.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: recursive}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		result = append(result, obj)
+	}
+	return result, nil
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	prefix := dirPrefix(key(name))
+	objects, err := f.list(prefix, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	if len(objects) == 0 && prefix != "" {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	var result []fs.DirEntry
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, prefix)
+		if rel == "" {
+			continue // a "directory marker" object created by some tools
+		}
+		info := objectInfo{name: strings.TrimSuffix(rel, "/"), size: obj.Size, modTime: obj.LastModified, dir: strings.HasSuffix(rel, "/")}
+		result = append(result, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	k := key(name)
+	if k == "" {
+		return objectInfo{name: "/", dir: true}, nil
+	}
+	objects, err := f.list(k, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	for _, obj := range objects {
+		switch obj.Key {
+		case k:
+			return objectInfo{name: path.Base(k), size: obj.Size, modTime: obj.LastModified}, nil
+		case k + "/":
+			return objectInfo{name: path.Base(k), modTime: obj.LastModified, dir: true}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+// MkdirAll does nothing, as directories only exist implicitly in S3.
+func (f *FS) MkdirAll(name string, perm fs.FileMode) error {
+	return nil
+}
+
+// Rename copies all objects below oldpath to newpath and removes the
+// originals afterwards. The originals are only removed if all copies
+// succeeded. newpath must not contain any objects yet. If there are no
+// objects below oldpath, the single object oldpath is moved, e.g. a
+// snapshot file; newpath must not exist then.
+func (f *FS) Rename(oldpath, newpath string) error {
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	oldKey, newKey := key(oldpath), key(newpath)
+	oldPrefix, newPrefix := dirPrefix(oldKey), dirPrefix(newKey)
+	if oldPrefix == "" || newPrefix == "" || strings.HasPrefix(newPrefix, oldPrefix) {
+		return linkErr(syscall.EINVAL)
+	}
+	existing, err := f.list(newPrefix, false)
+	if err != nil {
+		return linkErr(err)
+	}
+	if len(existing) > 0 {
+		return linkErr(syscall.ENOTEMPTY)
+	}
+	objects, err := f.list(oldPrefix, true)
+	if err != nil {
+		return linkErr(err)
+	}
+	if len(objects) == 0 {
+		return f.renameObject(oldKey, newKey, linkErr)
+	}
+	for _, obj := range objects {
+		dst := minio.CopyDestOptions{Bucket: f.Bucket, Object: newPrefix + strings.TrimPrefix(obj.Key, oldPrefix)}
+		src := minio.CopySrcOptions{Bucket: f.Bucket, Object: obj.Key}
+		if _, err := f.Client.ComposeObject(f.ctx(), dst, src); err != nil {
+			return linkErr(fmt.Errorf("copying %s: %w", obj.Key, err))
+		}
+	}
+	return f.removeObjects(objects, linkErr)
+}
+
+// renameObject copies the object oldKey to newKey and removes the original
+// afterwards.
+func (f *FS) renameObject(oldKey, newKey string, linkErr func(error) error) error {
+	info, err := f.Stat(oldKey)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return linkErr(fs.ErrNotExist)
+		}
+		return linkErr(err)
+	}
+	if info.IsDir() {
+		return linkErr(syscall.EINVAL)
+	}
+	if _, err := f.Stat(newKey); err == nil {
+		return linkErr(fs.ErrExist)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return linkErr(err)
+	}
+	dst := minio.CopyDestOptions{Bucket: f.Bucket, Object: newKey}
+	src := minio.CopySrcOptions{Bucket: f.Bucket, Object: oldKey}
+	if _, err := f.Client.ComposeObject(f.ctx(), dst, src); err != nil {
+		return linkErr(fmt.Errorf("copying %s: %w", oldKey, err))
+	}
+	if err := f.Client.RemoveObject(f.ctx(), f.Bucket, oldKey, minio.RemoveObjectOptions{}); err != nil {
+		return linkErr(fmt.Errorf("removing %s: %w", oldKey, err))
+	}
+	return nil
+}
+
+// RemoveAll removes all objects below name.
+func (f *FS) RemoveAll(name string) error {
+	pathErr := func(err error) error {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	prefix := dirPrefix(key(name))
+	if prefix == "" {
+		return pathErr(syscall.EBUSY)
+	}
+	objects, err := f.list(prefix, true)
+	if err != nil {
+		return pathErr(err)
+	}
+	return f.removeObjects(objects, pathErr)
+}
+
+func (f *FS) removeObjects(objects []minio.ObjectInfo, wrap func(error) error) error {
+	var errs []error
+	for _, obj := range objects {
+		if err := f.Client.RemoveObject(f.ctx(), f.Bucket, obj.Key, minio.RemoveObjectOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("removing %s: %w", obj.Key, err))
+		}
+	}
+	if len(errs) > 0 {
+		return wrap(errors.Join(errs...))
+	}
+	return nil
+}
+
+// ReadFile returns the content of the object name.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	k := key(name)
+	if k == "" {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	obj, err := f.Client.GetObject(f.ctx(), f.Bucket, k, minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			err = fs.ErrNotExist
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer func() {
+		_ = obj.Close()
+	}()
+	return io.ReadAll(obj)
+}
+
+// WriteFile stores data as object name. perm is ignored.
+func (f *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	k := key(name)
+	if k == "" {
+		return &fs.PathError{Op: "write", Path: name, Err: syscall.EISDIR}
+	}
+	if _, err := f.Client.PutObject(f.ctx(), f.Bucket, k, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{}); err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
+
+// SizeAndLinkCount returns the size of the object name. Objects cannot be
+// hard-linked, so the link count is always 1.
+func (f *FS) SizeAndLinkCount(name string) (size, linkCount uint64, err error) {
+	info, err := f.Stat(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(info.Size()), 1, nil
+}
@@ -0,0 +1,221 @@
+package s3fs
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"prune_backups/internal/s3test"
+	"prune_backups/retention"
+	"prune_backups/vfs"
+)
+
+var (
+	_ vfs.FS = (*FS)(nil)
+	_ Client = (*s3test.MemClient)(nil)
+)
+
+func newTestFS(keys ...string) (*FS, *s3test.MemClient) {
+	client := s3test.NewMemClient()
+	for _, k := range keys {
+		client.Put("backups", k, 10)
+	}
+	return New(client, "backups"), client
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url    string
+		bucket string
+		prefix string
+		ok     bool
+		err    bool
+	}{
+		{"s3://backups/host", "backups", "host", true, false},
+		{"s3://backups/host/sub/", "backups", "host/sub", true, false},
+		{"s3://backups", "backups", "", true, false},
+		{"s3:///host", "", "", true, true},
+		{"/srv/backup", "", "", false, false},
+	}
+	for _, tt := range tests {
+		bucket, prefix, ok, err := ParseURL(tt.url)
+		if bucket != tt.bucket || prefix != tt.prefix || ok != tt.ok || (err != nil) != tt.err {
+			t.Errorf("ParseURL(%q) = %q, %q, %v, %v", tt.url, bucket, prefix, ok, err)
+		}
+	}
+}
+
+func TestFS_ReadDirAndStat(t *testing.T) {
+	fsys, _ := newTestFS(
+		"host/2024-06-17_09-49/etc/passwd",
+		"host/2024-06-17_09-49/etc/group",
+		"host/2024-06-16_09-49/etc/passwd",
+		"host/README",
+	)
+
+	entries, err := fsys.ReadDir("host")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	want := []string{"2024-06-16_09-49", "2024-06-17_09-49", "README"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadDir() = %v, want %v", names, want)
+	}
+	if !entries[0].IsDir() || entries[2].IsDir() {
+		t.Errorf("unexpected entry types")
+	}
+
+	if info, err := fsys.Stat("host/2024-06-17_09-49"); err != nil || !info.IsDir() {
+		t.Errorf("Stat() of a prefix = %v, %v; want a directory", info, err)
+	}
+	if size, links, err := fsys.SizeAndLinkCount("host/README"); err != nil || size != 10 || links != 1 {
+		t.Errorf("SizeAndLinkCount() = %d, %d, %v", size, links, err)
+	}
+	if _, err := fsys.Stat("host/2024-06-17"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist for a partial name, got %v", err)
+	}
+	if _, err := fsys.ReadDir("other"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestFS_Rename(t *testing.T) {
+	fsys, client := newTestFS(
+		"host/a/1", "host/a/sub/2",
+		"host/b/1",
+		"host/to_delete/b/1",
+	)
+
+	if err := fsys.Rename("host/a", "host/to_delete/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"host/b/1", "host/to_delete/a/1", "host/to_delete/a/sub/2", "host/to_delete/b/1"}
+	if got := client.Keys("backups"); !reflect.DeepEqual(got, want) {
+		t.Errorf("objects after rename = %v, want %v", got, want)
+	}
+
+	if err := fsys.Rename("host/b", "host/to_delete/b"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("expected ENOTEMPTY, got %v", err)
+	}
+	if err := fsys.Rename("host/missing", "host/to_delete/missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+}
+
+func TestFS_RenameObject(t *testing.T) {
+	fsys, client := newTestFS("host/a.tar", "host/b.tar", "host/to_delete/b.tar")
+
+	if err := fsys.Rename("host/a.tar", "host/to_delete/a.tar"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"host/b.tar", "host/to_delete/a.tar", "host/to_delete/b.tar"}
+	if got := client.Keys("backups"); !reflect.DeepEqual(got, want) {
+		t.Errorf("objects after rename = %v, want %v", got, want)
+	}
+
+	if err := fsys.Rename("host/b.tar", "host/to_delete/b.tar"); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected ErrExist, got %v", err)
+	}
+	if got := client.Keys("backups"); !reflect.DeepEqual(got, want) {
+		t.Errorf("objects after the refused rename = %v, want %v", got, want)
+	}
+}
+
+func TestFS_RenameKeepsOriginalsOnCopyFailure(t *testing.T) {
+	fsys, client := newTestFS("host/a/1", "host/a/2")
+	injected := errors.New("injected")
+	client.Fault = func(op, key string) error {
+		if op == "copy" && key == "host/to_delete/a/2" {
+			return injected
+		}
+		return nil
+	}
+
+	if err := fsys.Rename("host/a", "host/to_delete/a"); !errors.Is(err, injected) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+	for _, k := range []string{"host/a/1", "host/a/2"} {
+		if _, err := fsys.Stat(k); err != nil {
+			t.Errorf("expected original %s to survive: %v", k, err)
+		}
+	}
+}
+
+func TestFS_RemoveAll(t *testing.T) {
+	fsys, client := newTestFS("host/a/1", "host/a/sub/2", "host/ab/1")
+	if err := fsys.RemoveAll("host/a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := client.Keys("backups"); !reflect.DeepEqual(got, []string{"host/ab/1"}) {
+		t.Errorf("objects after RemoveAll = %v", got)
+	}
+	if err := fsys.RemoveAll(""); !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("expected EBUSY removing the bucket root, got %v", err)
+	}
+}
+
+func TestFS_Prune(t *testing.T) {
+	now := time.Date(2024, 6, 17, 9, 54, 21, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name   string
+		delete bool
+		want   []string
+	}{
+		{"move", false, []string{"host/2024-06-15_23-49/data", "host/2024-06-17_09-49/data", "host/to_delete/" + retention.JournalName, "host/to_delete/" + retention.TrashIndexName, "host/to_delete/2024-06-15_13-49/data", "host/to_delete/2024-06-17_09-13/data"}},
+		{"delete", true, []string{"host/2024-06-15_23-49/data", "host/2024-06-17_09-49/data"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fsys, client := newTestFS(
+				"host/2024-06-17_09-49/data", "host/2024-06-17_09-13/data",
+				"host/2024-06-15_23-49/data", "host/2024-06-15_13-49/data",
+			)
+			dirs, err := retention.ListFS(fsys, "host")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			decisions := retention.DefaultPolicy().Plan(now, dirs)
+			if _, err := retention.Apply(decisions, retention.ApplyOptions{FS: fsys, Dir: "host", Trash: "host/to_delete", Delete: tt.delete}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := client.Keys("backups"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("objects after pruning = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFS_ReadWriteFile(t *testing.T) {
+	fsys, client := newTestFS("host/2024-06-17_09-49/data")
+
+	if err := fsys.WriteFile("host/index.json", []byte(`{"a":1}`), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := fsys.ReadFile("host/index.json")
+	if err != nil || string(data) != `{"a":1}` {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	if info, err := fsys.Stat("host/index.json"); err != nil || info.Size() != 7 {
+		t.Errorf("Stat() = %v, %v", info, err)
+	}
+	if _, err := fsys.ReadFile("host/missing.json"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected ErrNotExist, got %v", err)
+	}
+
+	client.Fault = func(op, key string) error {
+		if op == "put" {
+			return syscall.EACCES
+		}
+		return nil
+	}
+	if err := fsys.WriteFile("host/index.json", nil, 0644); !errors.Is(err, syscall.EACCES) {
+		t.Errorf("expected the injected error, got %v", err)
+	}
+}
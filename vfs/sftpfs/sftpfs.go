@@ -0,0 +1,244 @@
+// Package sftpfs implements vfs.FS on top of an SFTP connection, so that
+// backup stores only reachable via SSH can be pruned without mounting them.
+package sftpfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// FS provides access to the filesystem of a remote host via SFTP. Names are
+// converted to remote (slash separated) paths.
+type FS struct {
+	Client *sftp.Client
+	conn   *ssh.Client
+}
+
+// New returns an FS working on an existing SFTP client.
+func New(client *sftp.Client) *FS {
+	return &FS{Client: client}
+}
+
+// Location is a parsed sftp://user@host[:port]/path URL.
+type Location struct {
+	User string
+	Host string // host:port
+	Path string
+}
+
+// ParseURL parses an URL of the form sftp://user@host[:port]/path. ok is
+// false if u is not an sftp URL.
+func ParseURL(u string) (loc Location, ok bool, err error) {
+	if !strings.HasPrefix(u, "sftp://") {
+		return Location{}, false, nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return Location{}, true, err
+	}
+	if parsed.Hostname() == "" {
+		return Location{}, true, fmt.Errorf("missing host name in %s", u)
+	}
+	if _, hasPassword := parsed.User.Password(); hasPassword {
+		return Location{}, true, fmt.Errorf("passwords are not supported in %s, use key-based authentication", u)
+	}
+	loc.User = parsed.User.Username()
+	if loc.User == "" {
+		return Location{}, true, fmt.Errorf("missing user name in %s", u)
+	}
+	port := parsed.Port()
+	if port == "" {
+		port = "22"
+	}
+	loc.Host = net.JoinHostPort(parsed.Hostname(), port)
+	loc.Path = parsed.Path
+	if loc.Path == "" {
+		loc.Path = "."
+	}
+	return loc, true, nil
+}
+
+// DialOptions configures the SSH connection made by Dial.
+type DialOptions struct {
+	IdentityFiles  []string // private keys used for authentication, in addition to a running ssh-agent
+	KnownHostsFile string   // OpenSSH known_hosts file used to verify the host key
+}
+
+// DefaultDialOptions uses the usual OpenSSH files in the home directory of
+// the current user.
+func DefaultDialOptions() DialOptions {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return DialOptions{}
+	}
+	sshDir := filepath.Join(home, ".ssh")
+	return DialOptions{
+		IdentityFiles: []string{
+			filepath.Join(sshDir, "id_ed25519"),
+			filepath.Join(sshDir, "id_ecdsa"),
+			filepath.Join(sshDir, "id_rsa"),
+		},
+		KnownHostsFile: filepath.Join(sshDir, "known_hosts"),
+	}
+}
+
+// Dial connects to loc using key-based authentication. The host key must be
+// listed in opts.KnownHostsFile. Missing identity files are ignored.
+func Dial(loc Location, opts DialOptions) (*FS, error) {
+	if opts.KnownHostsFile == "" {
+		return nil, errors.New("no known_hosts file given, refusing to connect without host key verification")
+	}
+	hostKeyCallback, err := knownhosts.New(opts.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known_hosts file: %w", err)
+	}
+
+	var signers []ssh.Signer
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if agentConn, err := net.Dial("unix", sock); err == nil {
+			defer func() {
+				_ = agentConn.Close()
+			}()
+			if agentSigners, err := agent.NewClient(agentConn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	for _, file := range opts.IdentityFiles {
+		key, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading identity file: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing identity file %s: %w", file, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no private key found for SSH authentication")
+	}
+
+	conn, err := ssh.Dial("tcp", loc.Host, &ssh.ClientConfig{
+		User:            loc.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &FS{Client: client, conn: conn}, nil
+}
+
+// Close closes the SFTP session and the underlying SSH connection, if any.
+func (f *FS) Close() error {
+	err := f.Client.Close()
+	if f.conn != nil {
+		err = errors.Join(err, f.conn.Close())
+	}
+	return err
+}
+
+func remote(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	infos, err := f.Client.ReadDir(remote(name))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]fs.DirEntry, 0, len(infos))
+	for _, info := range infos {
+		result = append(result, fs.FileInfoToDirEntry(info))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+	return result, nil
+}
+
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	return f.Client.Stat(remote(name))
+}
+
+// MkdirAll creates name and all missing parents. perm is not supported by
+// the SFTP client and therefore ignored; the server's umask applies.
+func (f *FS) MkdirAll(name string, perm fs.FileMode) error {
+	return f.Client.MkdirAll(remote(name))
+}
+
+// Rename uses SFTP's rename, which fails if newpath already exists.
+func (f *FS) Rename(oldpath, newpath string) error {
+	return f.Client.Rename(remote(oldpath), remote(newpath))
+}
+
+func (f *FS) RemoveAll(name string) error {
+	return f.Client.RemoveAll(remote(name))
+}
+
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Client.Open(remote(name))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return io.ReadAll(file)
+}
+
+func (f *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	file, err := f.Client.OpenFile(remote(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return f.Client.Chmod(remote(name), perm)
+}
+
+// Lstat returns information about name without following a symlink.
+func (f *FS) Lstat(name string) (fs.FileInfo, error) {
+	return f.Client.Lstat(remote(name))
+}
+
+// Remove removes the file or empty directory name.
+func (f *FS) Remove(name string) error {
+	return f.Client.Remove(remote(name))
+}
+
+// SizeAndLinkCount returns the size of the file name. SFTP does not expose
+// the number of hard links, so the link count is always reported as 1.
+func (f *FS) SizeAndLinkCount(name string) (size, linkCount uint64, err error) {
+	info, err := f.Client.Lstat(remote(name))
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(info.Size()), 1, nil
+}
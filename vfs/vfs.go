@@ -0,0 +1,164 @@
+// Package vfs abstracts the filesystem operations used for pruning and for
+// collecting statistics, so that alternative backends can be plugged in and
+// failures can be injected in tests.
+package vfs
+
+import (
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+)
+
+// FS is the set of filesystem operations used by the retention and stats
+// packages. Names are paths in the syntax of the host operating system.
+type FS interface {
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	MkdirAll(name string, perm fs.FileMode) error
+	Rename(oldpath, newpath string) error
+	RemoveAll(name string) error
+	ReadFile(name string) ([]byte, error)
+	// WriteFile replaces the content of the regular file name, creating it
+	// if necessary. Implementations replace the file atomically and durably
+	// where the backend allows it, as it is used for journals.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// SizeAndLinkCount returns the size and the number of hard links of a regular file.
+	SizeAndLinkCount(name string) (size, linkCount uint64, err error)
+}
+
+// Remover is implemented by filesystems that can remove a single file or
+// empty directory. This allows removing large trees entry by entry, see
+// retention.RemoveTree.
+type Remover interface {
+	Remove(name string) error
+}
+
+// LinkReader is implemented by filesystems with symbolic links, so that the
+// snapshots they point to can be protected, see retention.Symlinks.
+type LinkReader interface {
+	Readlink(name string) (string, error)
+}
+
+// Lstater is implemented by filesystems with symbolic links, so that a
+// symlink can be told apart from what it points to, e.g. before removing it,
+// see retention.RemoveTree.
+type Lstater interface {
+	Lstat(name string) (fs.FileInfo, error)
+}
+
+// OS implements FS using the operating system's filesystem.
+type OS struct{}
+
+func (OS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (OS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OS) MkdirAll(name string, perm fs.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+func (OS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (OS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (OS) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (OS) Readlink(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+func (OS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+// WriteFile writes data to a temporary file next to name, syncs it to disk
+// and renames it to name, so that a crash leaves either the old or the new
+// content. The directory is synced afterwards where supported.
+func (OS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(data); err == nil {
+		if err = f.Chmod(perm); err == nil {
+			err = f.Sync()
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// syncDir makes a rename in dir durable. Errors are ignored, as not all
+// platforms can sync directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
+
+func (OS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OS) SizeAndLinkCount(name string) (size, linkCount uint64, err error) {
+	return getSizeAndLinkCount(name)
+}
+
+// OrOS returns fsys, or OS if fsys is nil.
+func OrOS(fsys FS) FS {
+	if fsys == nil {
+		return OS{}
+	}
+	return fsys
+}
+
+func openFileWithRetry(filename string, retries, maxWaitSeconds int) (*os.File, error) {
+	for range retries {
+		file, err := os.Open(filename)
+		if err != nil {
+			pathErr, ok := err.(*os.PathError)
+			if !ok {
+				return nil, fmt.Errorf("error reading file (error type %s): %v", reflect.TypeOf(err), err)
+			}
+			if pathErr.Err.Error() == "too many open files" {
+				// Wait a random time before retrying
+				rnd := rand.IntN(maxWaitSeconds * 1000)
+				time.Sleep(time.Duration(200+rnd) * time.Millisecond) // wait at least 200ms
+				continue
+			}
+			if pathErr.Err.Error() == "permission denied" {
+				return nil, pathErr.Err
+			}
+			return nil, err
+		}
+		return file, nil
+	}
+	return nil, fmt.Errorf("failed to open file after %v retries: %s", retries, filename)
+}
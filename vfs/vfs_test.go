@@ -0,0 +1,87 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenFileWithRetry(t *testing.T) {
+	tests := []struct {
+		filename        string
+		retries         int
+		maxwait_seconds int
+		expectError     bool
+	}{
+		{"../go.mod", 3, 1, false},          // Assuming go.mod exists
+		{"nonexistentfile.txt", 3, 1, true}, // Non-existent file
+		{"irrelevant", 0, 1, true},          // try 0 times -> shall return cannot open file error
+	}
+
+	for _, test := range tests {
+		file, err := openFileWithRetry(test.filename, test.retries, test.maxwait_seconds)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("expected error for file %s, but got none", test.filename)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("unexpected error for file %s: %v", test.filename, err)
+			}
+			if file != nil {
+				err2 := file.Close()
+				if err2 != nil {
+					t.Errorf("unexpected error for file %s: %v", test.filename, err2)
+				}
+			}
+		}
+	}
+}
+
+func TestGetSizeAndLinkCount(t *testing.T) {
+	tests := []struct {
+		filename    string
+		expectError bool
+	}{
+		{"../go.mod", false},          // Assuming go.mod exists
+		{"nonexistentfile.txt", true}, // Non-existent file
+	}
+
+	for _, test := range tests {
+		size, linkCount, err := getSizeAndLinkCount(test.filename)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("expected error for file %s, but got none", test.filename)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("unexpected error for file %s: %v", test.filename, err)
+			} else {
+				t.Logf("File: %s, Size: %d, Link Count: %d", test.filename, size, linkCount)
+			}
+		}
+	}
+}
+
+func TestOS_WriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "journal.json")
+	for _, content := range []string{"old", "new"} {
+		if err := (OS{}).WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	data, err := os.ReadFile(name)
+	if err != nil || string(data) != "new" {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+	if info, err := os.Stat(name); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode: %v, %v", info, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected no temporary files to remain, got %v", entries)
+	}
+	if err := (OS{}).WriteFile(filepath.Join(dir, "missing", "journal.json"), nil, 0600); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}